@@ -0,0 +1,224 @@
+package api
+
+import (
+	"embed"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"jotacoin/pkg/blockchain"
+	"jotacoin/pkg/network"
+	"jotacoin/pkg/wallet"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recentBlocks is the amount of blocks shown in the home page of the explorer
+const recentBlocks = 20
+
+//go:embed explorer
+var explorerFiles embed.FS
+
+var explorerFuncs = template.FuncMap{
+	"time": func(timestamp int64) string {
+		return time.Unix(timestamp, 0).UTC().Format("2006-01-02 15:04:05 UTC")
+	},
+}
+
+// explorerPages are the templates of the pages of the explorer, each one is rendered
+// inside the layout
+var explorerPages = map[string]*template.Template{}
+
+func init() {
+	for _, page := range []string{"index", "block", "tx", "address", "error"} {
+		explorerPages[page] = template.Must(template.New("layout.html").Funcs(explorerFuncs).
+			ParseFS(explorerFiles, "explorer/layout.html", "explorer/"+page+".html"))
+	}
+}
+
+// AddressPage is the data of the page of an address
+type AddressPage struct {
+	Address string
+	Balance int
+	UTXOs   []UTXOView
+	History []HistoryView // the newest entries first
+}
+
+// Explorer serves a web block explorer of the chain, rendered from the embedded
+// templates. The pages are:
+//
+//	GET /
+//	GET /block/{hash}
+//	GET /tx/{hash}
+//	GET /address/{address}
+//	GET /search?q={height, hash or address}
+type Explorer struct {
+	*backend
+}
+
+// NewExplorer creates the block explorer of the chain. node can be nil
+func NewExplorer(chain *blockchain.Blockchain, mempool *blockchain.Mempool, node *network.Server) *Explorer {
+	return &Explorer{&backend{Chain: chain, Mempool: mempool, Node: node}}
+}
+
+// ListenAndServe serves the explorer at addr
+func (e *Explorer) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, e)
+}
+
+// ServeHTTP renders the requested page, or an error page
+func (e *Explorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var page string
+	var data any
+	var err error
+	switch {
+	case len(path) == 1 && path[0] == "":
+		page = "index"
+		data, err = e.recentBlocks()
+	case len(path) == 2 && path[0] == "block":
+		page = "block"
+		data, err = e.block(path[1])
+	case len(path) == 2 && path[0] == "tx":
+		page = "tx"
+		data, err = e.transaction(path[1])
+	case len(path) == 2 && path[0] == "address":
+		page = "address"
+		data, err = e.address(path[1])
+	case len(path) == 1 && path[0] == "search":
+		e.search(w, r, strings.TrimSpace(r.URL.Query().Get("q")))
+		return
+	default:
+		err = notFound("page not found")
+	}
+	if err != nil {
+		e.renderError(w, err)
+		return
+	}
+	e.render(w, http.StatusOK, page, data)
+}
+
+func (e *Explorer) render(w http.ResponseWriter, status int, page string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	explorerPages[page].Execute(w, data)
+}
+
+func (e *Explorer) renderError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var httpErr *httpError
+	switch {
+	case errors.As(err, &httpErr):
+		status = httpErr.status
+	case errors.Is(err, blockchain.ErrBlockNotFound), errors.Is(err, blockchain.ErrTxNotFound):
+		status = http.StatusNotFound
+	}
+	e.render(w, status, "error", err.Error())
+}
+
+// recentBlocks returns the last blocks of the main chain, the tip first
+func (e *Explorer) recentBlocks() ([]BlockView, error) {
+	e.lock()
+	defer e.unlock()
+
+	tipHeight, err := e.Chain.Height()
+	if err != nil {
+		return nil, err
+	}
+	var views []BlockView
+	iter := e.Chain.Iterator()
+	for i := 0; i < recentBlocks && i <= tipHeight; i++ {
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, newBlockView(block, tipHeight))
+	}
+	return views, nil
+}
+
+func (e *Explorer) block(hashParam string) (*BlockView, error) {
+	hash, err := hex.DecodeString(hashParam)
+	if err != nil {
+		return nil, badRequest("invalid hash")
+	}
+
+	e.lock()
+	defer e.unlock()
+	return e.blockView(hash)
+}
+
+func (e *Explorer) transaction(hashParam string) (*TxView, error) {
+	hash, err := hex.DecodeString(hashParam)
+	if err != nil {
+		return nil, badRequest("invalid hash")
+	}
+
+	e.lock()
+	defer e.unlock()
+	return e.txView(hash)
+}
+
+func (e *Explorer) address(address string) (*AddressPage, error) {
+	if _, err := wallet.AddressToPubKeyHash(address); err != nil {
+		return nil, badRequest("invalid address")
+	}
+
+	e.lock()
+	utxos, err := e.Chain.ListUTXO(address)
+	e.unlock()
+	if err != nil {
+		return nil, err
+	}
+	history, err := e.historyPage(address, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &AddressPage{Address: address}
+	for _, utxo := range utxos {
+		page.Balance += utxo.Output.Value
+		page.UTXOs = append(page.UTXOs, UTXOView{hex.EncodeToString(utxo.TxHash), utxo.OutIdx, utxo.Output.Value})
+	}
+	for i := len(history.Entries) - 1; i >= 0; i-- {
+		page.History = append(page.History, history.Entries[i])
+	}
+	return page, nil
+}
+
+// search redirects to the block at a height, to the block or transaction with a hash
+// or to an address
+func (e *Explorer) search(w http.ResponseWriter, r *http.Request, query string) {
+	target := ""
+	if height, err := strconv.Atoi(query); err == nil {
+		e.lock()
+		hash, err := e.Chain.BlockHashAt(height)
+		e.unlock()
+		if err == nil {
+			target = "/block/" + hex.EncodeToString(hash)
+		}
+	} else if _, err := wallet.AddressToPubKeyHash(query); err == nil {
+		target = "/address/" + url.PathEscape(query)
+	} else if hash, err := hex.DecodeString(query); err == nil {
+		e.lock()
+		if _, err := e.Chain.GetBlock(hash); err == nil {
+			target = "/block/" + query
+		} else if _, err := e.txView(hash); err == nil {
+			target = "/tx/" + query
+		}
+		e.unlock()
+	}
+
+	if target == "" {
+		e.renderError(w, notFound("nothing found for "+strconv.Quote(query)))
+		return
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
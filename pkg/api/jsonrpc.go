@@ -0,0 +1,254 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"jotacoin/pkg/blockchain"
+	"jotacoin/pkg/network"
+	"net/http"
+	"sync"
+)
+
+// maxRequestSize is the maximum size of the body of a request
+const maxRequestSize = 1 << 20
+
+// Error codes of the JSON-RPC 2.0 specification and of this server
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	// CodeNotFound is returned when the block, transaction or wallet doesn't exist
+	CodeNotFound = -32001
+	// CodeRejected is returned when a transaction can't be created or it's rejected
+	CodeRejected = -32002
+)
+
+// Error is the error object of a JSON-RPC response
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc: %s (code %d)", e.Message, e.Code)
+}
+
+func newError(code int, format string, args ...any) *Error {
+	return &Error{code, fmt.Sprintf(format, args...)}
+}
+
+// Request is a JSON-RPC 2.0 request. A request without ID is a notification, which
+// doesn't get a response
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// Response is a JSON-RPC 2.0 response, it has either a result or an error
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type handler func(s *Server, params json.RawMessage) (any, error)
+
+// backend gives access to the chain and the mempool, which are shared by the APIs
+type backend struct {
+	Chain   *blockchain.Blockchain
+	Mempool *blockchain.Mempool
+	// Node relays the transactions sent through the API, if it's nil the transactions
+	// are only added into the mempool
+	Node *network.Server
+
+	mu sync.Mutex // locks the chain when there isn't a node
+}
+
+// lock locks the chain and the mempool, using the lock of the node if there is one
+func (b *backend) lock() {
+	if b.Node != nil {
+		b.Node.Lock()
+		return
+	}
+	b.mu.Lock()
+}
+
+func (b *backend) unlock() {
+	if b.Node != nil {
+		b.Node.Unlock()
+		return
+	}
+	b.mu.Unlock()
+}
+
+// Server serves the JSON-RPC API of the node and of its wallets over HTTP. The params of
+// the methods are positional (a JSON array)
+type Server struct {
+	*backend
+
+	walletMu sync.Mutex // serializes the changes of the wallets file
+}
+
+// NewServer creates the API of the chain. node can be nil
+func NewServer(chain *blockchain.Blockchain, mempool *blockchain.Mempool, node *network.Server) *Server {
+	return &Server{backend: &backend{Chain: chain, Mempool: mempool, Node: node}}
+}
+
+// REST returns the read-only REST API of the same chain
+func (s *Server) REST() *REST {
+	return &REST{s.backend}
+}
+
+// Explorer returns the web block explorer of the same chain
+func (s *Server) Explorer() *Explorer {
+	return &Explorer{s.backend}
+}
+
+// ListenAndServe serves the API at addr, which should be a localhost address because
+// the API has access to the wallets
+func (s *Server) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, s)
+}
+
+// ServeHTTP handles a JSON-RPC request, or a batch of requests, sent with POST
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var result any
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		result = s.handleBatch(body)
+	} else if response := s.handleRequest(body); response != nil {
+		result = response
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if result == nil {
+		// only notifications, which don't get a response
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *Server) handleBatch(body []byte) any {
+	var requests []json.RawMessage
+	err := json.Unmarshal(body, &requests)
+	if err != nil {
+		return errorResponse(nil, newError(CodeParseError, "parse error: %v", err))
+	}
+	if len(requests) == 0 {
+		return errorResponse(nil, newError(CodeInvalidRequest, "empty batch"))
+	}
+
+	var responses []*Response
+	for _, request := range requests {
+		response := s.handleRequest(request)
+		if response != nil {
+			responses = append(responses, response)
+		}
+	}
+	if len(responses) == 0 {
+		return nil
+	}
+	return responses
+}
+
+// handleRequest handles a single request, returning nil if it's a notification
+func (s *Server) handleRequest(body []byte) *Response {
+	var req Request
+	err := json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(nil, newError(CodeParseError, "parse error: %v", err))
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return errorResponse(req.ID, newError(CodeInvalidRequest, "invalid request"))
+	}
+
+	result, err := s.call(req.Method, req.Params)
+	if req.ID == nil {
+		return nil
+	}
+	if err != nil {
+		return errorResponse(req.ID, toError(err))
+	}
+	encodedResult, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(req.ID, newError(CodeInternalError, "%v", err))
+	}
+	return &Response{JSONRPC: "2.0", Result: encodedResult, ID: req.ID}
+}
+
+// call calls the method with the params passed as argument
+func (s *Server) call(method string, params json.RawMessage) (any, error) {
+	h, ok := handlers[method]
+	if !ok {
+		return nil, newError(CodeMethodNotFound, "method %q not found", method)
+	}
+	return h(s, params)
+}
+
+func errorResponse(id json.RawMessage, err *Error) *Response {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &Response{JSONRPC: "2.0", Error: err, ID: id}
+}
+
+// toError converts the errors returned by the handlers into JSON-RPC errors
+func toError(err error) *Error {
+	var rpcErr *Error
+	var validationErr *blockchain.ValidationError
+	switch {
+	case errors.As(err, &rpcErr):
+		return rpcErr
+	case errors.As(err, &validationErr), errors.Is(err, blockchain.ErrTxInMempool),
+		errors.Is(err, blockchain.ErrMempoolConflict), errors.Is(err, blockchain.ErrMempoolFull):
+		return newError(CodeRejected, "%v", err)
+	case errors.Is(err, blockchain.ErrBlockNotFound), errors.Is(err, blockchain.ErrTxNotFound):
+		return newError(CodeNotFound, "%v", err)
+	default:
+		return newError(CodeInternalError, "%v", err)
+	}
+}
+
+// parseParams decodes the positional params into dst. The first required params are
+// mandatory, the rest are optional
+func parseParams(params json.RawMessage, required int, dst ...any) error {
+	var values []json.RawMessage
+	if len(params) > 0 && string(params) != "null" {
+		err := json.Unmarshal(params, &values)
+		if err != nil {
+			return newError(CodeInvalidParams, "params must be an array")
+		}
+	}
+	if len(values) < required || len(values) > len(dst) {
+		return newError(CodeInvalidParams, "expected between %d and %d params, got %d",
+			required, len(dst), len(values))
+	}
+
+	for i, value := range values {
+		err := json.Unmarshal(value, dst[i])
+		if err != nil {
+			return newError(CodeInvalidParams, "param %d: %v", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,404 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"jotacoin/pkg/blockchain"
+	"jotacoin/pkg/wallet"
+	"sort"
+)
+
+var handlers = map[string]handler{
+	"getbalance":         getBalance,
+	"gethistory":         getHistory,
+	"getblock":           getBlock,
+	"getblockhash":       getBlockHash,
+	"getblockcount":      getBlockCount,
+	"gettransaction":     getTransaction,
+	"gettx":              getTx,
+	"sendtransaction":    sendTransaction,
+	"sendrawtransaction": sendRawTransaction,
+	"listwallets":        listWallets,
+	"newaddress":         newAddress,
+	"getmempoolinfo":     getMempoolInfo,
+	"getchaininfo":       getChainInfo,
+}
+
+// WalletInfo is the result of listwallets
+type WalletInfo struct {
+	Address string `json:"address"`
+	Balance int    `json:"balance"`
+}
+
+// SendResult is the result of sendtransaction and sendrawtransaction
+type SendResult struct {
+	Hash string `json:"hash"`
+	Fee  int    `json:"fee"`
+}
+
+// MempoolInfo is the result of getmempoolinfo
+type MempoolInfo struct {
+	Size  int `json:"size"`  // amount of transactions
+	Bytes int `json:"bytes"` // size of the transactions
+	Fees  int `json:"fees"`
+}
+
+// ChainInfo is the result of getchaininfo
+type ChainInfo struct {
+	Height         int    `json:"height"`
+	BestHash       string `json:"besthash"`
+	Bits           int    `json:"bits"`
+	NextBits       int    `json:"nextbits"`
+	ChainWork      string `json:"chainwork"` // hexadecimal
+	Headers        int    `json:"headers"`   // height of the best header
+	Subsidy        int    `json:"subsidy"`   // subsidy of the next block
+	Supply         int    `json:"supply"`
+	TxIndex        bool   `json:"txindex"` // whether the transaction index is enabled
+	MempoolSize    int    `json:"mempoolsize"`
+	Peers          int    `json:"peers"`
+	InitialSyncing bool   `json:"initialsyncing"`
+}
+
+func decodeHash(value string) ([]byte, error) {
+	hash, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, newError(CodeInvalidParams, "invalid hash %q", value)
+	}
+	return hash, nil
+}
+
+func checkAddress(address string) error {
+	if _, err := wallet.AddressToPubKeyHash(address); err != nil {
+		return newError(CodeInvalidParams, "invalid address %q", address)
+	}
+	return nil
+}
+
+// getBalance returns the balance of an address: [address]
+func getBalance(s *Server, params json.RawMessage) (any, error) {
+	var address string
+	err := parseParams(params, 1, &address)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkAddress(address); err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+	return s.Chain.GetBalance(address), nil
+}
+
+// getHistory returns a page of the history of an address, in chronological order and
+// with the balance after each transaction: [address, skip (optional), count (optional)]
+func getHistory(s *Server, params json.RawMessage) (any, error) {
+	var address string
+	skip, count := 0, defaultHistoryCount
+	err := parseParams(params, 1, &address, &skip, &count)
+	if err != nil {
+		return nil, err
+	}
+	if skip < 0 || count < 0 {
+		return nil, newError(CodeInvalidParams, "skip and count can't be negative")
+	}
+	if err = checkAddress(address); err != nil {
+		return nil, err
+	}
+	return s.historyPage(address, skip, count)
+}
+
+// getBlock returns a block: [hash]. The blocks out of the main chain have -1
+// confirmations
+func getBlock(s *Server, params json.RawMessage) (any, error) {
+	var hashParam string
+	err := parseParams(params, 1, &hashParam)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := decodeHash(hashParam)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+	return s.blockView(hash)
+}
+
+// getBlockHash returns the hash of the block of the main chain at a height: [height]
+func getBlockHash(s *Server, params json.RawMessage) (any, error) {
+	var height int
+	err := parseParams(params, 1, &height)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+	hash, err := s.Chain.BlockHashAt(height)
+	if err != nil {
+		return nil, err
+	}
+	return hex.EncodeToString(hash), nil
+}
+
+// getBlockCount returns the height of the tip of the main chain: []
+func getBlockCount(s *Server, params json.RawMessage) (any, error) {
+	err := parseParams(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+	return s.Chain.Height()
+}
+
+// getTransaction returns a transaction of the main chain or of the mempool: [hash]
+func getTransaction(s *Server, params json.RawMessage) (any, error) {
+	var hashParam string
+	err := parseParams(params, 1, &hashParam)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := decodeHash(hashParam)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+	return s.txView(hash)
+}
+
+// getTx returns a transaction of the main chain and its confirmations: [hash]. It's
+// fast if the transaction index is enabled
+func getTx(s *Server, params json.RawMessage) (any, error) {
+	var hashParam string
+	err := parseParams(params, 1, &hashParam)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := decodeHash(hashParam)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+	tx, block, err := s.Chain.FindTransaction(hash)
+	if err != nil {
+		return nil, err
+	}
+	tipHeight, err := s.Chain.Height()
+	if err != nil {
+		return nil, err
+	}
+	return newTxView(tx, block, tipHeight), nil
+}
+
+// sendTransaction creates a transaction signed by a wallet and sends it to the network:
+// [from, to, amount, fee (optional), fee rate per 1000 bytes (optional), sighash type
+// (optional, ALL by default)]
+func sendTransaction(s *Server, params json.RawMessage) (any, error) {
+	var from, to string
+	var amount int
+	var opts blockchain.TxOptions
+	sigHash := "ALL"
+	err := parseParams(params, 3, &from, &to, &amount, &opts.Fee, &opts.FeeRate, &sigHash)
+	if err != nil {
+		return nil, err
+	}
+	opts.SigHashType, err = blockchain.ParseSigHashType(sigHash)
+	if err != nil {
+		return nil, newError(CodeInvalidParams, "%v", err)
+	}
+	if err = checkAddress(to); err != nil {
+		return nil, err
+	}
+
+	// NewTransaction loads the wallets file to sign
+	s.walletMu.Lock()
+	s.lock()
+	tx, err := blockchain.NewTransaction(from, to, amount, opts, s.Chain)
+	s.unlock()
+	s.walletMu.Unlock()
+	if err != nil {
+		return nil, newError(CodeRejected, "%v", err)
+	}
+	return s.submit(tx)
+}
+
+// sendRawTransaction sends to the network a signed transaction, serialized in hex (e.g.
+// a finalized PSBT): [transaction]
+func sendRawTransaction(s *Server, params json.RawMessage) (any, error) {
+	var txHex string
+	err := parseParams(params, 1, &txHex)
+	if err != nil {
+		return nil, err
+	}
+	serializedTx, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, newError(CodeInvalidParams, "invalid transaction: %v", err)
+	}
+	tx, err := blockchain.DeserializeTransaction(serializedTx)
+	if err != nil {
+		return nil, newError(CodeInvalidParams, "invalid transaction: %v", err)
+	}
+	return s.submit(tx)
+}
+
+// submit validates the transaction and adds it into the mempool, relaying it if there
+// is a node
+func (s *Server) submit(tx *blockchain.Transaction) (any, error) {
+	var fee int
+	var err error
+	if s.Node != nil {
+		// the node locks the chain by itself
+		fee, err = s.Node.SubmitTransaction(tx)
+	} else {
+		s.lock()
+		fee, err = s.Mempool.Add(tx)
+		s.unlock()
+	}
+	if err != nil {
+		return nil, newError(CodeRejected, "%v", err)
+	}
+	return SendResult{hex.EncodeToString(tx.HashID), fee}, nil
+}
+
+// listWallets returns the addresses of the wallets and their balances: []
+func listWallets(s *Server, params json.RawMessage) (any, error) {
+	err := parseParams(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	s.walletMu.Lock()
+	wallets, err := wallet.LoadFile()
+	s.walletMu.Unlock()
+	if err != nil {
+		// there are no wallets yet
+		return []WalletInfo{}, nil
+	}
+
+	addresses := wallets.GetAllAddresses()
+	sort.Strings(addresses)
+
+	s.lock()
+	defer s.unlock()
+	infos := []WalletInfo{}
+	for _, address := range addresses {
+		infos = append(infos, WalletInfo{address, s.Chain.GetBalance(address)})
+	}
+	return infos, nil
+}
+
+// newAddress creates a new wallet and returns its address: []
+func newAddress(s *Server, params json.RawMessage) (any, error) {
+	err := parseParams(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	s.walletMu.Lock()
+	defer s.walletMu.Unlock()
+	wallets, err := wallet.LoadFile()
+	if err != nil {
+		wallets = wallet.Wallets{}
+	}
+	address, err := wallets.AddWallet()
+	if err != nil {
+		return nil, err
+	}
+	err = wallets.SaveFile()
+	if err != nil {
+		return nil, err
+	}
+	return address, nil
+}
+
+// getMempoolInfo returns the size of the mempool: []
+func getMempoolInfo(s *Server, params json.RawMessage) (any, error) {
+	err := parseParams(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+	info := MempoolInfo{}
+	for _, entry := range s.Mempool.Entries() {
+		info.Size++
+		info.Bytes += entry.Size
+		info.Fees += entry.Fee
+	}
+	return info, nil
+}
+
+// getChainInfo returns the state of the chain: []
+func getChainInfo(s *Server, params json.RawMessage) (any, error) {
+	err := parseParams(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	info := ChainInfo{Height: -1, Headers: -1, MempoolSize: s.Mempool.Count()}
+	err = s.chainInfo(&info)
+	s.unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Node != nil {
+		progress, err := s.Node.SyncProgress()
+		if err != nil {
+			return nil, err
+		}
+		info.Peers = progress.Peers
+		info.InitialSyncing = !progress.Synced()
+	}
+	return info, nil
+}
+
+func (s *Server) chainInfo(info *ChainInfo) error {
+	header, _, err := s.Chain.BestHeader()
+	if err != nil || header == nil {
+		return err
+	}
+	info.Headers = header.Height
+
+	height, err := s.Chain.Height()
+	if err != nil {
+		return err
+	}
+	tip, err := s.Chain.GetBlock(s.Chain.LastHash)
+	if err != nil {
+		return err
+	}
+	nextBits, err := s.Chain.NextDifficulty()
+	if err != nil {
+		return err
+	}
+	work, err := s.Chain.ChainWork()
+	if err != nil {
+		return err
+	}
+
+	txIndex, err := s.Chain.TxIndexEnabled()
+	if err != nil {
+		return err
+	}
+
+	info.Height = height
+	info.TxIndex = txIndex
+	info.BestHash = hex.EncodeToString(tip.Hash)
+	info.Bits = tip.Header.Bits
+	info.NextBits = nextBits
+	info.ChainWork = work.Text(16)
+	info.Subsidy = blockchain.BlockSubsidy(height + 1)
+	info.Supply = blockchain.Supply(height)
+	return nil
+}
@@ -0,0 +1,301 @@
+package api
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"jotacoin/pkg/blockchain"
+	"jotacoin/pkg/network"
+	"jotacoin/pkg/wallet"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// TipView is the JSON representation of the tip of the chain
+type TipView struct {
+	Height int    `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+// UTXOView is the JSON representation of an unspent output
+type UTXOView struct {
+	TxHash string `json:"txhash"`
+	OutIdx int    `json:"outidx"`
+	Value  int    `json:"value"`
+}
+
+// HistoryView is the JSON representation of an entry of the history of an address
+type HistoryView struct {
+	TxHash    string `json:"txhash"`
+	BlockHash string `json:"blockhash"`
+	Height    int    `json:"height"`
+	Received  int    `json:"received"`
+	Sent      int    `json:"sent"`
+	Balance   int    `json:"balance"` // balance after the transaction
+}
+
+// HistoryPage is a page of the history of an address, in chronological order
+type HistoryPage struct {
+	Total   int           `json:"total"` // amount of entries of the whole history
+	Entries []HistoryView `json:"entries"`
+}
+
+func newHistoryView(entry blockchain.HistoryEntry) HistoryView {
+	return HistoryView{
+		TxHash:    hex.EncodeToString(entry.TxHash),
+		BlockHash: hex.EncodeToString(entry.BlockHash),
+		Height:    entry.Height,
+		Received:  entry.Received,
+		Sent:      entry.Sent,
+		Balance:   entry.Balance,
+	}
+}
+
+// historyPage returns a page of the history of an address, see Blockchain.AddressHistory
+func (b *backend) historyPage(address string, skip, count int) (*HistoryPage, error) {
+	b.lock()
+	history, total, err := b.Chain.AddressHistory(address, skip, count)
+	b.unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	page := &HistoryPage{Total: total, Entries: []HistoryView{}}
+	for _, entry := range history {
+		page.Entries = append(page.Entries, newHistoryView(entry))
+	}
+	return page, nil
+}
+
+// REST serves a read-only JSON API of the chain over HTTP, without access to the
+// wallets. The routes are:
+//
+//	GET /tip
+//	GET /blocks/{hash}
+//	GET /blocks/height/{height}
+//	GET /tx/{hash}
+//	GET /address/{address}/utxos
+//	GET /address/{address}/history?skip={skip}&count={count}
+//
+// The history is paginated, by default it returns the first 100 entries
+type REST struct {
+	*backend
+}
+
+// NewREST creates the REST API of the chain. node can be nil
+func NewREST(chain *blockchain.Blockchain, mempool *blockchain.Mempool, node *network.Server) *REST {
+	return &REST{&backend{Chain: chain, Mempool: mempool, Node: node}}
+}
+
+// ListenAndServe serves the API at addr
+func (r *REST) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, r)
+}
+
+// httpError is an error with the HTTP status of the response
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+func notFound(msg string) error {
+	return &httpError{http.StatusNotFound, msg}
+}
+
+func badRequest(msg string) error {
+	return &httpError{http.StatusBadRequest, msg}
+}
+
+// defaultHistoryCount is the amount of entries of a page of the history of an address if
+// the request doesn't specify it
+const defaultHistoryCount = 100
+
+// ServeHTTP routes the request and writes the result (or the error) as JSON
+func (r *REST) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "only GET is allowed"})
+		return
+	}
+
+	result, err := r.route(strings.Split(strings.Trim(req.URL.Path, "/"), "/"), req.URL.Query())
+	if err != nil {
+		status := http.StatusInternalServerError
+		var httpErr *httpError
+		switch {
+		case errors.As(err, &httpErr):
+			status = httpErr.status
+		case errors.Is(err, blockchain.ErrBlockNotFound), errors.Is(err, blockchain.ErrTxNotFound):
+			status = http.StatusNotFound
+		}
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (r *REST) route(path []string, query url.Values) (any, error) {
+	switch {
+	case len(path) == 1 && path[0] == "tip":
+		return r.tip()
+	case len(path) == 2 && path[0] == "blocks":
+		return r.block(path[1])
+	case len(path) == 3 && path[0] == "blocks" && path[1] == "height":
+		height, err := strconv.Atoi(path[2])
+		if err != nil {
+			return nil, badRequest("invalid height")
+		}
+		return r.blockAt(height)
+	case len(path) == 2 && path[0] == "tx":
+		return r.transaction(path[1])
+	case len(path) == 3 && path[0] == "address" && path[2] == "utxos":
+		return r.utxos(path[1])
+	case len(path) == 3 && path[0] == "address" && path[2] == "history":
+		return r.history(path[1], query)
+	default:
+		return nil, notFound("route not found")
+	}
+}
+
+func (r *REST) tip() (any, error) {
+	r.lock()
+	defer r.unlock()
+
+	height, err := r.Chain.Height()
+	if err != nil {
+		return nil, err
+	}
+	return TipView{height, hex.EncodeToString(r.Chain.LastHash)}, nil
+}
+
+func (r *REST) block(hashParam string) (any, error) {
+	hash, err := hex.DecodeString(hashParam)
+	if err != nil {
+		return nil, badRequest("invalid hash")
+	}
+
+	r.lock()
+	defer r.unlock()
+	return r.blockView(hash)
+}
+
+func (r *REST) blockAt(height int) (any, error) {
+	r.lock()
+	defer r.unlock()
+
+	hash, err := r.Chain.BlockHashAt(height)
+	if err != nil {
+		return nil, err
+	}
+	return r.blockView(hash)
+}
+
+// blockView returns the view of the block, the blocks out of the main chain have -1
+// confirmations. The chain must be locked
+func (b *backend) blockView(hash []byte) (*BlockView, error) {
+	block, err := b.Chain.GetBlock(hash)
+	if err != nil {
+		return nil, blockchain.ErrBlockNotFound
+	}
+	tipHeight, err := b.Chain.Height()
+	if err != nil {
+		return nil, err
+	}
+
+	view := newBlockView(block, tipHeight)
+	mainHash, err := b.Chain.BlockHashAt(block.Header.Height)
+	if err != nil || !bytes.Equal(mainHash, block.Hash) {
+		view.Confirmations = -1
+	}
+	return &view, nil
+}
+
+// txView returns the view of a transaction of the mempool or of the main chain. The
+// chain must be locked
+func (b *backend) txView(hash []byte) (*TxView, error) {
+	if tx := b.Mempool.Get(hash); tx != nil {
+		view := newTxView(tx, nil, 0)
+		return &view, nil
+	}
+
+	tx, block, err := b.Chain.FindTransaction(hash)
+	if err != nil {
+		return nil, err
+	}
+	tipHeight, err := b.Chain.Height()
+	if err != nil {
+		return nil, err
+	}
+	view := newTxView(tx, block, tipHeight)
+	return &view, nil
+}
+
+func (r *REST) transaction(hashParam string) (any, error) {
+	hash, err := hex.DecodeString(hashParam)
+	if err != nil {
+		return nil, badRequest("invalid hash")
+	}
+
+	r.lock()
+	defer r.unlock()
+	return r.txView(hash)
+}
+
+func (r *REST) utxos(address string) (any, error) {
+	if _, err := wallet.AddressToPubKeyHash(address); err != nil {
+		return nil, badRequest("invalid address")
+	}
+
+	r.lock()
+	utxos, err := r.Chain.ListUTXO(address)
+	r.unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	views := []UTXOView{}
+	for _, utxo := range utxos {
+		views = append(views, UTXOView{hex.EncodeToString(utxo.TxHash), utxo.OutIdx, utxo.Output.Value})
+	}
+	return views, nil
+}
+
+func (r *REST) history(address string, query url.Values) (any, error) {
+	if _, err := wallet.AddressToPubKeyHash(address); err != nil {
+		return nil, badRequest("invalid address")
+	}
+	skip, err := intParam(query, "skip", 0)
+	if err != nil {
+		return nil, err
+	}
+	count, err := intParam(query, "count", defaultHistoryCount)
+	if err != nil {
+		return nil, err
+	}
+	return r.historyPage(address, skip, count)
+}
+
+// intParam returns the non-negative integer of the query parameter, or def if it's missing
+func intParam(query url.Values, name string, def int) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, badRequest("invalid " + name)
+	}
+	return n, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/hex"
+	"jotacoin/pkg/blockchain"
+	"jotacoin/pkg/wallet"
+)
+
+// BlockView is the JSON representation of a block
+type BlockView struct {
+	Hash          string   `json:"hash"`
+	Version       int      `json:"version"`
+	Height        int      `json:"height"`
+	Timestamp     int64    `json:"timestamp"`
+	PrevHash      string   `json:"prevhash"`
+	MerkleRoot    string   `json:"merkleroot"`
+	Bits          int      `json:"bits"`
+	Nonce         uint32   `json:"nonce"`
+	Confirmations int      `json:"confirmations"`
+	Transactions  []TxView `json:"transactions"`
+}
+
+// TxView is the JSON representation of a transaction. A transaction of the mempool
+// doesn't have a block and has 0 confirmations
+type TxView struct {
+	Hash          string         `json:"hash"`
+	Version       int            `json:"version"`
+	Size          int            `json:"size"`
+	Coinbase      bool           `json:"coinbase"`
+	Inputs        []TxInputView  `json:"inputs"`
+	Outputs       []TxOutputView `json:"outputs"`
+	BlockHash     string         `json:"blockhash,omitempty"`
+	BlockHeight   int            `json:"blockheight,omitempty"`
+	Confirmations int            `json:"confirmations"`
+}
+
+// TxInputView is the JSON representation of a transaction input. Address is the address
+// of the public key and SigHash the type of the signature, both are empty in the coinbase
+type TxInputView struct {
+	PrevTxHash string `json:"prevtxhash"`
+	OutIdx     int    `json:"outidx"`
+	Signature  string `json:"signature"`
+	SigHash    string `json:"sighash,omitempty"`
+	PubKey     string `json:"pubkey"`
+	Script     string `json:"script,omitempty"`
+	Address    string `json:"address,omitempty"`
+}
+
+// TxOutputView is the JSON representation of a transaction output
+type TxOutputView struct {
+	Value      int    `json:"value"`
+	PubKeyHash string `json:"pubkeyhash"`
+	Script     string `json:"script,omitempty"`
+	Address    string `json:"address,omitempty"`
+}
+
+// newBlockView creates the view of a block of the main chain whose tip is at tipHeight
+func newBlockView(b *blockchain.Block, tipHeight int) BlockView {
+	confirmations := tipHeight - b.Header.Height + 1
+	view := BlockView{
+		Hash:          hex.EncodeToString(b.Hash),
+		Version:       b.Header.Version,
+		Height:        b.Header.Height,
+		Timestamp:     b.Header.Timestamp,
+		PrevHash:      hex.EncodeToString(b.Header.PrevHash),
+		MerkleRoot:    hex.EncodeToString(b.Header.MerkleRoot),
+		Bits:          b.Header.Bits,
+		Nonce:         b.Header.Nonce,
+		Confirmations: confirmations,
+	}
+	for _, tx := range b.Transactions {
+		view.Transactions = append(view.Transactions, newTxView(tx, b, tipHeight))
+	}
+	return view
+}
+
+// newTxView creates the view of a transaction, b is the block of the main chain that
+// contains it or nil if it's in the mempool
+func newTxView(tx *blockchain.Transaction, b *blockchain.Block, tipHeight int) TxView {
+	size, _ := tx.Size()
+	view := TxView{
+		Hash:     hex.EncodeToString(tx.HashID),
+		Version:  tx.Version,
+		Size:     size,
+		Coinbase: tx.IsCoinbase(),
+	}
+	if b != nil {
+		view.BlockHash = hex.EncodeToString(b.Hash)
+		view.BlockHeight = b.Header.Height
+		view.Confirmations = tipHeight - b.Header.Height + 1
+	}
+
+	for _, in := range tx.Inputs {
+		inView := TxInputView{
+			PrevTxHash: hex.EncodeToString(in.PrevTxHash),
+			OutIdx:     in.OutIdx,
+			Signature:  hex.EncodeToString(in.Signature),
+			PubKey:     hex.EncodeToString(in.PubKey),
+		}
+		if in.Script != nil {
+			inView.Script, _ = blockchain.DisasmScript(in.Script)
+		} else if !view.Coinbase && tx.Version != blockchain.LegacyTxVersion {
+			inView.SigHash = in.SigHashType().String()
+		}
+		if !view.Coinbase && in.Script == nil {
+			if pubKeyHash, err := wallet.PublicKeyHash(in.PubKey); err == nil {
+				inView.Address = wallet.PubKeyHashToAddress(pubKeyHash)
+			}
+		}
+		view.Inputs = append(view.Inputs, inView)
+	}
+	for _, out := range tx.Outputs {
+		outView := TxOutputView{
+			Value:      out.Value,
+			PubKeyHash: hex.EncodeToString(out.AddressHash()),
+			Address:    out.Address(),
+		}
+		if out.Script != nil {
+			outView.Script, _ = blockchain.DisasmScript(out.Script)
+		}
+		view.Outputs = append(view.Outputs, outView)
+	}
+	return view
+}
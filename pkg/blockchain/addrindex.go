@@ -0,0 +1,228 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"jotacoin/pkg/database"
+	"jotacoin/pkg/utils"
+)
+
+const (
+	// addrIndexPrefix is the prefix of the keys that map an address (see addressKey), a
+	// height and the position of a transaction in its block to what the transaction
+	// received and sent from the address. The keys of an address are sorted
+	// chronologically
+	addrIndexPrefix = "addrindex-"
+	positionLength  = 4
+)
+
+// HistoryEntry is a transaction of the main chain that pays to or spends from an address
+type HistoryEntry struct {
+	TxHash    []byte
+	BlockHash []byte
+	Height    int
+	Received  int // sum of the outputs locked to the address
+	Sent      int // sum of the outputs locked to the address that are spent
+	Balance   int // balance of the address after the transaction, it isn't stored
+}
+
+// addressEntry is the entry of the address index of an address key for the transaction
+// at a position of its block
+type addressEntry struct {
+	addrKey  []byte
+	position int
+	entry    HistoryEntry
+}
+
+func addrIndexPrefixOf(addrKey []byte) []byte {
+	return append([]byte(addrIndexPrefix), addrKey...)
+}
+
+func addrIndexKey(addrKey []byte, height, position int) []byte {
+	key := make([]byte, 2*positionLength)
+	binary.BigEndian.PutUint32(key, uint32(height))
+	binary.BigEndian.PutUint32(key[positionLength:], uint32(position))
+	return append(addrIndexPrefixOf(addrKey), key...)
+}
+
+// addressEntries returns the entries of the address index of the block, given the
+// outputs spent by its inputs in order (its undo data)
+func addressEntries(b *Block, spent []TxOutput) ([]addressEntry, error) {
+	var entries []addressEntry
+	for position, tx := range b.Transactions {
+		byAddrKey := make(map[string]*HistoryEntry)
+		entryOf := func(addrKey []byte) *HistoryEntry {
+			entry, ok := byAddrKey[string(addrKey)]
+			if !ok {
+				entry = &HistoryEntry{TxHash: tx.HashID, BlockHash: b.Hash, Height: b.Header.Height}
+				byAddrKey[string(addrKey)] = entry
+			}
+			return entry
+		}
+
+		if !tx.IsCoinbase() {
+			for range tx.Inputs {
+				if len(spent) == 0 {
+					return nil, fmt.Errorf("addrindex: spent outputs of block %x are incomplete", b.Hash)
+				}
+				entryOf(spent[0].addressKey()).Sent += spent[0].Value
+				spent = spent[1:]
+			}
+		}
+		for _, out := range tx.Outputs {
+			entryOf(out.addressKey()).Received += out.Value
+		}
+
+		for addrKey, entry := range byAddrKey {
+			entries = append(entries, addressEntry{[]byte(addrKey), position, *entry})
+		}
+	}
+	return entries, nil
+}
+
+func writeAddressEntries(set func(key, val []byte) error, entries []addressEntry) error {
+	for _, e := range entries {
+		serializedEntry, err := utils.Serialize(e.entry)
+		if err != nil {
+			return err
+		}
+		err = set(addrIndexKey(e.addrKey, e.entry.Height, e.position), serializedEntry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexAddresses adds the transactions of a block connected to the main chain into the
+// address index. It must be called after the block is applied to the UTXO set
+func indexAddresses(txn database.Txn, b *Block) error {
+	spent, err := getUndo(txn, b.Hash)
+	if err != nil {
+		return err
+	}
+	entries, err := addressEntries(b, spent)
+	if err != nil {
+		return err
+	}
+	return writeAddressEntries(txn.Set, entries)
+}
+
+// unindexAddresses removes the transactions of a block disconnected from the main chain
+// from the address index
+func unindexAddresses(txn database.Txn, b *Block) error {
+	spent, err := getUndo(txn, b.Hash)
+	if err != nil {
+		return err
+	}
+	entries, err := addressEntries(b, spent)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		err = txn.Delete(addrIndexKey(e.addrKey, e.entry.Height, e.position))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddressHistory returns the transactions of the main chain that pay to or spend from
+// the address, in chronological order, with the balance after each one. The
+// first skip entries are left out and at most count entries are returned (all of them
+// if count is 0). The total amount of entries is also returned
+func (chain *Blockchain) AddressHistory(address string, skip, count int) ([]HistoryEntry, int, error) {
+	var history []HistoryEntry
+	total := 0
+
+	addrKey, err := addressKey(address)
+	if err != nil {
+		return nil, 0, err
+	}
+	err = chain.DB.View(func(txn database.Txn) error {
+		prefix := addrIndexPrefixOf(addrKey)
+		balance := 0
+		return txn.Iterate(prefix, func(key, val []byte) error {
+			// the prefix may also match a longer hash
+			if len(key) != len(prefix)+2*positionLength {
+				return nil
+			}
+			total++
+			// the entries after the page are only counted
+			if count > 0 && total > skip+count {
+				return nil
+			}
+
+			var entry HistoryEntry
+			decoder := gob.NewDecoder(bytes.NewReader(val))
+			err := decoder.Decode(&entry)
+			if err != nil {
+				return err
+			}
+			balance += entry.Received - entry.Sent
+			if total > skip {
+				entry.Balance = balance
+				history = append(history, entry)
+			}
+			return nil
+		})
+	})
+	return history, total, err
+}
+
+// ReindexAddresses rebuilds the whole address index going through all the blocks of the
+// main chain
+func (chain *Blockchain) ReindexAddresses() error {
+	err := chain.DB.DropPrefix([]byte(addrIndexPrefix))
+	if err != nil {
+		return err
+	}
+
+	batch := chain.DB.NewBatch()
+	err = chain.writeAddressIndex(batch)
+	if err != nil {
+		batch.Cancel()
+		return err
+	}
+
+	return batch.Flush()
+}
+
+func (chain *Blockchain) writeAddressIndex(batch database.Batch) error {
+	// the blocks are applied from the genesis, keeping the unspent outputs to know what
+	// the inputs spend
+	unspent := make(map[string]TxOutput)
+	iter := chain.ForwardIterator(0)
+	for {
+		block, err := iter.Next()
+		if err != nil || block == nil {
+			return err
+		}
+
+		var spent []TxOutput
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() {
+				for _, txin := range tx.Inputs {
+					key := string(outpoint(txin.PrevTxHash, txin.OutIdx))
+					spent = append(spent, unspent[key])
+					delete(unspent, key)
+				}
+			}
+			for outIdx, out := range tx.Outputs {
+				unspent[string(outpoint(tx.HashID, outIdx))] = out
+			}
+		}
+
+		entries, err := addressEntries(block, spent)
+		if err != nil {
+			return err
+		}
+		err = writeAddressEntries(batch.Set, entries)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -2,51 +2,112 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
+	"time"
 )
 
+// BlockVersion is the version of the blocks created by this node
+const BlockVersion = 1
+
+// ErrPreHeaderDB is returned when opening a database written before the blocks had a
+// header. Its blocks can't be converted, since their hashes don't commit to a header
+var ErrPreHeaderDB = errors.New("blockchain: the database was written before the block headers, " +
+	"it must be created again")
+
+// BlockHeader contains the metadata of a block. The proof of work commits to the header,
+// and the header commits to the transactions through the MerkleRoot
+type BlockHeader struct {
+	Version    int
+	Height     int
+	Timestamp  int64 // unix time in seconds
+	PrevHash   []byte
+	MerkleRoot []byte
+	Bits       int // difficulty, amount of leading zero bits required in the hash
+	Nonce      uint32
+}
+
 // Block represents a block in a blockchain
 type Block struct {
+	Header       BlockHeader
 	Hash         []byte
 	Transactions []*Transaction
-	PrevHash     []byte
-	Nonce        int
 }
 
-// NewBlock creates a new block struct
-func NewBlock(txs []*Transaction, prevHash []byte) *Block {
-	b := &Block{[]byte{}, txs, prevHash, 0}
-	pow := NewProof(b)
-	nonce, hash := pow.Run()
+// preHeaderBlock has the fields of the blocks as they were encoded with gob before they
+// had a header (the transactions are skipped by the decoder)
+type preHeaderBlock struct {
+	Header   BlockHeader
+	Hash     []byte
+	PrevHash []byte
+	Nonce    int
+}
+
+// isPreHeaderBlock checks if the stored block was written before the blocks had a header
+func isPreHeaderBlock(data []byte) bool {
+	var b preHeaderBlock
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&b)
+	return err == nil && b.Header.Version == 0 && (len(b.PrevHash) > 0 || b.Nonce != 0)
+}
 
-	b.Hash = hash[:]
-	b.Nonce = nonce
+// NewBlock creates a new block struct and mines it using all the CPUs. The merkle root
+// and the nonce of the header are set by this function
+func NewBlock(txs []*Transaction, header BlockHeader) *Block {
+	b := &Block{header, []byte{}, txs}
+	// without a context to cancel it, the mining only stops when the block is mined
+	NewMiner(0).Mine(context.Background(), b)
 	return b
 }
 
 // Genesis creates a genesis block
 func Genesis(coinbase *Transaction) *Block {
-	return NewBlock([]*Transaction{coinbase}, []byte{})
+	header := BlockHeader{
+		Version:   BlockVersion,
+		Height:    0,
+		Timestamp: time.Now().Unix(),
+		PrevHash:  []byte{},
+		Bits:      InitialDifficulty,
+	}
+	return NewBlock([]*Transaction{coinbase}, header)
 }
 
-// DeserializeBlock transforms a serialized block ([]byte) into a Block
-func DeserializeBlock(data []byte) (*Block, error) {
-	block := &Block{}
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(block)
-	return block, err
+// IsGenesis checks if the block is the first block of the chain
+func (b *Block) IsGenesis() bool {
+	return len(b.Header.PrevHash) == 0
 }
 
-// HashTransactions generates the hash of the combined transactions
+// HashTransactions generates the merkle root of the transactions of the block
 func (b *Block) HashTransactions() []byte {
-	var txHashes [][]byte
-	var txHash [sha256.Size]byte
+	return b.merkleTree().Root()
+}
 
-	for _, tx := range b.Transactions {
-		txHashes = append(txHashes, tx.HashID)
-	}
-	txHash = sha256.Sum256(bytes.Join(txHashes, []byte{}))
+// Serialize returns the binary representation of the header, which is what gets hashed
+// by the proof of work. Every field has a fixed size and is encoded as big endian, the
+// hashes are padded to 32 bytes (so the genesis prev hash is all zeros)
+func (h *BlockHeader) Serialize() []byte {
+	buff := new(bytes.Buffer)
+	binary.Write(buff, binary.BigEndian, int32(h.Version))
+	binary.Write(buff, binary.BigEndian, int64(h.Height))
+	binary.Write(buff, binary.BigEndian, h.Timestamp)
+	buff.Write(fixedHash(h.PrevHash))
+	buff.Write(fixedHash(h.MerkleRoot))
+	binary.Write(buff, binary.BigEndian, int32(h.Bits))
+	binary.Write(buff, binary.BigEndian, h.Nonce)
+
+	return buff.Bytes()
+}
+
+// Hash returns the hash of the serialized header
+func (h *BlockHeader) Hash() []byte {
+	hash := sha256.Sum256(h.Serialize())
+	return hash[:]
+}
 
-	return txHash[:]
+func fixedHash(hash []byte) []byte {
+	fixed := make([]byte, sha256.Size)
+	copy(fixed, hash)
+	return fixed
 }
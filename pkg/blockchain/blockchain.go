@@ -1,19 +1,29 @@
 package blockchain
 
 import (
+	"bytes"
+	"context"
 	"encoding/hex"
 	"errors"
 	"jotacoin/pkg/database"
-
-	"github.com/dgraph-io/badger"
+	"math/big"
+	"time"
 )
 
 const genesisData = "Genesis Transaction"
 
+var (
+	// ErrBlockNotFound is returned when the block isn't in the chain
+	ErrBlockNotFound = errors.New("blockchain: block not found")
+	// ErrTxNotFound is returned when the transaction isn't in the chain
+	ErrTxNotFound = errors.New("blockchain: transaction not found")
+)
+
 // Blockchain Represents a chain of blocks
 type Blockchain struct {
 	LastHash []byte
-	DB       *badger.DB
+	DB       database.Store
+	mempool  *Mempool
 }
 
 // NewBlockchain creates a new blockchain, starting with coinbase
@@ -22,18 +32,43 @@ func NewBlockchain(address string) (*Blockchain, error) {
 		return nil, errors.New("Blockchain already exists")
 	}
 
-	cbtx, err := NewCoinbaseTx(address, genesisData)
+	return NewBlockchainAt(database.DBPath, address)
+}
+
+// NewBlockchainAt creates a new blockchain stored in the database folder passed as argument
+func NewBlockchainAt(path, address string) (*Blockchain, error) {
+	if database.DBexistsAt(path) {
+		return nil, errors.New("Blockchain already exists")
+	}
+
+	return NewBlockchainWithStore(database.ConnectDB(path), address)
+}
+
+// NewBlockchainWithStore creates a new blockchain in the store passed as argument, which
+// must be empty
+func NewBlockchainWithStore(store database.Store, address string) (*Blockchain, error) {
+	_, err := getLastHash(store)
+	if err != database.ErrKeyNotFound {
+		store.Close()
+		return nil, errors.New("Blockchain already exists")
+	}
+	err = migrateDB(store)
 	if err != nil {
+		store.Close()
 		return nil, err
 	}
 
-	genesis := Genesis(cbtx)
+	cbtx, err := NewCoinbaseTx(address, genesisData, BlockSubsidy(0))
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
 
-	db := database.ConnectDB(database.DBPath)
-	err = addBlockToDB(db, genesis)
+	genesis := Genesis(cbtx)
 
-	lastHash := genesis.Hash
-	return &Blockchain{lastHash, db}, err
+	chain := &Blockchain{LastHash: []byte{}, DB: store}
+	err = chain.AcceptBlock(genesis)
+	return chain, err
 }
 
 // ContinueBlockchain continues the previous BlockChain if it already exists
@@ -42,13 +77,83 @@ func ContinueBlockchain() (*Blockchain, error) {
 		return nil, errors.New("Blockchain doesn't exist")
 	}
 
-	db := database.ConnectDB(database.DBPath)
-	lastHash, err := getLastHash(db)
+	return ContinueBlockchainAt(database.DBPath)
+}
+
+// ContinueBlockchainAt continues the BlockChain stored in the database folder passed as argument
+func ContinueBlockchainAt(path string) (*Blockchain, error) {
+	if !database.DBexistsAt(path) {
+		return nil, errors.New("Blockchain doesn't exist")
+	}
+
+	chain, err := OpenBlockchainWithStore(database.ConnectDB(path))
 	if err != nil {
 		return nil, err
 	}
+	if len(chain.LastHash) == 0 {
+		chain.DB.Close()
+		return nil, errors.New("Blockchain doesn't exist")
+	}
+	return chain, nil
+}
 
-	return &Blockchain{lastHash, db}, nil
+// OpenBlockchainAt opens the BlockChain stored in the database folder passed as argument.
+// If it doesn't exist, an empty chain is created, which must get its blocks (including
+// the genesis) from other nodes
+func OpenBlockchainAt(path string) (*Blockchain, error) {
+	return OpenBlockchainWithStore(database.ConnectDB(path))
+}
+
+// OpenBlockchainWithStore opens the BlockChain kept in the store passed as argument, which
+// is empty if the store is empty. The store is migrated to the current format if it was
+// written by an older version (building the UTXO set and the address index if they're
+// missing) and closed if it can't be opened
+func OpenBlockchainWithStore(store database.Store) (*Blockchain, error) {
+	err := checkBlockFormat(store)
+	if err == nil {
+		err = migrateDB(store)
+	}
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	lastHash, err := getLastHash(store)
+	if err == database.ErrKeyNotFound {
+		lastHash = []byte{}
+	} else if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	chain := &Blockchain{LastHash: lastHash, DB: store}
+	err = chain.buildHeightIndex()
+	if err == nil && len(lastHash) > 0 {
+		err = chain.ensureIndexes()
+	}
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return chain, nil
+}
+
+// ensureIndexes builds the UTXO set and the address index of a chain stored before they
+// were kept, or whose keys were dropped by migrateDB. Once the chain has blocks they're
+// never empty, since the unspent outputs add up to the whole supply
+func (chain *Blockchain) ensureIndexes() error {
+	found, err := hasKeys(chain.DB, utxoAddrPrefix)
+	if err == nil && !found {
+		err = chain.ReindexUTXO()
+	}
+	if err != nil {
+		return err
+	}
+	found, err = hasKeys(chain.DB, addrIndexPrefix)
+	if err == nil && !found {
+		err = chain.ReindexAddresses()
+	}
+	return err
 }
 
 // Iterator creates a BlockChain Iterador
@@ -56,128 +161,311 @@ func (chain *Blockchain) Iterator() *Iterator {
 	return &Iterator{chain.LastHash, chain.DB}
 }
 
-// AddBlock adds a block into the chain of blocks
+// ForwardIterator creates an iterator that goes through the main chain from the block
+// at the height passed as argument to the tip
+func (chain *Blockchain) ForwardIterator(height int) *ForwardIterator {
+	return &ForwardIterator{height, chain}
+}
+
+// AddBlock mines a block with the transactions and adds it into the chain of blocks.
+// The first transaction must be the coinbase that rewards the miner
 func (chain *Blockchain) AddBlock(txs []*Transaction) error {
-	lastHash, err := getLastHash(chain.DB)
+	newBlock, err := chain.MineBlock(txs)
 	if err != nil {
 		return err
 	}
 
-	newBlock := NewBlock(txs, lastHash)
-	err = addBlockToDB(chain.DB, newBlock)
+	return chain.AcceptBlock(newBlock)
+}
+
+// MineBlock mines a block with the transactions on top of the last block of the chain,
+// but it doesn't add it into the chain
+func (chain *Blockchain) MineBlock(txs []*Transaction) (*Block, error) {
+	b, err := chain.NewBlockTemplate(txs)
 	if err != nil {
+		return nil, err
+	}
+
+	err = NewMiner(0).Mine(context.Background(), b)
+	return b, err
+}
+
+// NewBlockTemplate creates a block that isn't mined yet with the transactions on top
+// of the last block of the chain. It must be mined using Miner.Mine
+func (chain *Blockchain) NewBlockTemplate(txs []*Transaction) (*Block, error) {
+	var lastBlock *Block
+	var mtp int64
+	var bits int
+
+	err := chain.DB.View(func(txn database.Txn) error {
+		lastHash, err := getLastHashTxn(txn)
+		if err != nil {
+			return err
+		}
+		lastBlock, err = getBlockTxn(txn, lastHash)
+		if err != nil {
+			return err
+		}
+		mtp, err = medianTimePast(txn, lastHash)
+		if err != nil {
+			return err
+		}
+		bits, err = nextDifficulty(txn, &lastBlock.Header)
 		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	chain.LastHash = newBlock.Hash
-	return nil
+	// the timestamp must always be greater than the median time past, even when
+	// several blocks are mined in the same second
+	timestamp := time.Now().Unix()
+	if timestamp <= mtp {
+		timestamp = mtp + 1
+	}
+
+	header := BlockHeader{
+		Version:   BlockVersion,
+		Height:    lastBlock.Header.Height + 1,
+		Timestamp: timestamp,
+		PrevHash:  lastBlock.Hash,
+		Bits:      bits,
+	}
+	b := &Block{header, []byte{}, txs}
+	b.Header.MerkleRoot = b.HashTransactions()
+	return b, nil
+}
+
+// Height returns the height of the last block of the chain, or -1 if the chain is empty
+func (chain *Blockchain) Height() (int, error) {
+	if len(chain.LastHash) == 0 {
+		return -1, nil
+	}
+	lastBlock, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		return 0, err
+	}
+	return lastBlock.Header.Height, nil
+}
+
+// HasBlock checks if the block with the hash passed as argument is stored
+func (chain *Blockchain) HasBlock(hash []byte) bool {
+	_, err := chain.GetBlock(hash)
+	return err == nil
+}
+
+// Locator returns hashes of the chain, from the tip back to the genesis, that other
+// nodes use to find the last block they have in common with this chain. The first ten
+// hashes are consecutive, then the step doubles with every hash
+func (chain *Blockchain) Locator() ([][]byte, error) {
+	var locator [][]byte
+
+	err := chain.DB.View(func(txn database.Txn) error {
+		var err error
+		locator, err = locatorFrom(txn, chain.LastHash)
+		return err
+	})
+	return locator, err
 }
 
-// FindUnspentTransactions returns the Transactions where the output hasn't been spent yet
-// by the public key hash
-func (chain *Blockchain) FindUnspentTransactions(pubKeyHash []byte) []*Transaction {
-	var unspentTxs []*Transaction
-	spentTXOs := make(map[string][]int)
+func locatorFrom(txn database.Txn, hash []byte) ([][]byte, error) {
+	var locator [][]byte
+	step := 1
+	for skip := 0; len(hash) > 0; skip-- {
+		header, err := getHeaderTxn(txn, hash)
+		if err != nil {
+			return nil, err
+		}
+		if skip > 0 && len(header.PrevHash) > 0 {
+			hash = header.PrevHash
+			continue
+		}
 
+		locator = append(locator, hash)
+		if len(locator) >= 10 {
+			step *= 2
+		}
+		skip = step
+		hash = header.PrevHash
+	}
+	return locator, nil
+}
+
+// HashesAfter returns the hashes, in ascending order, of the (at most max) blocks that
+// follow the first hash of the locator found in the chain
+func (chain *Blockchain) HashesAfter(locator [][]byte, max int) ([][]byte, error) {
+	if len(chain.LastHash) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]bool)
+	for _, hash := range locator {
+		known[string(hash)] = true
+	}
+
+	var hashes [][]byte
 	iter := chain.Iterator()
 	for {
-		block, err := iter.Next()
+		b, err := iter.Next()
 		if err != nil {
+			return nil, err
+		}
+		if known[string(b.Hash)] {
+			break
+		}
+		hashes = append(hashes, b.Hash)
+		if b.IsGenesis() {
 			break
 		}
+	}
 
-	Transactions:
-		for _, tx := range block.Transactions {
-			if !tx.IsCoinbase() {
-				// If it's not a Coinbase, it means that's a normal transaction
-				// so it has a previous transaction
-				for _, txin := range tx.Inputs {
-					if txin.UsesKey(pubKeyHash) {
-						// if address can unlock, it means that address spent the previous output
-						prevTxHash := hex.EncodeToString(txin.PrevTxHash)
-						spentTXOs[prevTxHash] = append(spentTXOs[prevTxHash], txin.OutIdx)
-					}
-				}
-			}
+	for i, j := 0, len(hashes)-1; i < j; i, j = i+1, j-1 {
+		hashes[i], hashes[j] = hashes[j], hashes[i]
+	}
+	if len(hashes) > max {
+		hashes = hashes[:max]
+	}
+	return hashes, nil
+}
 
-			txHash := hex.EncodeToString(tx.HashID)
-		Outputs:
-			for outIdx, out := range tx.Outputs {
-				if !out.IsLockedWithKey(pubKeyHash) {
-					continue
-				}
-				// Check if the address has already spent the output
-				if spentTXOs[txHash] != nil {
-					for _, spentOutIdx := range spentTXOs[txHash] {
-						if spentOutIdx == outIdx {
-							continue Outputs
-						}
-					}
-				}
-
-				unspentTxs = append(unspentTxs, tx)
-				// if this transaction was already spent, it can go to another Tx
-				continue Transactions
-			}
+// GetBlock returns the block with the hash passed as argument
+func (chain *Blockchain) GetBlock(hash []byte) (*Block, error) {
+	return getBlock(chain.DB, hash)
+}
+
+// FindTransaction returns the transaction of the main chain with the hash passed as
+// argument and the block that contains it. If the transaction index is enabled, it's
+// used instead of going through the blocks
+func (chain *Blockchain) FindTransaction(txHash []byte) (*Transaction, *Block, error) {
+	if len(chain.LastHash) == 0 {
+		return nil, nil, ErrTxNotFound
+	}
+
+	var tx *Transaction
+	var block *Block
+	var indexed bool
+	err := chain.DB.View(func(txn database.Txn) error {
+		var err error
+		indexed, err = txIndexEnabled(txn)
+		if err != nil || !indexed {
+			return err
+		}
+		location, err := getTxLocation(txn, txHash)
+		if err != nil {
+			return err
+		}
+		block, err = getBlockTxn(txn, location.BlockHash)
+		if err != nil {
+			return err
 		}
+		if location.Position >= len(block.Transactions) {
+			return ErrTxNotFound
+		}
+		tx = block.Transactions[location.Position]
+		return nil
+	})
+	if err != nil || indexed {
+		return tx, block, err
+	}
 
-		// if it's the genesis block, break
-		if len(block.PrevHash) == 0 {
-			break
+	iter := chain.Iterator()
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, tx := range block.Transactions {
+			if bytes.Equal(tx.HashID, txHash) {
+				return tx, block, nil
+			}
+		}
+		if block.IsGenesis() {
+			return nil, nil, ErrTxNotFound
 		}
 	}
+}
+
+// AcceptBlock validates the block (mined locally or received from another node) and,
+// if it's valid, stores it. The block becomes the new tip if it extends the tip or if
+// its branch has more cumulative work than the main chain (reorganizing the chain),
+// otherwise it's kept in a side branch
+func (chain *Blockchain) AcceptBlock(b *Block) error {
+	change, err := addBlockToDB(chain.DB, b)
+	if err != nil {
+		return err
+	}
+
+	lastHash, err := getLastHash(chain.DB)
+	if err != nil {
+		return err
+	}
+	chain.LastHash = lastHash
+	if chain.mempool != nil {
+		return chain.mempool.updateTip(change)
+	}
+	return nil
+}
+
+// ChainWork returns the cumulative work of the main chain
+func (chain *Blockchain) ChainWork() (*big.Int, error) {
+	var work *big.Int
 
-	return unspentTxs
+	err := chain.DB.View(func(txn database.Txn) error {
+		var err error
+		work, err = getWork(txn, chain.LastHash)
+		return err
+	})
+	return work, err
 }
 
-// FindSpendableTxOutputs returns the tokens accumulated by the spendable outputs and a map where
+// FindSpendableTxOutputs returns the tokens accumulated by the spendable outputs locked to
+// the address and a map where
 // the keys are the Transactions IDs and the values are slices containing the indexes
-// of the outputs of that Transaction
+// of the outputs of that Transaction. If the chain has a mempool, the outputs already
+// spent by the transactions of the mempool aren't spendable
 func (chain *Blockchain) FindSpendableTxOutputs(
-	pubKeyHash []byte, requiredAmount int,
+	address string, requiredAmount int,
 ) (int, map[string][]int) {
 	spendableOuts := make(map[string][]int)
-	unspentTxs := chain.FindUnspentTransactions(pubKeyHash)
 	accumulated := 0
 
-Work:
-	for _, tx := range unspentTxs {
-		txHash := hex.EncodeToString(tx.HashID)
-
-		for outIdx, out := range tx.Outputs {
-			if out.IsLockedWithKey(pubKeyHash) {
-				accumulated += out.Value
-				spendableOuts[txHash] = append(spendableOuts[txHash], outIdx)
-
-				if accumulated >= requiredAmount {
-					break Work
-				}
+	chain.DB.View(func(txn database.Txn) error {
+		return forEachUTXO(txn, address, func(txHash []byte, outIdx int, out TxOutput) error {
+			if accumulated >= requiredAmount {
+				return errStopIteration
 			}
-		}
-	}
+			if chain.mempool != nil && chain.mempool.IsSpent(txHash, outIdx) {
+				return nil
+			}
+
+			txHashStr := hex.EncodeToString(txHash)
+			accumulated += out.Value
+			spendableOuts[txHashStr] = append(spendableOuts[txHashStr], outIdx)
+			return nil
+		})
+	})
 
 	return accumulated, spendableOuts
 }
 
-// FindUTXO find the unspent outputs of the public key hash. This function is useful
-// to get the public key hash balance
-func (chain *Blockchain) FindUTXO(pubKeyHash []byte) []TxOutput {
+// FindUTXO find the unspent outputs locked to the address. This function is useful to
+// get the address balance
+func (chain *Blockchain) FindUTXO(address string) []TxOutput {
 	var UTXOs []TxOutput
-	unspentTxs := chain.FindUnspentTransactions(pubKeyHash)
-	for _, tx := range unspentTxs {
-		for _, out := range tx.Outputs {
-			if out.IsLockedWithKey(pubKeyHash) {
-				UTXOs = append(UTXOs, out)
-			}
-		}
-	}
+
+	chain.DB.View(func(txn database.Txn) error {
+		return forEachUTXO(txn, address, func(_ []byte, _ int, out TxOutput) error {
+			UTXOs = append(UTXOs, out)
+			return nil
+		})
+	})
 
 	return UTXOs
 }
 
-// GetBalance returns the balance of the public key hash
-func (chain *Blockchain) GetBalance(pubKeyHash []byte) int {
-	unspentOutput := chain.FindUTXO(pubKeyHash)
+// GetBalance returns the balance of the address
+func (chain *Blockchain) GetBalance(address string) int {
+	unspentOutput := chain.FindUTXO(address)
 	total := 0
 
 	for _, out := range unspentOutput {
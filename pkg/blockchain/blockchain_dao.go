@@ -1,59 +1,193 @@
 package blockchain
 
 import (
-	"jotacoin/pkg/utils"
-
-	"github.com/dgraph-io/badger"
+	"bytes"
+	"errors"
+	"fmt"
+	"jotacoin/pkg/database"
+	"sort"
 )
 
-func getLastHash(db *badger.DB) ([]byte, error) {
+func getLastHash(db database.Store) ([]byte, error) {
 	var lastHash []byte
 
-	err := db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lastHash"))
+	err := db.View(func(txn database.Txn) error {
+		var err error
+		lastHash, err = getLastHashTxn(txn)
+		return err
+	})
+	return lastHash, err
+}
+
+func getLastHashTxn(txn database.Txn) ([]byte, error) {
+	return txn.Get([]byte("lastHash"))
+}
+
+// checkBlockFormat fails with ErrPreHeaderDB if the last block of the store was written
+// before the blocks had a header
+func checkBlockFormat(db database.Store) error {
+	return db.View(func(txn database.Txn) error {
+		lastHash, err := getLastHashTxn(txn)
+		if err == database.ErrKeyNotFound {
+			return nil
+		}
 		if err != nil {
 			return err
 		}
-		return item.Value(func(val []byte) error {
-			lastHash = val
+		val, err := txn.Get(lastHash)
+		if err == database.ErrKeyNotFound {
 			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if isPreHeaderBlock(val) {
+			return ErrPreHeaderDB
+		}
+		return nil
+	})
+}
+
+// hasKeys checks if the store has any key with the prefix
+func hasKeys(db database.Store, prefix string) (bool, error) {
+	found := false
+	err := db.View(func(txn database.Txn) error {
+		return txn.Iterate([]byte(prefix), func(key, val []byte) error {
+			found = true
+			return errStopIteration
 		})
 	})
-	return lastHash, err
+	if err == errStopIteration {
+		err = nil
+	}
+	return found, err
 }
 
-func getBlock(db *badger.DB, hash []byte) (*Block, error) {
+func getBlock(db database.Store, hash []byte) (*Block, error) {
 	var block *Block
 
-	err := db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(hash)
+	err := db.View(func(txn database.Txn) error {
+		var err error
+		block, err = getBlockTxn(txn, hash)
+		return err
+	})
+
+	return block, err
+}
+
+func getBlockTxn(txn database.Txn, hash []byte) (*Block, error) {
+	val, err := txn.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	return DeserializeBlock(val)
+}
+
+// medianTimePast returns the median timestamp of the last medianTimeBlocks blocks (or
+// headers), starting from the block with the hash passed as argument
+func medianTimePast(txn database.Txn, hash []byte) (int64, error) {
+	var timestamps []int64
+
+	for len(hash) > 0 && len(timestamps) < medianTimeBlocks {
+		header, err := getHeaderTxn(txn, hash)
 		if err != nil {
+			return 0, err
+		}
+		timestamps = append(timestamps, header.Timestamp)
+		hash = header.PrevHash
+	}
+	if len(timestamps) == 0 {
+		return 0, nil
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2], nil
+}
+
+// addBlockToDB validates the block and stores it. The block can extend the tip or any
+// other stored block: if its branch has more cumulative work than the main chain, the
+// main chain is reorganized to end at the block. It returns how the main chain changed.
+// If a block fails to connect, it's flagged as invalid along with its descendants
+func addBlockToDB(db database.Store, b *Block) (*tipChange, error) {
+	var change *tipChange
+
+	err := db.Update(func(txn database.Txn) error {
+		_, err := txn.Get(b.Hash)
+		if err == nil {
+			return newValidationError(ErrKnownBlock, nil, fmt.Sprintf("block %x", b.Hash))
+		}
+		if err != database.ErrKeyNotFound {
 			return err
 		}
-		return item.Value(func(val []byte) error {
-			block, err = DeserializeBlock(val)
+
+		lastHash, err := getLastHashTxn(txn)
+		if err == database.ErrKeyNotFound {
+			lastHash = []byte{}
+		} else if err != nil {
 			return err
-		})
-	})
+		}
+		if b.IsGenesis() && len(lastHash) > 0 {
+			return newValidationError(ErrBadPrevHash, nil, "the chain already has a genesis")
+		}
 
-	return block, err
-}
+		err = validateHeader(txn, b)
+		if err != nil {
+			return err
+		}
+		err = validateStructure(b)
+		if err != nil {
+			return err
+		}
 
-func addBlockToDB(db *badger.DB, b *Block) error {
-	return db.Update(func(txn *badger.Txn) error {
-		serializedBlock, err := utils.Serialize(b)
+		work, err := getWork(txn, b.Header.PrevHash)
 		if err != nil {
 			return err
 		}
-		err = txn.Set(b.Hash, serializedBlock)
+		work.Add(work, blockProof(b.Header.Bits))
+
+		err = txn.Set(b.Hash, b.Serialize())
 		if err != nil {
 			return err
 		}
-		err = txn.Set([]byte("lastHash"), b.Hash)
+		err = txn.Set(workKey(b.Hash), work.Bytes())
 		if err != nil {
 			return err
 		}
 
-		return nil
+		// the UTXO set is updated in the same transaction, so it never gets out of sync
+		// with the main chain
+		if bytes.Equal(b.Header.PrevHash, lastHash) {
+			change = &tipChange{connected: []*Block{b}}
+			return invalidBranch(connectBlock(txn, b), change.connected)
+		}
+
+		// the block is in a side branch, which only becomes the main chain if it has
+		// more work (on a tie, the branch seen first wins)
+		tipWork, err := getWork(txn, lastHash)
+		if err != nil {
+			return err
+		}
+		if work.Cmp(tipWork) <= 0 {
+			change = &tipChange{}
+			return nil
+		}
+		tip, err := getBlockTxn(txn, lastHash)
+		if err != nil {
+			return err
+		}
+		change, err = reorganize(txn, tip, b)
+		return err
 	})
+
+	var invalid *invalidBranchError
+	if errors.As(err, &invalid) {
+		flagErr := db.Update(func(txn database.Txn) error {
+			return invalidateHeaders(txn, invalid.blocks)
+		})
+		if flagErr != nil {
+			return nil, flagErr
+		}
+		return nil, invalid.err
+	}
+	return change, err
 }
@@ -1,24 +1,45 @@
 package blockchain
 
 import (
-	"github.com/dgraph-io/badger"
+	"jotacoin/pkg/database"
 )
 
 // Iterator is a struct that will iterate with all the hashes
 // in the database
 type Iterator struct {
 	CurrentHash []byte
-	DB          *badger.DB
+	DB          database.Store
 }
 
 // Next returns the block according to Iterator.CurrentHash and will set the
-// Iterator.CurrentHash to be the Block.PrevHash gotten
+// Iterator.CurrentHash to be the previous hash of the Block gotten
 func (iter *Iterator) Next() (*Block, error) {
 	block, err := getBlock(iter.DB, iter.CurrentHash)
 	if err != nil {
 		return nil, err
 	}
 
-	iter.CurrentHash = block.PrevHash
+	iter.CurrentHash = block.Header.PrevHash
+	return block, nil
+}
+
+// ForwardIterator iterates through the blocks of the main chain in ascending order
+type ForwardIterator struct {
+	Height int
+	chain  *Blockchain
+}
+
+// Next returns the block of the main chain at ForwardIterator.Height and increments
+// it. After the tip it returns nil
+func (iter *ForwardIterator) Next() (*Block, error) {
+	block, err := iter.chain.BlockAt(iter.Height)
+	if err == ErrBlockNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	iter.Height++
 	return block, nil
 }
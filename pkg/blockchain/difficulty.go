@@ -0,0 +1,86 @@
+package blockchain
+
+import (
+	"jotacoin/pkg/database"
+	"time"
+)
+
+const (
+	// InitialDifficulty is the difficulty of the genesis block
+	InitialDifficulty = 12
+	// MinDifficulty is the lowest difficulty a block can have
+	MinDifficulty = 1
+	// MaxDifficulty is the highest difficulty a block can have
+	MaxDifficulty = 255
+)
+
+var (
+	// RetargetInterval is the amount of blocks between each difficulty adjustment
+	RetargetInterval = 20
+	// TargetBlockTime is the expected time between two blocks
+	TargetBlockTime = 30 * time.Second
+)
+
+// NextDifficulty returns the difficulty bits required for the next block of the chain
+func (chain *Blockchain) NextDifficulty() (int, error) {
+	var bits int
+
+	err := chain.DB.View(func(txn database.Txn) error {
+		lastHash, err := getLastHashTxn(txn)
+		if err != nil {
+			return err
+		}
+		lastBlock, err := getBlockTxn(txn, lastHash)
+		if err != nil {
+			return err
+		}
+		bits, err = nextDifficulty(txn, &lastBlock.Header)
+		return err
+	})
+
+	return bits, err
+}
+
+// nextDifficulty returns the difficulty bits required for the block after prev. Every
+// RetargetInterval blocks, the time spent to mine the last interval is compared to the
+// expected time. As the bits are the amount of leading zero bits of the target, each
+// bit doubles (or halves) the difficulty, so it can change at most 2 bits per retarget
+func nextDifficulty(txn database.Txn, prev *BlockHeader) (int, error) {
+	height := prev.Height + 1
+	if height%RetargetInterval != 0 {
+		return prev.Bits, nil
+	}
+
+	first := prev
+	for first.Height > height-RetargetInterval {
+		var err error
+		first, err = getHeaderTxn(txn, first.PrevHash)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	blocks := int64(prev.Height - first.Height)
+	expected := int64(TargetBlockTime/time.Second) * blocks
+	actual := prev.Timestamp - first.Timestamp
+
+	bits := prev.Bits
+	switch {
+	case actual*4 <= expected:
+		bits += 2
+	case actual*2 <= expected:
+		bits++
+	case actual >= expected*4:
+		bits -= 2
+	case actual >= expected*2:
+		bits--
+	}
+
+	if bits < MinDifficulty {
+		bits = MinDifficulty
+	}
+	if bits > MaxDifficulty {
+		bits = MaxDifficulty
+	}
+	return bits, nil
+}
@@ -0,0 +1,337 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// The canonical serialization of blocks and transactions, which is what gets hashed,
+// stored and sent to other nodes. All the integers are big endian and every variable
+// sized field is prefixed by its length as an uint32:
+//
+//	transaction: version (uint32), [hash (bytes), only in legacy transactions],
+//	             inputs count (uint32), inputs, outputs count (uint32), outputs,
+//	             lock time (uint32)
+//	input:       prev tx hash (bytes), out idx (int32), signature (bytes), pub key (bytes),
+//	             unlocking script (bytes)
+//	output:      value (int64), pub key hash (bytes), locking script (bytes)
+//	block:       header (see BlockHeader.Serialize), txs count (uint32),
+//	             transactions (each one prefixed by its length)
+
+// headerSize is the size of the serialized header
+const headerSize = 4 + 8 + 8 + sha256.Size + sha256.Size + 4 + 4
+
+// ErrMalformedData is returned when the serialized block or transaction can't be decoded
+var ErrMalformedData = errors.New("blockchain: malformed serialized data")
+
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) writeUint32(n uint32) {
+	binary.Write(e, binary.BigEndian, n)
+}
+
+func (e *encoder) writeInt64(n int64) {
+	binary.Write(e, binary.BigEndian, n)
+}
+
+func (e *encoder) writeBytes(data []byte) {
+	e.writeUint32(uint32(len(data)))
+	e.Write(data)
+}
+
+// decoder reads the fields written by an encoder, failing with ErrMalformedData if the
+// data is shorter than its fields say
+type decoder struct {
+	data []byte
+}
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data) {
+		return nil, ErrMalformedData
+	}
+	field := d.data[:n]
+	d.data = d.data[n:]
+	return field, nil
+}
+
+func (d *decoder) readUint32() (uint32, error) {
+	field, err := d.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(field), nil
+}
+
+func (d *decoder) readInt64() (int64, error) {
+	field, err := d.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(field)), nil
+}
+
+func (d *decoder) readBytes() ([]byte, error) {
+	n, err := d.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	field, err := d.next(int(n))
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{}, field...), nil
+}
+
+// end checks that all the data was read
+func (d *decoder) end() error {
+	if len(d.data) > 0 {
+		return ErrMalformedData
+	}
+	return nil
+}
+
+func (txin *TxInput) encode(e *encoder) {
+	e.writeBytes(txin.PrevTxHash)
+	e.writeUint32(uint32(int32(txin.OutIdx)))
+	e.writeBytes(txin.Signature)
+	e.writeBytes(txin.PubKey)
+	e.writeBytes(txin.Script)
+}
+
+func (txin *TxInput) decode(d *decoder) error {
+	var err error
+	if txin.PrevTxHash, err = d.readBytes(); err != nil {
+		return err
+	}
+	outIdx, err := d.readUint32()
+	if err != nil {
+		return err
+	}
+	txin.OutIdx = int(int32(outIdx))
+	if txin.Signature, err = d.readBytes(); err != nil {
+		return err
+	}
+	if txin.PubKey, err = d.readBytes(); err != nil {
+		return err
+	}
+	txin.Script, err = readScript(d)
+	return err
+}
+
+func (txout *TxOutput) encode(e *encoder) {
+	e.writeInt64(int64(txout.Value))
+	e.writeBytes(txout.PubKeyHash)
+	e.writeBytes(txout.Script)
+}
+
+func (txout *TxOutput) decode(d *decoder) error {
+	value, err := d.readInt64()
+	if err != nil {
+		return err
+	}
+	txout.Value = int(value)
+	if txout.PubKeyHash, err = d.readBytes(); err != nil {
+		return err
+	}
+	txout.Script, err = readScript(d)
+	return err
+}
+
+// readScript reads a script, which is nil if it's empty (the inputs and outputs that
+// use the P2PKH template don't have one)
+func readScript(d *decoder) ([]byte, error) {
+	script, err := d.readBytes()
+	if len(script) == 0 {
+		return nil, err
+	}
+	return script, err
+}
+
+func (tx *Transaction) encode(e *encoder) {
+	e.writeUint32(uint32(tx.Version))
+	if tx.Version == LegacyTxVersion {
+		e.writeBytes(tx.HashID)
+	}
+
+	e.writeUint32(uint32(len(tx.Inputs)))
+	for i := range tx.Inputs {
+		tx.Inputs[i].encode(e)
+	}
+	e.writeUint32(uint32(len(tx.Outputs)))
+	for i := range tx.Outputs {
+		tx.Outputs[i].encode(e)
+	}
+	e.writeUint32(uint32(tx.LockTime))
+}
+
+func (tx *Transaction) decode(d *decoder) error {
+	version, err := d.readUint32()
+	if err != nil {
+		return err
+	}
+	if version > TxVersion {
+		return ErrMalformedData
+	}
+	tx.Version = int(version)
+	if tx.Version == LegacyTxVersion {
+		if tx.HashID, err = d.readBytes(); err != nil {
+			return err
+		}
+	}
+
+	count, err := d.readUint32()
+	if err != nil {
+		return err
+	}
+	tx.Inputs = nil
+	for i := uint32(0); i < count; i++ {
+		var txin TxInput
+		if err = txin.decode(d); err != nil {
+			return err
+		}
+		tx.Inputs = append(tx.Inputs, txin)
+	}
+
+	count, err = d.readUint32()
+	if err != nil {
+		return err
+	}
+	tx.Outputs = nil
+	for i := uint32(0); i < count; i++ {
+		var txout TxOutput
+		if err = txout.decode(d); err != nil {
+			return err
+		}
+		tx.Outputs = append(tx.Outputs, txout)
+	}
+
+	lockTime, err := d.readUint32()
+	if err != nil {
+		return err
+	}
+	tx.LockTime = int(lockTime)
+
+	if tx.Version != LegacyTxVersion {
+		tx.HashID, err = tx.Hash()
+	}
+	return err
+}
+
+// Serialize returns the canonical serialization of the transaction, which doesn't
+// include its hash (except in the legacy transactions)
+func (tx *Transaction) Serialize() []byte {
+	e := &encoder{}
+	tx.encode(e)
+	return e.Bytes()
+}
+
+// DeserializeTransaction decodes a transaction serialized with Transaction.Serialize and
+// calculates its hash
+func DeserializeTransaction(data []byte) (*Transaction, error) {
+	tx := &Transaction{}
+	d := &decoder{data}
+	err := tx.decode(d)
+	if err != nil {
+		return nil, err
+	}
+	return tx, d.end()
+}
+
+// MarshalBinary makes gob (used by the network messages and the mempool) encode the
+// transaction with its canonical serialization
+func (tx *Transaction) MarshalBinary() ([]byte, error) {
+	return tx.Serialize(), nil
+}
+
+// UnmarshalBinary is the counterpart of MarshalBinary
+func (tx *Transaction) UnmarshalBinary(data []byte) error {
+	decoded, err := DeserializeTransaction(data)
+	if err != nil {
+		return err
+	}
+	*tx = *decoded
+	return nil
+}
+
+func (h *BlockHeader) decode(d *decoder) error {
+	data, err := d.next(headerSize)
+	if err != nil {
+		return err
+	}
+
+	h.Version = int(int32(binary.BigEndian.Uint32(data[0:4])))
+	h.Height = int(int64(binary.BigEndian.Uint64(data[4:12])))
+	h.Timestamp = int64(binary.BigEndian.Uint64(data[12:20]))
+	h.PrevHash = append([]byte{}, data[20:52]...)
+	h.MerkleRoot = append([]byte{}, data[52:84]...)
+	h.Bits = int(int32(binary.BigEndian.Uint32(data[84:88])))
+	h.Nonce = binary.BigEndian.Uint32(data[88:92])
+
+	// the genesis prev hash is serialized as zeros (see fixedHash)
+	if bytes.Equal(h.PrevHash, make([]byte, sha256.Size)) {
+		h.PrevHash = []byte{}
+	}
+	return nil
+}
+
+// Serialize returns the canonical serialization of the block, which doesn't include its
+// hash nor the hashes of its transactions
+func (b *Block) Serialize() []byte {
+	e := &encoder{}
+	e.Write(b.Header.Serialize())
+	e.writeUint32(uint32(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		e.writeBytes(tx.Serialize())
+	}
+	return e.Bytes()
+}
+
+// DeserializeBlock decodes a block serialized with Block.Serialize and calculates the
+// hashes of the block and its transactions
+func DeserializeBlock(data []byte) (*Block, error) {
+	block := &Block{}
+	d := &decoder{data}
+	err := block.Header.decode(d)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := d.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint32(0); i < count; i++ {
+		serializedTx, err := d.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		tx, err := DeserializeTransaction(serializedTx)
+		if err != nil {
+			return nil, err
+		}
+		block.Transactions = append(block.Transactions, tx)
+	}
+
+	block.Hash = block.Header.Hash()
+	return block, d.end()
+}
+
+// MarshalBinary makes gob (used by the network messages) encode the block with its
+// canonical serialization
+func (b *Block) MarshalBinary() ([]byte, error) {
+	return b.Serialize(), nil
+}
+
+// UnmarshalBinary is the counterpart of MarshalBinary
+func (b *Block) UnmarshalBinary(data []byte) error {
+	decoded, err := DeserializeBlock(data)
+	if err != nil {
+		return err
+	}
+	*b = *decoded
+	return nil
+}
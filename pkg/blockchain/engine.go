@@ -0,0 +1,312 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"jotacoin/pkg/wallet"
+	"math/big"
+)
+
+// ErrScriptFailed is returned when a script fails or ends with a false result
+var ErrScriptFailed = errors.New("script: evaluation failed")
+
+// engine is the stack machine that executes the scripts of an input of a transaction
+type engine struct {
+	tx      *Transaction
+	txinIdx int
+	prevOut TxOutput // output spent by the input
+	stack   [][]byte
+}
+
+// verifyInput executes the unlocking script of the input txinIdx and then the locking
+// script of prevOut, the output it spends. The input can spend the output if both
+// scripts run without failing and the top of the stack is true. If prevOut is a P2SH
+// output, the redeem script must also succeed
+func verifyInput(tx *Transaction, txinIdx int, prevOut TxOutput) error {
+	unlocking := tx.Inputs[txinIdx].UnlockingScript()
+	if !IsPushOnly(unlocking) {
+		return fmt.Errorf("%w: the unlocking script must only push values", ErrScriptFailed)
+	}
+
+	e := &engine{tx: tx, txinIdx: txinIdx, prevOut: prevOut}
+	err := e.execute(unlocking)
+	if err != nil {
+		return err
+	}
+	unlockingStack := append([][]byte{}, e.stack...)
+	locking := prevOut.LockingScript()
+	err = e.execute(locking)
+	if err != nil {
+		return err
+	}
+	err = e.result()
+	if err != nil || extractP2SH(locking) == nil {
+		return err
+	}
+
+	// the locking script of a P2SH output only checks the hash of the redeem script, the
+	// last value pushed by the unlocking script, which is executed over the rest of values
+	redeemScript := unlockingStack[len(unlockingStack)-1]
+	e.stack = unlockingStack[:len(unlockingStack)-1]
+	err = e.execute(redeemScript)
+	if err != nil {
+		return err
+	}
+	return e.result()
+}
+
+// result checks that the top of the stack is true after executing the scripts
+func (e *engine) result() error {
+	if len(e.stack) == 0 || !isTrue(e.stack[len(e.stack)-1]) {
+		return fmt.Errorf("%w: false result", ErrScriptFailed)
+	}
+	return nil
+}
+
+func (e *engine) execute(script []byte) error {
+	instructions, err := ParseScript(script)
+	if err != nil {
+		return err
+	}
+
+	for _, in := range instructions {
+		if in.IsPush() {
+			value := in.Data
+			if in.Op >= Op1 && in.Op <= Op16 {
+				value = scriptNum(uint32(in.Op-Op1) + 1)
+			}
+			e.push(value)
+		} else {
+			err = e.executeOp(in.Op)
+		}
+		if err == nil && len(e.stack) > maxStackSize {
+			err = fmt.Errorf("%w: stack overflow", ErrScriptFailed)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *engine) executeOp(op Opcode) error {
+	switch op {
+	case OpVerify:
+		return e.verify(op)
+	case OpDrop:
+		_, err := e.pop()
+		return err
+	case OpDup:
+		value, err := e.peek()
+		if err != nil {
+			return err
+		}
+		e.push(value)
+	case OpEqual, OpEqualVerify:
+		a, err := e.pop()
+		if err != nil {
+			return err
+		}
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		e.push(boolValue(bytes.Equal(a, b)))
+		if op == OpEqualVerify {
+			return e.verify(op)
+		}
+	case OpSHA256:
+		value, err := e.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(value)
+		e.push(hash[:])
+	case OpHash160:
+		value, err := e.pop()
+		if err != nil {
+			return err
+		}
+		hash, err := wallet.PublicKeyHash(value)
+		if err != nil {
+			return err
+		}
+		e.push(hash)
+	case OpCheckSig, OpCheckSigVerify:
+		pubKey, err := e.pop()
+		if err != nil {
+			return err
+		}
+		signature, err := e.pop()
+		if err != nil {
+			return err
+		}
+		e.push(boolValue(e.checkSignature(signature, pubKey)))
+		if op == OpCheckSigVerify {
+			return e.verify(op)
+		}
+	case OpCheckMultiSig:
+		return e.checkMultiSig()
+	case OpCheckLockTimeVerify:
+		value, err := e.peek()
+		if err != nil {
+			return err
+		}
+		height, err := parseScriptNum(value)
+		if err != nil {
+			return err
+		}
+		if height > uint32(e.tx.LockTime) {
+			return fmt.Errorf("%w: locked until height %d, the lock time is %d",
+				ErrScriptFailed, height, e.tx.LockTime)
+		}
+	default:
+		return fmt.Errorf("%w: unknown opcode %#x", ErrScriptFailed, byte(op))
+	}
+	return nil
+}
+
+// checkMultiSig checks m of n signatures. The stack must have (from the bottom)
+// <sig 1> ... <sig m> <m> <pubKey 1> ... <pubKey n> <n>, and the signatures must be in
+// the same order as their public keys. It pushes if all the signatures are valid
+func (e *engine) checkMultiSig() error {
+	n, err := e.popNum()
+	if err != nil {
+		return err
+	}
+	if n < 1 || n > 16 {
+		return fmt.Errorf("%w: %d public keys", ErrScriptFailed, n)
+	}
+	pubKeys, err := e.popN(int(n))
+	if err != nil {
+		return err
+	}
+	m, err := e.popNum()
+	if err != nil {
+		return err
+	}
+	if m > n {
+		return fmt.Errorf("%w: %d signatures of %d public keys", ErrScriptFailed, m, n)
+	}
+	signatures, err := e.popN(int(m))
+	if err != nil {
+		return err
+	}
+
+	// each signature is checked against the next public keys until one matches
+	k := 0
+	for _, signature := range signatures {
+		for k < len(pubKeys) && !e.checkSignature(signature, pubKeys[k]) {
+			k++
+		}
+		if k == len(pubKeys) {
+			e.push(boolValue(false))
+			return nil
+		}
+		k++
+	}
+	e.push(boolValue(true))
+	return nil
+}
+
+// checkSignature checks the signature, with the sighash type appended, of the input
+// with the public key (the X and Y coordinates of the P256 point)
+func (e *engine) checkSignature(signature, pubKey []byte) bool {
+	if len(signature) == 0 || len(pubKey) == 0 || len(pubKey)%2 != 0 {
+		return false
+	}
+	hashType := SigHashType(signature[len(signature)-1])
+	sigHash, err := e.tx.SigHash(e.txinIdx, e.prevOut, hashType)
+	if err != nil {
+		return false
+	}
+
+	var x, y big.Int
+	keyLen := len(pubKey)
+	x.SetBytes(pubKey[:(keyLen / 2)])
+	y.SetBytes(pubKey[(keyLen / 2):])
+	rawPubKey := ecdsa.PublicKey{Curve: elliptic.P256(), X: &x, Y: &y}
+	return ecdsa.VerifyASN1(&rawPubKey, sigHash, signature[:len(signature)-1])
+}
+
+func (e *engine) verify(op Opcode) error {
+	value, err := e.pop()
+	if err != nil {
+		return err
+	}
+	if !isTrue(value) {
+		return fmt.Errorf("%w: %s failed", ErrScriptFailed, opcodeNames[op])
+	}
+	return nil
+}
+
+func (e *engine) push(value []byte) {
+	e.stack = append(e.stack, value)
+}
+
+func (e *engine) peek() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, fmt.Errorf("%w: empty stack", ErrScriptFailed)
+	}
+	return e.stack[len(e.stack)-1], nil
+}
+
+func (e *engine) pop() ([]byte, error) {
+	value, err := e.peek()
+	if err != nil {
+		return nil, err
+	}
+	e.stack = e.stack[:len(e.stack)-1]
+	return value, nil
+}
+
+// popN pops n values, which are returned in the order they were pushed
+func (e *engine) popN(n int) ([][]byte, error) {
+	if len(e.stack) < n {
+		return nil, fmt.Errorf("%w: empty stack", ErrScriptFailed)
+	}
+	values := append([][]byte{}, e.stack[len(e.stack)-n:]...)
+	e.stack = e.stack[:len(e.stack)-n]
+	return values, nil
+}
+
+func (e *engine) popNum() (uint32, error) {
+	value, err := e.pop()
+	if err != nil {
+		return 0, err
+	}
+	return parseScriptNum(value)
+}
+
+// parseScriptNum decodes a number encoded by scriptNum
+func parseScriptNum(value []byte) (uint32, error) {
+	if len(value) > maxScriptNumSize {
+		return 0, fmt.Errorf("%w: number of %d bytes", ErrScriptFailed, len(value))
+	}
+	n := uint32(0)
+	for _, b := range value {
+		n = n<<8 | uint32(b)
+	}
+	return n, nil
+}
+
+// isTrue checks if the value is true, which is any value with a byte that isn't 0
+func isTrue(value []byte) bool {
+	for _, b := range value {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func boolValue(b bool) []byte {
+	if b {
+		return []byte{1}
+	}
+	return []byte{}
+}
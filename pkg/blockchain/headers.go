@@ -0,0 +1,305 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"jotacoin/pkg/database"
+	"jotacoin/pkg/utils"
+	"math/big"
+)
+
+// headerPrefix is the prefix of the keys where the headers downloaded before their
+// blocks are stored, so the sync can be resumed after a restart
+const headerPrefix = "header-"
+
+// bestHeaderKey is the key of the hash of the header with the most cumulative work
+var bestHeaderKey = []byte("bestHeader")
+
+func headerKey(hash []byte) []byte {
+	return append([]byte(headerPrefix), hash...)
+}
+
+// getHeaderTxn returns the header of the block with the hash passed as argument, which
+// can be stored as a header or as a whole block
+func getHeaderTxn(txn database.Txn, hash []byte) (*BlockHeader, error) {
+	val, err := txn.Get(headerKey(hash))
+	if err == database.ErrKeyNotFound {
+		block, err := getBlockTxn(txn, hash)
+		if err != nil {
+			return nil, err
+		}
+		return &block.Header, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header := &BlockHeader{}
+	decoder := gob.NewDecoder(bytes.NewReader(val))
+	err = decoder.Decode(header)
+	return header, err
+}
+
+// getBestHeaderTxn returns the hash of the header with the most work, which is the
+// chain tip if no header has more work than it
+func getBestHeaderTxn(txn database.Txn) ([]byte, error) {
+	bestHeader, err := txn.Get(bestHeaderKey)
+	if err == database.ErrKeyNotFound {
+		lastHash, err := getLastHashTxn(txn)
+		if err == database.ErrKeyNotFound {
+			return []byte{}, nil
+		}
+		return lastHash, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// a block connected after the headers sync may have more work than the best header
+	lastHash, err := getLastHashTxn(txn)
+	if err != nil {
+		return bestHeader, nil
+	}
+	lastWork, err := getWork(txn, lastHash)
+	if err != nil {
+		return nil, err
+	}
+	bestWork, err := getWork(txn, bestHeader)
+	if err != nil {
+		return nil, err
+	}
+	if lastWork.Cmp(bestWork) > 0 {
+		return lastHash, nil
+	}
+	return bestHeader, nil
+}
+
+// AddHeaders validates the headers (without their transactions) and stores them, so
+// their blocks can be downloaded later. Each header must follow a stored header or
+// block. The headers already stored are skipped
+func (chain *Blockchain) AddHeaders(headers []BlockHeader) error {
+	return chain.DB.Update(func(txn database.Txn) error {
+		bestHeader, err := getBestHeaderTxn(txn)
+		if err != nil {
+			return err
+		}
+		bestWork, err := getWork(txn, bestHeader)
+		if err != nil {
+			return err
+		}
+
+		for i := range headers {
+			header := &headers[i]
+			hash := header.Hash()
+			if _, err := getHeaderTxn(txn, hash); err == nil {
+				continue
+			}
+			if len(header.PrevHash) == 0 && len(bestHeader) > 0 {
+				return newValidationError(ErrBadPrevHash, nil, "the chain already has a genesis")
+			}
+
+			err = checkHeader(txn, header, hash)
+			if err != nil {
+				return err
+			}
+			work, err := getWork(txn, header.PrevHash)
+			if err != nil {
+				return err
+			}
+			work.Add(work, blockProof(header.Bits))
+
+			serializedHeader, err := utils.Serialize(header)
+			if err != nil {
+				return err
+			}
+			err = txn.Set(headerKey(hash), serializedHeader)
+			if err != nil {
+				return err
+			}
+			err = txn.Set(workKey(hash), work.Bytes())
+			if err != nil {
+				return err
+			}
+
+			if work.Cmp(bestWork) > 0 {
+				bestHeader, bestWork = hash, work
+				err = txn.Set(bestHeaderKey, hash)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// invalidateHeaders flags the blocks and the stored headers that descend from them as
+// invalid, and chooses the best header again among the headers that aren't flagged
+func invalidateHeaders(txn database.Txn, hashes [][]byte) error {
+	children := make(map[string][][]byte)
+	err := txn.Iterate([]byte(headerPrefix), func(key, val []byte) error {
+		header := &BlockHeader{}
+		decoder := gob.NewDecoder(bytes.NewReader(val))
+		err := decoder.Decode(header)
+		if err != nil {
+			return err
+		}
+		prevHash := string(header.PrevHash)
+		children[prevHash] = append(children[prevHash], key[len(headerPrefix):])
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for len(hashes) > 0 {
+		hash := hashes[0]
+		hashes = append(hashes[1:], children[string(hash)]...)
+		err = txn.Set(invalidKey(hash), []byte{})
+		if err != nil {
+			return err
+		}
+	}
+
+	// the descendants of the flagged headers are always flagged, so the best header is
+	// the one with the most work among the rest
+	var bestHeader []byte
+	var bestWork *big.Int
+	for _, hashes := range children {
+		for _, hash := range hashes {
+			invalid, err := isInvalid(txn, hash)
+			if err != nil {
+				return err
+			}
+			if invalid {
+				continue
+			}
+			work, err := getWork(txn, hash)
+			if err != nil {
+				return err
+			}
+			if bestWork == nil || work.Cmp(bestWork) > 0 {
+				bestHeader, bestWork = hash, work
+			}
+		}
+	}
+	if bestHeader == nil {
+		return txn.Delete(bestHeaderKey)
+	}
+	return txn.Set(bestHeaderKey, bestHeader)
+}
+
+// InvalidateBlock flags the block (or header), which can't be in the main chain, as
+// invalid along with its stored descendants, so they aren't downloaded nor accepted
+func (chain *Blockchain) InvalidateBlock(hash []byte) error {
+	return chain.DB.Update(func(txn database.Txn) error {
+		header, err := getHeaderTxn(txn, hash)
+		if err != nil {
+			return fmt.Errorf("header %x: %w", hash, err)
+		}
+		mainHash, err := getHashAtTxn(txn, header.Height)
+		if err != nil && err != ErrBlockNotFound {
+			return err
+		}
+		if bytes.Equal(mainHash, hash) {
+			return fmt.Errorf("blockchain: block %x is in the main chain", hash)
+		}
+		return invalidateHeaders(txn, [][]byte{hash})
+	})
+}
+
+// BestHeader returns the header with the most cumulative work, whose block may not be
+// downloaded yet, and its hash. It returns nil if the chain is empty
+func (chain *Blockchain) BestHeader() (*BlockHeader, []byte, error) {
+	var header *BlockHeader
+	var hash []byte
+
+	err := chain.DB.View(func(txn database.Txn) error {
+		var err error
+		hash, err = getBestHeaderTxn(txn)
+		if err != nil || len(hash) == 0 {
+			return err
+		}
+		header, err = getHeaderTxn(txn, hash)
+		return err
+	})
+	return header, hash, err
+}
+
+// HeaderLocator is like Locator, but starting from the best header instead of the tip
+func (chain *Blockchain) HeaderLocator() ([][]byte, error) {
+	var locator [][]byte
+
+	err := chain.DB.View(func(txn database.Txn) error {
+		hash, err := getBestHeaderTxn(txn)
+		if err != nil {
+			return err
+		}
+		locator, err = locatorFrom(txn, hash)
+		return err
+	})
+	return locator, err
+}
+
+// MissingBlocks returns the headers (at most max, in ascending order) of the blocks of
+// the best header chain that aren't downloaded yet
+func (chain *Blockchain) MissingBlocks(max int) ([]*BlockHeader, error) {
+	var missing []*BlockHeader
+
+	err := chain.DB.View(func(txn database.Txn) error {
+		hash, err := getBestHeaderTxn(txn)
+		if err != nil {
+			return err
+		}
+
+		// the ancestors of a stored block are always stored, so the missing blocks
+		// are the ones after the last stored block
+		for len(hash) > 0 {
+			_, err := txn.Get(hash)
+			if err == nil {
+				break
+			}
+			if err != database.ErrKeyNotFound {
+				return err
+			}
+
+			header, err := getHeaderTxn(txn, hash)
+			if err != nil {
+				return err
+			}
+			missing = append(missing, header)
+			hash = header.PrevHash
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(missing)-1; i < j; i, j = i+1, j-1 {
+		missing[i], missing[j] = missing[j], missing[i]
+	}
+	if len(missing) > max {
+		missing = missing[:max]
+	}
+	return missing, nil
+}
+
+// Headers returns the headers of the stored blocks (or headers) with the hashes passed
+// as argument
+func (chain *Blockchain) Headers(hashes [][]byte) ([]BlockHeader, error) {
+	var headers []BlockHeader
+
+	err := chain.DB.View(func(txn database.Txn) error {
+		for _, hash := range hashes {
+			header, err := getHeaderTxn(txn, hash)
+			if err != nil {
+				return fmt.Errorf("header %x: %w", hash, err)
+			}
+			headers = append(headers, *header)
+		}
+		return nil
+	})
+	return headers, err
+}
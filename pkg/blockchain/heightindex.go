@@ -0,0 +1,84 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"jotacoin/pkg/database"
+)
+
+// heightPrefix is the prefix of the keys that map a height to the hash of the block of
+// the main chain at that height
+const heightPrefix = "height-"
+
+func heightKey(height int) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, uint32(height))
+	return append([]byte(heightPrefix), key...)
+}
+
+func getHashAtTxn(txn database.Txn, height int) ([]byte, error) {
+	if height < 0 {
+		return nil, ErrBlockNotFound
+	}
+	hash, err := txn.Get(heightKey(height))
+	if err == database.ErrKeyNotFound {
+		return nil, ErrBlockNotFound
+	}
+	return hash, err
+}
+
+// BlockHashAt returns the hash of the block of the main chain at the height passed as argument
+func (chain *Blockchain) BlockHashAt(height int) ([]byte, error) {
+	var hash []byte
+	err := chain.DB.View(func(txn database.Txn) error {
+		var err error
+		hash, err = getHashAtTxn(txn, height)
+		return err
+	})
+	return hash, err
+}
+
+// BlockAt returns the block of the main chain at the height passed as argument
+func (chain *Blockchain) BlockAt(height int) (*Block, error) {
+	var block *Block
+	err := chain.DB.View(func(txn database.Txn) error {
+		hash, err := getHashAtTxn(txn, height)
+		if err != nil {
+			return err
+		}
+		block, err = getBlockTxn(txn, hash)
+		return err
+	})
+	return block, err
+}
+
+// buildHeightIndex indexes the blocks of the main chain by height if the chain was
+// stored before the blocks were indexed
+func (chain *Blockchain) buildHeightIndex() error {
+	if len(chain.LastHash) == 0 {
+		return nil
+	}
+	tip, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		return err
+	}
+	if _, err = chain.BlockHashAt(tip.Header.Height); err != ErrBlockNotFound {
+		return err
+	}
+
+	batch := chain.DB.NewBatch()
+	iter := chain.Iterator()
+	for {
+		block, err := iter.Next()
+		if err == nil {
+			err = batch.Set(heightKey(block.Header.Height), block.Hash)
+		}
+		if err != nil {
+			batch.Cancel()
+			return err
+		}
+		if block.IsGenesis() {
+			break
+		}
+	}
+	return batch.Flush()
+}
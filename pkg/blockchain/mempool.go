@@ -0,0 +1,393 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"jotacoin/pkg/database"
+	"jotacoin/pkg/utils"
+	"sort"
+	"sync"
+	"time"
+)
+
+// mempoolPrefix is the prefix of the keys where the transactions of the mempool are
+// stored, so they survive between runs
+const mempoolPrefix = "mempool-"
+
+var (
+	// MaxMempoolSize is the maximum size, in bytes, of the transactions in the mempool
+	MaxMempoolSize = 1 << 20
+	// MaxBlockTxsSize is the maximum size, in bytes, of the transactions taken from the
+	// mempool to mine a block
+	MaxBlockTxsSize = 1 << 18
+)
+
+var (
+	// ErrTxInMempool is returned when the transaction is already in the mempool
+	ErrTxInMempool = errors.New("mempool: transaction already in the mempool")
+	// ErrMempoolConflict is returned when the transaction spends an output that is already
+	// spent by another transaction of the mempool
+	ErrMempoolConflict = errors.New("mempool: transaction conflicts with another transaction")
+	// ErrMempoolFull is returned when the mempool is full and the transaction fee rate
+	// isn't higher than the lowest fee rate of the mempool
+	ErrMempoolFull = errors.New("mempool: mempool is full")
+)
+
+// MempoolEntry is a transaction waiting in the mempool to be mined
+type MempoolEntry struct {
+	Tx      *Transaction
+	Fee     int
+	Size    int
+	AddedAt int64 // unix time in seconds
+}
+
+// hasHigherFeeRate checks if the fee per byte of the entry is higher than the other's,
+// the older entry wins when both are the same
+func (e *MempoolEntry) hasHigherFeeRate(other *MempoolEntry) bool {
+	rate, otherRate := e.Fee*other.Size, other.Fee*e.Size
+	if rate != otherRate {
+		return rate > otherRate
+	}
+	return e.AddedAt < other.AddedAt
+}
+
+// Mempool holds the validated transactions that aren't in a block yet. The transactions
+// of the mempool can only spend outputs that are already in the chain
+type Mempool struct {
+	chain   *Blockchain
+	mu      sync.Mutex
+	entries map[string]*MempoolEntry
+	spent   map[string]string // outpoint -> hash of the transaction that spends it
+	size    int
+}
+
+// NewMempool creates the mempool of the chain, loading the transactions stored in the
+// database. The stored transactions that aren't valid anymore are discarded. The chain
+// keeps the created mempool up to date (replacing any mempool created before)
+func NewMempool(chain *Blockchain) (*Mempool, error) {
+	mp := &Mempool{
+		chain:   chain,
+		entries: make(map[string]*MempoolEntry),
+		spent:   make(map[string]string),
+	}
+
+	var stored []*MempoolEntry
+	err := chain.DB.View(func(txn database.Txn) error {
+		return txn.Iterate([]byte(mempoolPrefix), func(key, val []byte) error {
+			entry, err := deserializeMempoolEntry(val)
+			if err != nil {
+				return err
+			}
+			stored = append(stored, entry)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range stored {
+		_, err = mp.add(entry.Tx, entry.AddedAt)
+		if err != nil {
+			err = mp.deleteStored(entry.Tx.HashID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	chain.mempool = mp
+	return mp, nil
+}
+
+func deserializeMempoolEntry(data []byte) (*MempoolEntry, error) {
+	entry := &MempoolEntry{}
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(entry)
+	return entry, err
+}
+
+// Add validates the transaction and adds it into the mempool. It returns the fee of
+// the transaction
+func (mp *Mempool) Add(tx *Transaction) (int, error) {
+	return mp.add(tx, time.Now().Unix())
+}
+
+func (mp *Mempool) add(tx *Transaction, addedAt int64) (int, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	txHash := hex.EncodeToString(tx.HashID)
+	if _, ok := mp.entries[txHash]; ok {
+		return 0, ErrTxInMempool
+	}
+	for _, txin := range tx.Inputs {
+		conflict, ok := mp.spent[string(outpoint(txin.PrevTxHash, txin.OutIdx))]
+		if ok {
+			return 0, fmt.Errorf("%w: both spend %x:%d (tx %s)",
+				ErrMempoolConflict, txin.PrevTxHash, txin.OutIdx, conflict)
+		}
+	}
+
+	fee, err := mp.chain.ValidateTransaction(tx)
+	if err != nil {
+		return 0, err
+	}
+	size, err := tx.Size()
+	if err != nil {
+		return 0, err
+	}
+	entry := &MempoolEntry{tx, fee, size, addedAt}
+
+	evicted, err := mp.makeRoom(entry)
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range evicted {
+		err = mp.remove(hex.EncodeToString(e.Tx.HashID))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	serializedEntry, err := utils.Serialize(entry)
+	if err != nil {
+		return 0, err
+	}
+	err = mp.chain.DB.Update(func(txn database.Txn) error {
+		return txn.Set(mempoolKey(tx.HashID), serializedEntry)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	mp.entries[txHash] = entry
+	mp.size += entry.Size
+	for _, txin := range tx.Inputs {
+		mp.spent[string(outpoint(txin.PrevTxHash, txin.OutIdx))] = txHash
+	}
+	return fee, nil
+}
+
+// makeRoom returns the entries with the lowest fee rates that must be evicted so the
+// new entry fits into the mempool
+func (mp *Mempool) makeRoom(entry *MempoolEntry) ([]*MempoolEntry, error) {
+	if mp.size+entry.Size <= MaxMempoolSize {
+		return nil, nil
+	}
+
+	sorted := mp.sortedEntries()
+	var evicted []*MempoolEntry
+	freed := 0
+	for i := len(sorted) - 1; i >= 0 && mp.size-freed+entry.Size > MaxMempoolSize; i-- {
+		if !entry.hasHigherFeeRate(sorted[i]) {
+			break
+		}
+		evicted = append(evicted, sorted[i])
+		freed += sorted[i].Size
+	}
+
+	if mp.size-freed+entry.Size > MaxMempoolSize {
+		return nil, ErrMempoolFull
+	}
+	return evicted, nil
+}
+
+// Remove removes the transaction from the mempool
+func (mp *Mempool) Remove(txHash []byte) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return mp.remove(hex.EncodeToString(txHash))
+}
+
+func (mp *Mempool) remove(txHash string) error {
+	entry, ok := mp.entries[txHash]
+	if !ok {
+		return nil
+	}
+
+	err := mp.deleteStored(entry.Tx.HashID)
+	if err != nil {
+		return err
+	}
+
+	delete(mp.entries, txHash)
+	mp.size -= entry.Size
+	for _, txin := range entry.Tx.Inputs {
+		delete(mp.spent, string(outpoint(txin.PrevTxHash, txin.OutIdx)))
+	}
+	return nil
+}
+
+func (mp *Mempool) deleteStored(txHash []byte) error {
+	return mp.chain.DB.Update(func(txn database.Txn) error {
+		return txn.Delete(mempoolKey(txHash))
+	})
+}
+
+// RemoveBlockTransactions removes the transactions included in the block and the ones
+// that conflict with them
+func (mp *Mempool) RemoveBlockTransactions(b *Block) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	for _, tx := range b.Transactions {
+		err := mp.remove(hex.EncodeToString(tx.HashID))
+		if err != nil {
+			return err
+		}
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for _, txin := range tx.Inputs {
+			conflict, ok := mp.spent[string(outpoint(txin.PrevTxHash, txin.OutIdx))]
+			if !ok {
+				continue
+			}
+			err = mp.remove(conflict)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// updateTip updates the mempool after the main chain changed: the transactions of the
+// connected blocks are removed, the ones that aren't valid against the new UTXO set are
+// discarded and the transactions of the disconnected blocks are added back
+func (mp *Mempool) updateTip(change *tipChange) error {
+	for _, b := range change.connected {
+		err := mp.RemoveBlockTransactions(b)
+		if err != nil {
+			return err
+		}
+	}
+	if len(change.disconnected) == 0 {
+		return nil
+	}
+
+	for _, entry := range mp.Entries() {
+		_, err := mp.chain.ValidateTransaction(entry.Tx)
+		if err == nil {
+			continue
+		}
+		err = mp.Remove(entry.Tx.HashID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the disconnected transactions that are in the new chain or that conflict with
+	// it are rejected, so the errors are ignored
+	for i := len(change.disconnected) - 1; i >= 0; i-- {
+		for _, tx := range change.disconnected[i].Transactions[1:] {
+			mp.Add(tx)
+		}
+	}
+	return nil
+}
+
+// Get returns the transaction of the mempool with the hash passed as argument, or nil
+// if there is no such transaction
+func (mp *Mempool) Get(txHash []byte) *Transaction {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	entry, ok := mp.entries[hex.EncodeToString(txHash)]
+	if !ok {
+		return nil
+	}
+	return entry.Tx
+}
+
+// IsSpent checks if the output is spent by a transaction of the mempool
+func (mp *Mempool) IsSpent(txHash []byte, outIdx int) bool {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	_, ok := mp.spent[string(outpoint(txHash, outIdx))]
+	return ok
+}
+
+// Entries returns the entries of the mempool ordered by fee rate, highest first
+func (mp *Mempool) Entries() []*MempoolEntry {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return mp.sortedEntries()
+}
+
+// Count returns the amount of transactions in the mempool
+func (mp *Mempool) Count() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return len(mp.entries)
+}
+
+// Size returns the size, in bytes, of the transactions in the mempool
+func (mp *Mempool) Size() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return mp.size
+}
+
+// SelectTransactions returns the transactions with the highest fee rates that fit into
+// maxSize bytes and the sum of their fees
+func (mp *Mempool) SelectTransactions(maxSize int) ([]*Transaction, int) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	var txs []*Transaction
+	size, fees := 0, 0
+	for _, entry := range mp.sortedEntries() {
+		if size+entry.Size > maxSize {
+			continue
+		}
+		txs = append(txs, entry.Tx)
+		size += entry.Size
+		fees += entry.Fee
+	}
+	return txs, fees
+}
+
+// BlockTemplate creates a block template, to be mined by a Miner, with a coinbase that
+// rewards the miner address with the block subsidy plus the fees of the transactions
+// selected from the mempool, followed by these transactions
+func (mp *Mempool) BlockTemplate(minerAddress string) (*Block, error) {
+	height, err := mp.chain.Height()
+	if err != nil {
+		return nil, err
+	}
+
+	txs, fees := mp.SelectTransactions(MaxBlockTxsSize)
+	cbtx, err := NewCoinbaseTx(minerAddress, "", BlockSubsidy(height+1)+fees)
+	if err != nil {
+		return nil, err
+	}
+
+	return mp.chain.NewBlockTemplate(append([]*Transaction{cbtx}, txs...))
+}
+
+func (mp *Mempool) sortedEntries() []*MempoolEntry {
+	var entries []*MempoolEntry
+	for _, entry := range mp.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].hasHigherFeeRate(entries[j])
+	})
+	return entries
+}
+
+func mempoolKey(txHash []byte) []byte {
+	return append([]byte(mempoolPrefix), txHash...)
+}
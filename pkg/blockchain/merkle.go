@@ -0,0 +1,128 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+// The leaves and the inner nodes are hashed with different prefixes, so a leaf can
+// never be confused with an inner node
+const (
+	merkleLeafPrefix = byte(0x00)
+	merkleNodePrefix = byte(0x01)
+)
+
+// MerkleTree is a binary hash tree of the transactions of a block. When a level has an
+// odd amount of nodes, the last one is moved up to the next level without being hashed
+type MerkleTree struct {
+	// Levels stores the hashes of each level, starting from the leaves until the root
+	Levels [][][]byte
+}
+
+// MerkleProofStep is a sibling hash used to go one level up in the tree
+type MerkleProofStep struct {
+	Hash []byte
+	Left bool // if the sibling is on the left of the current hash
+}
+
+// MerkleProof is the path from a leaf to the root of the tree
+type MerkleProof []MerkleProofStep
+
+// NewMerkleTree creates a merkle tree using each element of data as a leaf
+func NewMerkleTree(data [][]byte) *MerkleTree {
+	var leaves [][]byte
+	for _, d := range data {
+		leaves = append(leaves, merkleLeafHash(d))
+	}
+	if len(leaves) == 0 {
+		empty := sha256.Sum256([]byte{})
+		leaves = append(leaves, empty[:])
+	}
+
+	tree := &MerkleTree{[][][]byte{leaves}}
+	level := leaves
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, merkleNodeHash(level[i], level[i+1]))
+		}
+
+		tree.Levels = append(tree.Levels, next)
+		level = next
+	}
+
+	return tree
+}
+
+// Root returns the merkle root
+func (t *MerkleTree) Root() []byte {
+	return t.Levels[len(t.Levels)-1][0]
+}
+
+// Proof returns the proof that the leaf of index idx is included in the tree
+func (t *MerkleTree) Proof(idx int) (MerkleProof, error) {
+	if idx < 0 || idx >= len(t.Levels[0]) {
+		return nil, errors.New("merkle: leaf index out of range")
+	}
+
+	proof := MerkleProof{}
+	for _, level := range t.Levels[:len(t.Levels)-1] {
+		sibling := idx ^ 1
+		if sibling < len(level) {
+			proof = append(proof, MerkleProofStep{level[sibling], sibling < idx})
+		}
+		idx /= 2
+	}
+
+	return proof, nil
+}
+
+// MerkleProof returns the proof that the transaction is included in the block
+func (b *Block) MerkleProof(txID []byte) (MerkleProof, error) {
+	for idx, tx := range b.Transactions {
+		if bytes.Equal(tx.HashID, txID) {
+			return b.merkleTree().Proof(idx)
+		}
+	}
+
+	return nil, errors.New("merkle: transaction not found in the block")
+}
+
+// VerifyMerkleProof checks if the proof leads from the transaction to the merkle root
+func VerifyMerkleProof(root, txID []byte, proof MerkleProof) bool {
+	hash := merkleLeafHash(txID)
+	for _, step := range proof {
+		if step.Left {
+			hash = merkleNodeHash(step.Hash, hash)
+		} else {
+			hash = merkleNodeHash(hash, step.Hash)
+		}
+	}
+
+	return bytes.Equal(hash, root)
+}
+
+func (b *Block) merkleTree() *MerkleTree {
+	var txHashes [][]byte
+	for _, tx := range b.Transactions {
+		txHashes = append(txHashes, tx.HashID)
+	}
+
+	return NewMerkleTree(txHashes)
+}
+
+func merkleLeafHash(data []byte) []byte {
+	hash := sha256.Sum256(append([]byte{merkleLeafPrefix}, data...))
+	return hash[:]
+}
+
+func merkleNodeHash(left, right []byte) []byte {
+	data := append([]byte{merkleNodePrefix}, left...)
+	hash := sha256.Sum256(append(data, right...))
+	return hash[:]
+}
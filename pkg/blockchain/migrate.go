@@ -0,0 +1,150 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"jotacoin/pkg/database"
+	"jotacoin/pkg/utils"
+)
+
+// DBVersion is the version of the database format written by this node. The databases
+// without version are the ones written before the canonical serialization, with the
+// blocks and the mempool encoded with gob. Since version 2 the keys of the UTXO set and
+// the address index have the type of the address (see addressKey)
+const DBVersion = 2
+
+// dbVersionKey is the key of the version of the database format
+var dbVersionKey = []byte("dbVersion")
+
+// legacyTransaction and legacyBlock have the fields of the types as they were encoded
+// with gob, before Transaction and Block had a canonical serialization
+type legacyTransaction struct {
+	HashID  []byte
+	Inputs  []TxInput
+	Outputs []TxOutput
+}
+
+type legacyBlock struct {
+	Header       BlockHeader
+	Hash         []byte
+	Transactions []*legacyTransaction
+}
+
+// toTransaction converts the legacy transaction, which keeps its hash because it can't
+// be calculated with the canonical serialization
+func (tx *legacyTransaction) toTransaction() *Transaction {
+	return &Transaction{LegacyTxVersion, tx.HashID, tx.Inputs, tx.Outputs, 0}
+}
+
+// migrateDB updates the database to the current format. The stored blocks are serialized
+// again keeping the hashes of their transactions, so the UTXO set and the indexes are
+// still valid. The mempool transactions are discarded, since they would be legacy ones
+func migrateDB(store database.Store) error {
+	version, err := dbVersion(store)
+	if err != nil || version == DBVersion {
+		return err
+	}
+	if version > DBVersion {
+		return fmt.Errorf("blockchain: database version %d is newer than %d", version, DBVersion)
+	}
+
+	// the blocks are checked before writing anything, so a database that can't be
+	// migrated is left as it was
+	err = store.View(func(txn database.Txn) error {
+		return txn.Iterate(nil, func(key, val []byte) error {
+			if len(key) != sha256.Size {
+				return nil
+			}
+			_, err := migratedBlock(key, val)
+			return err
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	// the address keys without type are dropped before the version is set, and the chain
+	// builds them again when it's opened (see Blockchain.ensureIndexes)
+	if version < 2 {
+		err = store.DropPrefix([]byte(utxoAddrPrefix), []byte(addrIndexPrefix))
+		if err != nil {
+			return err
+		}
+	}
+
+	// the writes don't fit into a transaction, but running the migration again after
+	// an interruption skips what was already migrated, since the version is set the last
+	batch := store.NewBatch()
+	err = store.View(func(txn database.Txn) error {
+		return txn.Iterate(nil, func(key, val []byte) error {
+			switch {
+			case len(key) == sha256.Size:
+				// the blocks are the only keys without prefix
+				return migrateBlock(batch, key, val)
+			case bytes.HasPrefix(key, []byte(mempoolPrefix)):
+				if _, err := deserializeMempoolEntry(val); err != nil {
+					return batch.Delete(key)
+				}
+			}
+			return nil
+		})
+	})
+	if err == nil {
+		err = batch.Set(dbVersionKey, utils.ToHex(DBVersion))
+	}
+	if err != nil {
+		batch.Cancel()
+		return err
+	}
+	return batch.Flush()
+}
+
+func dbVersion(store database.Store) (int64, error) {
+	var version int64
+	err := store.View(func(txn database.Txn) error {
+		val, err := txn.Get(dbVersionKey)
+		if err == database.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		d := &decoder{val}
+		version, err = d.readInt64()
+		return err
+	})
+	return version, err
+}
+
+func migrateBlock(batch database.Batch, key, val []byte) error {
+	block, err := migratedBlock(key, val)
+	if err != nil || block == nil {
+		return err
+	}
+	return batch.Set(key, block.Serialize())
+}
+
+// migratedBlock decodes a block encoded with gob, returning nil if it's already migrated.
+// It fails if the block doesn't have a header, instead of migrating it with a zero one
+func migratedBlock(key, val []byte) (*Block, error) {
+	if isPreHeaderBlock(val) {
+		return nil, fmt.Errorf("%w: block %x", ErrPreHeaderDB, key)
+	}
+	var legacy legacyBlock
+	err := gob.NewDecoder(bytes.NewReader(val)).Decode(&legacy)
+	if err != nil {
+		// already migrated
+		return nil, nil
+	}
+	if legacy.Header.Version == 0 {
+		return nil, fmt.Errorf("blockchain: migrating block %x: the block has no header", key)
+	}
+
+	block := &Block{Header: legacy.Header, Hash: legacy.Hash}
+	for _, tx := range legacy.Transactions {
+		block.Transactions = append(block.Transactions, tx.toTransaction())
+	}
+	return block, nil
+}
@@ -0,0 +1,104 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/binary"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// Miner mines blocks splitting the work between several goroutines
+type Miner struct {
+	// Workers is the amount of goroutines used to mine, if it's lower than 1 the amount
+	// of CPUs is used
+	Workers int
+
+	hashes    uint64
+	startedAt int64 // unix nano
+	stoppedAt int64 // unix nano, 0 while mining
+}
+
+// NewMiner creates a miner that uses the amount of workers passed as argument
+func NewMiner(workers int) *Miner {
+	return &Miner{Workers: workers}
+}
+
+// Mine runs the proof of work of the block, setting its nonce and its hash. When the
+// nonce space is exhausted, the extra nonce of the coinbase is incremented (which changes
+// the merkle root) and the mining restarts. The mining can be aborted cancelling ctx
+func (m *Miner) Mine(ctx context.Context, b *Block) error {
+	atomic.StoreUint64(&m.hashes, 0)
+	atomic.StoreInt64(&m.startedAt, time.Now().UnixNano())
+	atomic.StoreInt64(&m.stoppedAt, 0)
+	defer atomic.StoreInt64(&m.stoppedAt, time.Now().UnixNano())
+
+	var coinbaseData []byte
+	if len(b.Transactions) > 0 && b.Transactions[0].IsCoinbase() {
+		coinbaseData = b.Transactions[0].Inputs[0].PubKey
+	}
+	b.Header.MerkleRoot = b.HashTransactions()
+
+	for extraNonce := uint64(1); ; extraNonce++ {
+		nonce, hash, err := NewProof(b).Run(ctx, m.workers(), &m.hashes)
+		if err == nil {
+			b.Header.Nonce = nonce
+			b.Hash = hash
+			return nil
+		}
+		if err != ErrNonceExhausted || coinbaseData == nil {
+			return err
+		}
+
+		err = b.Transactions[0].setExtraNonce(coinbaseData, extraNonce)
+		if err != nil {
+			return err
+		}
+		b.Header.MerkleRoot = b.HashTransactions()
+	}
+}
+
+// Hashes returns the amount of hashes tried by the current (or the last) mining
+func (m *Miner) Hashes() uint64 {
+	return atomic.LoadUint64(&m.hashes)
+}
+
+// HashRate returns the hashes per second of the current (or the last) mining
+func (m *Miner) HashRate() float64 {
+	startedAt := atomic.LoadInt64(&m.startedAt)
+	stoppedAt := atomic.LoadInt64(&m.stoppedAt)
+	if startedAt == 0 {
+		return 0
+	}
+	if stoppedAt == 0 {
+		stoppedAt = time.Now().UnixNano()
+	}
+
+	elapsed := time.Duration(stoppedAt - startedAt).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(m.Hashes()) / elapsed
+}
+
+func (m *Miner) workers() int {
+	if m.Workers < 1 {
+		return runtime.NumCPU()
+	}
+	return m.Workers
+}
+
+// setExtraNonce appends the extra nonce to the original data of the coinbase and
+// updates the coinbase hash
+func (tx *Transaction) setExtraNonce(data []byte, extraNonce uint64) error {
+	extra := make([]byte, 8)
+	binary.BigEndian.PutUint64(extra, extraNonce)
+	tx.Inputs[0].PubKey = append(append([]byte{}, data...), extra...)
+
+	hash, err := tx.Hash()
+	if err != nil {
+		return err
+	}
+	tx.HashID = hash
+	return nil
+}
@@ -0,0 +1,198 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"jotacoin/pkg/wallet"
+)
+
+// The multisig addresses are P2SH addresses whose redeem script is a MultisigScript. A
+// transaction that spends their outputs is created by NewMultisigTransaction without
+// signatures, with the redeem script in the unlocking script of its inputs, and each
+// co-signer adds its signature with SignMultisig until the required ones are present:
+//
+//	<signature 1> ... <signature m> <redeemScript>
+
+// maxSignatureSize is the maximum size of a signature in an unlocking script: the DER
+// signature (up to 72 bytes with P256), the sighash type and the push opcode
+const maxSignatureSize = 74
+
+var (
+	// ErrNotMultisig is returned when an input doesn't spend a P2SH multisig output
+	ErrNotMultisig = errors.New("multisig: input doesn't spend a multisig output")
+	// ErrNotCosigner is returned when signing with a key that isn't one of the multisig keys
+	ErrNotCosigner = errors.New("multisig: the key isn't one of the multisig keys")
+)
+
+// MultisigAddress returns the P2SH address of the redeem script of a multisig
+func MultisigAddress(redeemScript []byte) (string, error) {
+	if _, _, ok := extractMultisig(redeemScript); !ok {
+		return "", fmt.Errorf("%w: not a multisig script", ErrBadScript)
+	}
+	if len(redeemScript) > MaxScriptElementSize {
+		return "", fmt.Errorf("%w: %d bytes", ErrRedeemScriptSize, len(redeemScript))
+	}
+	scriptHash, err := wallet.PublicKeyHash(redeemScript)
+	if err != nil {
+		return "", err
+	}
+	return wallet.ScriptHashToAddress(scriptHash), nil
+}
+
+// NewMultisigTransaction creates a transaction that pays amount from the multisig address
+// of redeemScript to the address to, with the change back to the multisig address. Its
+// inputs aren't signed (see SignMultisig). The fee rate takes into account the size of
+// the signatures that will be added
+func NewMultisigTransaction(
+	redeemScript []byte, to string, amount int, opts TxOptions, chain *Blockchain,
+) (*Transaction, error) {
+	err := opts.checkAmount(amount)
+	if err != nil {
+		return nil, err
+	}
+	from, err := MultisigAddress(redeemScript)
+	if err != nil {
+		return nil, err
+	}
+	m, _, _ := extractMultisig(redeemScript)
+
+	template := TxInput{Script: P2SHUnlockingScript(nil, redeemScript)}
+	return buildWithFee(opts, func(fee int) (*Transaction, int, error) {
+		tx, err := buildTransaction(from, to, amount, fee, template, chain)
+		if err != nil {
+			return nil, 0, err
+		}
+		tx.HashID, err = tx.Hash()
+		if err != nil {
+			return nil, 0, err
+		}
+		size, err := tx.Size()
+		return tx, size + len(tx.Inputs)*m*maxSignatureSize, err
+	})
+}
+
+// multisigInput returns the signatures and the redeem script of the unlocking script of
+// an input that spends a P2SH multisig output
+func (txin *TxInput) multisigInput() ([][]byte, []byte, error) {
+	instructions, err := ParseScript(txin.Script)
+	if err != nil || len(instructions) == 0 {
+		return nil, nil, ErrNotMultisig
+	}
+
+	var values [][]byte
+	for _, in := range instructions {
+		if !in.IsPush() || len(in.Data) == 0 {
+			return nil, nil, ErrNotMultisig
+		}
+		values = append(values, in.Data)
+	}
+	redeemScript := values[len(values)-1]
+	if _, _, ok := extractMultisig(redeemScript); !ok {
+		return nil, nil, ErrNotMultisig
+	}
+	return values[:len(values)-1], redeemScript, nil
+}
+
+// MultisigStatus returns the amount of signatures of an input that spends a P2SH multisig
+// output and the amount of signatures it requires
+func (txin *TxInput) MultisigStatus() (int, int, error) {
+	signatures, redeemScript, err := txin.multisigInput()
+	if err != nil {
+		return 0, 0, err
+	}
+	m, _, _ := extractMultisig(redeemScript)
+	return len(signatures), m, nil
+}
+
+// SignMultisig adds the signature of w, committing to the parts of the transaction
+// selected by hashType, to the inputs that spend a P2SH multisig output with its public
+// key and that don't have the required signatures yet. It returns the amount of signed
+// inputs. prevOuts are the outputs spent by the inputs, in the same order (see
+// Blockchain.PrevOutputs). The hash of the transaction is updated
+func (tx *Transaction) SignMultisig(w *wallet.Wallet, prevOuts []TxOutput, hashType SigHashType) (int, error) {
+	if len(prevOuts) != len(tx.Inputs) {
+		return 0, errors.New("transaction: there must be a previous output for each input")
+	}
+
+	signedInputs := 0
+	for txinIdx := range tx.Inputs {
+		signed, err := tx.signMultisigInput(txinIdx, w.PrivateKey, w.PublicKey, prevOuts[txinIdx], hashType)
+		if errors.Is(err, ErrNotMultisig) || errors.Is(err, ErrNotCosigner) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if signed {
+			signedInputs++
+		}
+	}
+
+	hash, err := tx.Hash()
+	if err != nil {
+		return 0, err
+	}
+	tx.HashID = hash
+	return signedInputs, nil
+}
+
+// signMultisigInput adds the signature of privKey, whose public key is pubKey, to the
+// input txinIdx. The signatures are kept in the same order as their keys, so the
+// co-signers can sign in any order, and the ones that aren't valid are removed. It
+// returns false if the key already signed or the input has the required signatures
+func (tx *Transaction) signMultisigInput(
+	txinIdx int, privKey *ecdsa.PrivateKey, pubKey []byte, prevOut TxOutput, hashType SigHashType,
+) (bool, error) {
+	signatures, redeemScript, err := tx.Inputs[txinIdx].multisigInput()
+	if err != nil {
+		return false, err
+	}
+	scriptHash, err := wallet.PublicKeyHash(redeemScript)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(extractP2SH(prevOut.LockingScript()), scriptHash) {
+		return false, ErrNotMultisig
+	}
+
+	m, pubKeys, _ := extractMultisig(redeemScript)
+	keyIdx := -1
+	for k := range pubKeys {
+		if bytes.Equal(pubKeys[k], pubKey) {
+			keyIdx = k
+		}
+	}
+	if keyIdx == -1 {
+		return false, ErrNotCosigner
+	}
+
+	// the current signatures by the index of their key
+	e := &engine{tx: tx, txinIdx: txinIdx, prevOut: prevOut}
+	byKey := make(map[int][]byte)
+	for _, signature := range signatures {
+		for k := range pubKeys {
+			if e.checkSignature(signature, pubKeys[k]) {
+				byKey[k] = signature
+				break
+			}
+		}
+	}
+	if _, ok := byKey[keyIdx]; ok || len(byKey) >= m {
+		return false, nil
+	}
+
+	byKey[keyIdx], err = tx.InputSignature(txinIdx, privKey, prevOut, hashType)
+	if err != nil {
+		return false, err
+	}
+	signatures = nil
+	for k := range pubKeys {
+		if signature, ok := byKey[k]; ok {
+			signatures = append(signatures, signature)
+		}
+	}
+	tx.Inputs[txinIdx].Script = P2SHUnlockingScript(signatures, redeemScript)
+	return true, nil
+}
@@ -1,15 +1,21 @@
 package blockchain
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
-	"jotacoin/pkg/utils"
+	"encoding/binary"
+	"errors"
 	"math"
 	"math/big"
+	"sync"
+	"sync/atomic"
 )
 
-// Difficulty defines the difficult of the proof
-const Difficulty = 12
+// ctxCheckInterval is the amount of hashes a worker tries between each check of the context
+const ctxCheckInterval = 1 << 12
+
+// ErrNonceExhausted is returned when no nonce generates a valid hash for the block header
+var ErrNonceExhausted = errors.New("pow: nonce space exhausted")
 
 // ProofOfWork represents a struct that will be responsable to run the algorithm
 type ProofOfWork struct {
@@ -17,49 +23,108 @@ type ProofOfWork struct {
 	Target *big.Int
 }
 
-// NewProof creates a new Proof of Work struct according to the Difficulty variable value
+// NewProof creates a new Proof of Work struct according to the difficulty bits of the block header
 func NewProof(block *Block) *ProofOfWork {
 	target := big.NewInt(1)
-	target.Lsh(target, uint(256-Difficulty))
+	target.Lsh(target, uint(256-block.Header.Bits))
 	return &ProofOfWork{block, target}
 }
 
-// InitData transform the values of the block plus the nonce and difficulty to generate the hash afterwards
-func (pow *ProofOfWork) InitData(nonce int) []byte {
-	return bytes.Join(
-		[][]byte{
-			pow.Block.PrevHash,
-			pow.Block.HashTransactions(),
-			utils.ToHex(int64(nonce)),
-			utils.ToHex(int64(Difficulty)),
-		},
-		[]byte{},
-	)
+// InitData returns the serialized block header using the nonce passed as argument,
+// it's the data that is hashed by the proof of work
+func (pow *ProofOfWork) InitData(nonce uint32) []byte {
+	header := pow.Block.Header
+	header.Nonce = nonce
+	return header.Serialize()
 }
 
-// Run runs the proof of work and generates the nonce and the hash
-func (pow *ProofOfWork) Run() (int, []byte) {
+// Run runs the proof of work splitting the nonce space between the workers (each worker
+// tries the nonces first, first+workers, first+2*workers...). It stops as soon as one of
+// the workers finds a valid nonce, when the context is cancelled or when the nonce space
+// is exhausted (returning ErrNonceExhausted). Each hash tried is counted in hashes
+func (pow *ProofOfWork) Run(ctx context.Context, workers int, hashes *uint64) (uint32, []byte, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	workersCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := make(chan proofResult, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(first uint32) {
+			defer wg.Done()
+			nonce, hash, ok := pow.runWorker(workersCtx, first, uint32(workers), hashes)
+			if ok {
+				found <- proofResult{nonce, hash}
+				cancel()
+			}
+		}(uint32(w))
+	}
+	wg.Wait()
+	close(found)
+
+	if result, ok := <-found; ok {
+		return result.nonce, result.hash, nil
+	}
+	if ctx.Err() != nil {
+		return 0, nil, ctx.Err()
+	}
+	return 0, nil, ErrNonceExhausted
+}
+
+type proofResult struct {
+	nonce uint32
+	hash  []byte
+}
+
+// runWorker tries the nonces first, first+step, first+2*step... until a valid one is found
+func (pow *ProofOfWork) runWorker(
+	ctx context.Context, first, step uint32, hashes *uint64,
+) (uint32, []byte, bool) {
 	var intHash big.Int
-	var hash [sha256.Size]byte
-	var nonce int
+	var counted uint64
+
+	// the nonce is the last field of the serialized header, so only its bytes change
+	data := pow.InitData(0)
+	nonceBytes := data[len(data)-4:]
+	defer func() {
+		if hashes != nil {
+			atomic.AddUint64(hashes, counted)
+		}
+	}()
+
+	for nonce := uint64(first); nonce <= math.MaxUint32; nonce += uint64(step) {
+		if counted%ctxCheckInterval == 0 {
+			if ctx.Err() != nil {
+				return 0, nil, false
+			}
+			if hashes != nil {
+				atomic.AddUint64(hashes, counted)
+				counted = 0
+			}
+		}
 
-	for nonce = 0; nonce < math.MaxInt64; nonce++ {
-		data := pow.InitData(nonce)
-		hash = sha256.Sum256(data)
+		binary.BigEndian.PutUint32(nonceBytes, uint32(nonce))
+		hash := sha256.Sum256(data)
+		counted++
 
 		intHash.SetBytes(hash[:])
 		if intHash.Cmp(pow.Target) == -1 {
-			break
+			return uint32(nonce), hash[:], true
 		}
 	}
-	return nonce, hash[:]
+
+	return 0, nil, false
 }
 
 // IsValid checks the validation of the block
 func (pow *ProofOfWork) IsValid() bool {
 	var intHash big.Int
 
-	data := pow.InitData(pow.Block.Nonce)
+	data := pow.InitData(pow.Block.Header.Nonce)
 	hash := sha256.Sum256(data)
 	intHash.SetBytes(hash[:])
 
@@ -0,0 +1,346 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"jotacoin/pkg/wallet"
+	"sort"
+)
+
+// A PSBT (partially signed transaction) lets the inputs of a transaction be signed by
+// machines that don't have the chain, like an air-gapped wallet, and by several parties.
+// It's created where the chain is (see CreatePSBT), passed serialized to each signer
+// (see PSBT.Sign), the copies signed by different parties are combined (see PSBT.Combine)
+// and, once it has all the signatures, it's finalized into a transaction
+// (see PSBT.Finalize) that can be broadcast.
+//
+// The serialization follows the canonical one (see encoding.go):
+//
+//	psbt:  magic (4 bytes), unsigned tx (bytes), inputs count (uint32), inputs
+//	input: spent output (see TxOutput.encode), redeem script (bytes),
+//	       signatures count (uint32), signatures: pub key (bytes), signature (bytes)
+
+// psbtMagic starts every serialized PSBT
+var psbtMagic = []byte("psbt")
+
+// pubKeySize is the size of the public keys, the X and Y coordinates of the P256 point
+const pubKeySize = 64
+
+var (
+	// ErrPSBTMismatch is returned when combining PSBTs of different transactions
+	ErrPSBTMismatch = errors.New("psbt: the PSBTs are of different transactions")
+	// ErrPSBTIncomplete is returned when finalizing a PSBT without the required signatures
+	ErrPSBTIncomplete = errors.New("psbt: missing signatures")
+	// ErrPSBTUnsupported is returned when an input spends an output that isn't P2PKH nor
+	// a P2SH multisig, whose unlocking script can't be built from signatures
+	ErrPSBTUnsupported = errors.New("psbt: unsupported output")
+	// ErrPSBTRedeemScript is returned when the redeem script of an input isn't the one
+	// whose hash locks the output it spends
+	ErrPSBTRedeemScript = errors.New("psbt: the redeem script doesn't match the spent output")
+)
+
+// PSBT is a transaction without signatures, with the outputs spent by its inputs and the
+// signatures collected for each one of them
+type PSBT struct {
+	Tx     *Transaction
+	Inputs []PSBTInput
+}
+
+// PSBTInput is the data needed to sign and finalize an input of a PSBT
+type PSBTInput struct {
+	PrevOut TxOutput // output spent by the input, the signers commit to it
+	// RedeemScript is the multisig script of a P2SH output
+	RedeemScript []byte
+	// Signatures are the signatures of the input by the public key (as a string) that
+	// made them
+	Signatures map[string][]byte
+}
+
+// CreatePSBT creates a PSBT that pays amount from the address from to the address to,
+// with the change back to from. If from is a multisig address, redeemScript must be its
+// redeem script. The fee rate takes into account the signatures that will be added
+func CreatePSBT(
+	from, to string, amount int, redeemScript []byte, opts TxOptions, chain *Blockchain,
+) (*PSBT, error) {
+	err := opts.checkAmount(amount)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = wallet.AddressToPubKeyHash(from); err != nil {
+		return nil, err
+	}
+	if wallet.IsScriptHashAddress(from) {
+		address, err := MultisigAddress(redeemScript)
+		if err != nil {
+			return nil, err
+		}
+		if address != from {
+			return nil, errors.New("psbt: the redeem script isn't the one of the address")
+		}
+	} else {
+		redeemScript = nil
+	}
+
+	var p *PSBT
+	_, err = buildWithFee(opts, func(fee int) (*Transaction, int, error) {
+		tx, err := buildTransaction(from, to, amount, fee, TxInput{}, chain)
+		if err != nil {
+			return nil, 0, err
+		}
+		prevOuts, err := chain.PrevOutputs(tx)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		p = &PSBT{Tx: tx}
+		size, err := tx.Size()
+		for _, prevOut := range prevOuts {
+			in := PSBTInput{prevOut, redeemScript, map[string][]byte{}}
+			size += in.maxUnlockingSize()
+			p.Inputs = append(p.Inputs, in)
+		}
+		return tx, size, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.Tx.HashID, err = p.Tx.Hash()
+	return p, err
+}
+
+// maxUnlockingSize returns the maximum size that the signatures (and the public key or
+// the redeem script) add to the input when the PSBT is finalized
+func (in *PSBTInput) maxUnlockingSize() int {
+	if m, _, ok := extractMultisig(in.RedeemScript); ok {
+		return m*maxSignatureSize + len(in.RedeemScript) + 3
+	}
+	return maxSignatureSize + pubKeySize + 4
+}
+
+// checkRedeemScript checks that the redeem script of the input txinIdx is the one whose
+// hash locks the P2SH output it spends, and that only those inputs have one
+func (in *PSBTInput) checkRedeemScript(txinIdx int) error {
+	if len(in.RedeemScript) == 0 && !in.PrevOut.IsP2SH() {
+		return nil
+	}
+	scriptHash, err := wallet.PublicKeyHash(in.RedeemScript)
+	if err != nil {
+		return err
+	}
+	if !in.PrevOut.IsP2SH() || !bytes.Equal(scriptHash, in.PrevOut.AddressHash()) {
+		return fmt.Errorf("%w: input %d", ErrPSBTRedeemScript, txinIdx)
+	}
+	return nil
+}
+
+// Sign adds the signatures of w, committing to the parts of the transaction selected by
+// hashType, to the inputs it can unlock: the ones that spend an output locked to its
+// public key hash or a multisig output with its public key. It doesn't need the chain,
+// as the signatures commit to the spent outputs of the PSBT (so they're invalid if the
+// outputs are wrong), but it fails with ErrPSBTRedeemScript if a redeem script isn't
+// the one of its output. It returns the amount of signed inputs
+func (p *PSBT) Sign(w *wallet.Wallet, hashType SigHashType) (int, error) {
+	pubKeyHash, err := wallet.PublicKeyHash(w.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	signed := 0
+	for txinIdx := range p.Inputs {
+		in := &p.Inputs[txinIdx]
+		if err = in.checkRedeemScript(txinIdx); err != nil {
+			return 0, err
+		}
+		if _, ok := in.Signatures[string(w.PublicKey)]; ok {
+			continue
+		}
+		canSign := in.PrevOut.IsP2PKH() && bytes.Equal(in.PrevOut.AddressHash(), pubKeyHash)
+		if _, pubKeys, ok := extractMultisig(in.RedeemScript); ok {
+			for _, pubKey := range pubKeys {
+				canSign = canSign || bytes.Equal(pubKey, w.PublicKey)
+			}
+		}
+		if !canSign {
+			continue
+		}
+
+		signature, err := p.Tx.InputSignature(txinIdx, w.PrivateKey, in.PrevOut, hashType)
+		if err != nil {
+			return 0, err
+		}
+		if in.Signatures == nil {
+			in.Signatures = make(map[string][]byte)
+		}
+		in.Signatures[string(w.PublicKey)] = signature
+		signed++
+	}
+	return signed, nil
+}
+
+// Combine adds the signatures of other, a copy of the same PSBT signed by other parties
+func (p *PSBT) Combine(other *PSBT) error {
+	if !bytes.Equal(p.Tx.Serialize(), other.Tx.Serialize()) || len(p.Inputs) != len(other.Inputs) {
+		return ErrPSBTMismatch
+	}
+	for txinIdx := range p.Inputs {
+		in, otherIn := &p.Inputs[txinIdx], &other.Inputs[txinIdx]
+		if !bytes.Equal(in.PrevOut.serialize(), otherIn.PrevOut.serialize()) ||
+			!bytes.Equal(in.RedeemScript, otherIn.RedeemScript) {
+			return ErrPSBTMismatch
+		}
+		if in.Signatures == nil {
+			in.Signatures = make(map[string][]byte)
+		}
+		for pubKey, signature := range otherIn.Signatures {
+			in.Signatures[pubKey] = signature
+		}
+	}
+	return nil
+}
+
+// InputStatus returns the amount of signatures of the input txinIdx and the amount of
+// signatures it requires
+func (p *PSBT) InputStatus(txinIdx int) (int, int) {
+	in := p.Inputs[txinIdx]
+	if m, _, ok := extractMultisig(in.RedeemScript); ok {
+		return len(in.Signatures), m
+	}
+	return len(in.Signatures), 1
+}
+
+// Finalize returns the signed transaction, building the unlocking data of each input from
+// its signatures: the signature and the public key for the P2PKH outputs and the P2SH
+// unlocking script for the multisig outputs. It fails with ErrPSBTIncomplete if an input
+// doesn't have the required valid signatures and with ErrPSBTRedeemScript if a redeem
+// script isn't the one of its output
+func (p *PSBT) Finalize() (*Transaction, error) {
+	tx := &Transaction{p.Tx.Version, nil, append([]TxInput{}, p.Tx.Inputs...), p.Tx.Outputs, p.Tx.LockTime}
+	for txinIdx, in := range p.Inputs {
+		txin := &tx.Inputs[txinIdx]
+		txin.Signature, txin.PubKey, txin.Script = nil, nil, nil
+		e := &engine{tx: p.Tx, txinIdx: txinIdx, prevOut: in.PrevOut}
+		if err := in.checkRedeemScript(txinIdx); err != nil {
+			return nil, err
+		}
+
+		switch m, pubKeys, ok := extractMultisig(in.RedeemScript); {
+		case ok && in.PrevOut.IsP2SH():
+			var signatures [][]byte
+			for _, pubKey := range pubKeys {
+				signature, ok := in.Signatures[string(pubKey)]
+				if ok && len(signatures) < m && e.checkSignature(signature, pubKey) {
+					signatures = append(signatures, signature)
+				}
+			}
+			txin.Script = P2SHUnlockingScript(signatures, in.RedeemScript)
+		case in.PrevOut.IsP2PKH():
+			for _, pubKey := range sortedKeys(in.Signatures) {
+				pubKeyHash, err := wallet.PublicKeyHash([]byte(pubKey))
+				if err != nil {
+					return nil, err
+				}
+				if bytes.Equal(pubKeyHash, in.PrevOut.AddressHash()) {
+					txin.Signature, txin.PubKey = in.Signatures[pubKey], []byte(pubKey)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("%w: input %d", ErrPSBTUnsupported, txinIdx)
+		}
+
+		if err := tx.VerifyInput(txinIdx, in.PrevOut); err != nil {
+			return nil, fmt.Errorf("%w: input %d: %v", ErrPSBTIncomplete, txinIdx, err)
+		}
+	}
+
+	hash, err := tx.Hash()
+	if err != nil {
+		return nil, err
+	}
+	tx.HashID = hash
+	return tx, nil
+}
+
+func sortedKeys(signatures map[string][]byte) []string {
+	var keys []string
+	for key := range signatures {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (txout *TxOutput) serialize() []byte {
+	e := &encoder{}
+	txout.encode(e)
+	return e.Bytes()
+}
+
+// Serialize returns the serialization of the PSBT
+func (p *PSBT) Serialize() []byte {
+	e := &encoder{}
+	e.Write(psbtMagic)
+	e.writeBytes(p.Tx.Serialize())
+	e.writeUint32(uint32(len(p.Inputs)))
+	for i := range p.Inputs {
+		in := &p.Inputs[i]
+		in.PrevOut.encode(e)
+		e.writeBytes(in.RedeemScript)
+		e.writeUint32(uint32(len(in.Signatures)))
+		for _, pubKey := range sortedKeys(in.Signatures) {
+			e.writeBytes([]byte(pubKey))
+			e.writeBytes(in.Signatures[pubKey])
+		}
+	}
+	return e.Bytes()
+}
+
+// DeserializePSBT decodes a PSBT serialized by PSBT.Serialize
+func DeserializePSBT(data []byte) (*PSBT, error) {
+	d := &decoder{data}
+	magic, err := d.next(len(psbtMagic))
+	if err != nil || !bytes.Equal(magic, psbtMagic) {
+		return nil, ErrMalformedData
+	}
+	serializedTx, err := d.readBytes()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := DeserializeTransaction(serializedTx)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &PSBT{Tx: tx}
+	count, err := d.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	if int(count) != len(tx.Inputs) {
+		return nil, ErrMalformedData
+	}
+	for i := uint32(0); i < count; i++ {
+		in := PSBTInput{Signatures: make(map[string][]byte)}
+		if err = in.PrevOut.decode(d); err != nil {
+			return nil, err
+		}
+		if in.RedeemScript, err = readScript(d); err != nil {
+			return nil, err
+		}
+		signatures, err := d.readUint32()
+		if err != nil {
+			return nil, err
+		}
+		for j := uint32(0); j < signatures; j++ {
+			pubKey, err := d.readBytes()
+			if err != nil {
+				return nil, err
+			}
+			if in.Signatures[string(pubKey)], err = d.readBytes(); err != nil {
+				return nil, err
+			}
+		}
+		p.Inputs = append(p.Inputs, in)
+	}
+	return p, d.end()
+}
@@ -0,0 +1,232 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"jotacoin/pkg/database"
+	"jotacoin/pkg/utils"
+	"math/big"
+)
+
+const (
+	// workPrefix is the prefix of the keys that map a block hash to the cumulative work
+	// of the chain ending at that block
+	workPrefix = "work-"
+	// undoPrefix is the prefix of the keys that map a block hash to the outputs spent by
+	// the block, so it can be disconnected from the UTXO set
+	undoPrefix = "undo-"
+	// invalidPrefix is the prefix of the keys that flag a block as invalid, because it
+	// or one of its ancestors failed to connect
+	invalidPrefix = "invalid-"
+)
+
+// tipChange describes how the main chain changed after storing a block: the blocks
+// disconnected from the old tip (tip first) and the blocks connected to the new tip
+// (in ascending order)
+type tipChange struct {
+	disconnected []*Block
+	connected    []*Block
+}
+
+func workKey(hash []byte) []byte {
+	return append([]byte(workPrefix), hash...)
+}
+
+func undoKey(hash []byte) []byte {
+	return append([]byte(undoPrefix), hash...)
+}
+
+func invalidKey(hash []byte) []byte {
+	return append([]byte(invalidPrefix), hash...)
+}
+
+// invalidBranchError is returned when a block fails to connect. The transaction where
+// it happened is discarded, so the blocks are flagged as invalid in a new one
+type invalidBranchError struct {
+	blocks [][]byte
+	err    error
+}
+
+func (e *invalidBranchError) Error() string {
+	return e.err.Error()
+}
+
+func (e *invalidBranchError) Unwrap() error {
+	return e.err
+}
+
+// invalidBranch wraps the error of a block that failed to connect, so the block and
+// its descendants are flagged as invalid. Only validation errors flag the blocks
+func invalidBranch(err error, blocks []*Block) error {
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		return err
+	}
+	invalid := &invalidBranchError{err: err}
+	for _, b := range blocks {
+		invalid.blocks = append(invalid.blocks, b.Hash)
+	}
+	return invalid
+}
+
+// isInvalid returns if the block (or header) was flagged as invalid
+func isInvalid(txn database.Txn, hash []byte) (bool, error) {
+	_, err := txn.Get(invalidKey(hash))
+	if err == database.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// blockProof returns the expected amount of hashes needed to mine a block with the
+// difficulty bits passed as argument, which is 2^bits
+func blockProof(bits int) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(bits))
+}
+
+// getWork returns the cumulative work of the chain ending at the block (or header). The
+// blocks stored before the work was tracked don't have it, so it's calculated from the
+// closest ancestor that has it
+func getWork(txn database.Txn, hash []byte) (*big.Int, error) {
+	work := new(big.Int)
+	for len(hash) > 0 {
+		val, err := txn.Get(workKey(hash))
+		if err == nil {
+			return work.Add(work, new(big.Int).SetBytes(val)), nil
+		}
+		if err != database.ErrKeyNotFound {
+			return nil, err
+		}
+
+		header, err := getHeaderTxn(txn, hash)
+		if err != nil {
+			return nil, err
+		}
+		work.Add(work, blockProof(header.Bits))
+		hash = header.PrevHash
+	}
+	return work, nil
+}
+
+func getUndo(txn database.Txn, hash []byte) ([]TxOutput, error) {
+	var spent []TxOutput
+
+	val, err := txn.Get(undoKey(hash))
+	if err == database.ErrKeyNotFound {
+		return nil, fmt.Errorf("reorg: block %x can't be disconnected, it has no undo data", hash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	decoder := gob.NewDecoder(bytes.NewReader(val))
+	err = decoder.Decode(&spent)
+	return spent, err
+}
+
+func putUndo(txn database.Txn, hash []byte, spent []TxOutput) error {
+	serializedUndo, err := utils.Serialize(spent)
+	if err != nil {
+		return err
+	}
+	return txn.Set(undoKey(hash), serializedUndo)
+}
+
+// connectBlock validates the transactions of the block, whose parent must be the tip,
+// applies it to the UTXO set and the indexes and makes it the new tip
+func connectBlock(txn database.Txn, b *Block) error {
+	err := validateTransactions(txn, b)
+	if err != nil {
+		return err
+	}
+	err = updateUTXO(txn, b)
+	if err != nil {
+		return err
+	}
+	err = indexAddresses(txn, b)
+	if err != nil {
+		return err
+	}
+	err = indexTransactions(txn, b)
+	if err != nil {
+		return err
+	}
+	err = txn.Set(heightKey(b.Header.Height), b.Hash)
+	if err != nil {
+		return err
+	}
+	return txn.Set([]byte("lastHash"), b.Hash)
+}
+
+// disconnectBlock reverts the tip block from the UTXO set and the indexes and makes its
+// parent the new tip
+func disconnectBlock(txn database.Txn, b *Block) error {
+	err := revertUTXO(txn, b)
+	if err != nil {
+		return err
+	}
+	err = unindexAddresses(txn, b)
+	if err != nil {
+		return err
+	}
+	err = unindexTransactions(txn, b)
+	if err != nil {
+		return err
+	}
+	err = txn.Delete(heightKey(b.Header.Height))
+	if err != nil {
+		return err
+	}
+	return txn.Set([]byte("lastHash"), b.Header.PrevHash)
+}
+
+// reorganize switches the main chain from the current tip to the branch ending at
+// newTip, disconnecting the blocks down to the fork point and connecting the blocks of
+// the new branch. If any block of the new branch is invalid, the error is returned and
+// the caller must discard the transaction and flag the branch from that block
+func reorganize(txn database.Txn, tip, newTip *Block) (*tipChange, error) {
+	change := &tipChange{}
+
+	// walk both branches back until they meet at the fork point
+	oldBranch, newBranch := tip, newTip
+	for !bytes.Equal(oldBranch.Hash, newBranch.Hash) {
+		var err error
+		if oldBranch.Header.Height >= newBranch.Header.Height {
+			change.disconnected = append(change.disconnected, oldBranch)
+			oldBranch, err = getBlockTxn(txn, oldBranch.Header.PrevHash)
+		} else {
+			change.connected = append([]*Block{newBranch}, change.connected...)
+
+			// a branch with a block that already failed isn't connected again
+			var invalid bool
+			invalid, err = isInvalid(txn, newBranch.Hash)
+			if err != nil {
+				return nil, err
+			}
+			if invalid {
+				err = newValidationError(ErrInvalidChain, nil, fmt.Sprintf("block %x", newBranch.Hash))
+				return nil, invalidBranch(err, change.connected)
+			}
+			newBranch, err = getBlockTxn(txn, newBranch.Header.PrevHash)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, b := range change.disconnected {
+		err := disconnectBlock(txn, b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, b := range change.connected {
+		err := connectBlock(txn, b)
+		if err != nil {
+			return nil, invalidBranch(err, change.connected[i:])
+		}
+	}
+
+	return change, nil
+}
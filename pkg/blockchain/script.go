@@ -0,0 +1,362 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The scripts lock the outputs and unlock them in the inputs. A script is a sequence of
+// opcodes executed by a stack machine (see engine.go), without loops, so it always ends.
+// The unlocking script of an input is executed first and then the locking script of the
+// output it spends, over the same stack: the input can spend the output if the result is
+// true. The outputs without script are locked with the P2PKH template to their
+// PubKeyHash, and the inputs without script unlock them with their Signature and PubKey
+
+// Opcode is an instruction of a script
+type Opcode byte
+
+// Opcodes of the scripts. The opcodes from 0x01 to 0x4b push the next n bytes
+const (
+	Op0                   Opcode = 0x00 // pushes an empty value (false)
+	OpPushData1           Opcode = 0x4c // pushes the next n bytes, n is the next byte
+	OpPushData2           Opcode = 0x4d // pushes the next n bytes, n is the next 2 bytes
+	Op1                   Opcode = 0x51 // OP_1 to OP_16 push the numbers 1 to 16
+	Op16                  Opcode = 0x60
+	OpVerify              Opcode = 0x69 // fails if the top value is false, pops it otherwise
+	OpDrop                Opcode = 0x75 // pops the top value
+	OpDup                 Opcode = 0x76 // duplicates the top value
+	OpEqual               Opcode = 0x87 // pops two values and pushes if they're equal
+	OpEqualVerify         Opcode = 0x88 // OpEqual followed by OpVerify
+	OpSHA256              Opcode = 0xa8 // replaces the top value by its SHA-256
+	OpHash160             Opcode = 0xa9 // replaces the top value by its RIPEMD-160 of SHA-256
+	OpCheckSig            Opcode = 0xac // pops a public key and a signature and pushes if it's valid
+	OpCheckSigVerify      Opcode = 0xad // OpCheckSig followed by OpVerify
+	OpCheckMultiSig       Opcode = 0xae // checks m of n signatures, see engine.checkMultiSig
+	OpCheckLockTimeVerify Opcode = 0xb1 // fails if the top value is greater than the tx lock time
+)
+
+const (
+	// MaxScriptSize is the maximum size, in bytes, of a script
+	MaxScriptSize = 10000
+	// MaxScriptElementSize is the maximum size, in bytes, of a value pushed to the stack
+	MaxScriptElementSize = 520
+	// MaxScriptOps is the maximum amount of opcodes (not counting the pushes) of a script
+	MaxScriptOps = 201
+	// maxStackSize is the maximum amount of values in the stack
+	maxStackSize = 1000
+	// maxScriptNumSize is the maximum size, in bytes, of the values used as numbers
+	maxScriptNumSize = 4
+)
+
+var (
+	// ErrBadScript is returned when a script can't be parsed or doesn't follow the limits
+	ErrBadScript = errors.New("script: malformed script")
+	// ErrRedeemScriptSize is returned when a redeem script is bigger than
+	// MaxScriptElementSize, so it can't be pushed by the unlocking scripts that spend it
+	ErrRedeemScriptSize = errors.New("script: redeem script too big")
+)
+
+var opcodeNames = map[Opcode]string{
+	Op0:                   "0",
+	OpVerify:              "VERIFY",
+	OpDrop:                "DROP",
+	OpDup:                 "DUP",
+	OpEqual:               "EQUAL",
+	OpEqualVerify:         "EQUALVERIFY",
+	OpSHA256:              "SHA256",
+	OpHash160:             "HASH160",
+	OpCheckSig:            "CHECKSIG",
+	OpCheckSigVerify:      "CHECKSIGVERIFY",
+	OpCheckMultiSig:       "CHECKMULTISIG",
+	OpCheckLockTimeVerify: "CHECKLOCKTIMEVERIFY",
+}
+
+// Instruction is an opcode of a parsed script with the data it pushes, if any
+type Instruction struct {
+	Op   Opcode
+	Data []byte
+}
+
+// IsPush checks if the instruction only pushes a value to the stack
+func (in Instruction) IsPush() bool {
+	return in.Op <= OpPushData2 || (in.Op >= Op1 && in.Op <= Op16)
+}
+
+// ParseScript splits the script into instructions, checking the limits of the size of
+// the script, of the pushed values and of the amount of opcodes
+func ParseScript(script []byte) ([]Instruction, error) {
+	if len(script) > MaxScriptSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrBadScript, len(script))
+	}
+
+	var instructions []Instruction
+	ops := 0
+	for i := 0; i < len(script); {
+		op := Opcode(script[i])
+		i++
+
+		size := 0
+		switch {
+		case op == Op0:
+		case op < OpPushData1:
+			size = int(op)
+		case op == OpPushData1 && i+1 <= len(script):
+			size = int(script[i])
+			i++
+		case op == OpPushData2 && i+2 <= len(script):
+			size = int(binary.BigEndian.Uint16(script[i:]))
+			i += 2
+		case op == OpPushData1 || op == OpPushData2:
+			return nil, fmt.Errorf("%w: truncated push", ErrBadScript)
+		case op >= Op1 && op <= Op16:
+		default:
+			if _, ok := opcodeNames[op]; !ok {
+				return nil, fmt.Errorf("%w: unknown opcode %#x", ErrBadScript, byte(op))
+			}
+			ops++
+		}
+		if size > MaxScriptElementSize || i+size > len(script) {
+			return nil, fmt.Errorf("%w: invalid push of %d bytes", ErrBadScript, size)
+		}
+
+		var data []byte
+		if size > 0 {
+			data = script[i : i+size]
+			i += size
+		}
+		instructions = append(instructions, Instruction{op, data})
+	}
+
+	if ops > MaxScriptOps {
+		return nil, fmt.Errorf("%w: %d opcodes", ErrBadScript, ops)
+	}
+	return instructions, nil
+}
+
+// IsPushOnly checks if the script is valid and only pushes values, like the unlocking scripts
+func IsPushOnly(script []byte) bool {
+	instructions, err := ParseScript(script)
+	if err != nil {
+		return false
+	}
+	for _, in := range instructions {
+		if !in.IsPush() {
+			return false
+		}
+	}
+	return true
+}
+
+// DisasmScript returns the script in a human readable form: the opcodes by their names
+// and the pushed values in hexadecimal
+func DisasmScript(script []byte) (string, error) {
+	instructions, err := ParseScript(script)
+	if err != nil {
+		return "", err
+	}
+
+	var parts []string
+	for _, in := range instructions {
+		switch {
+		case in.Op >= Op1 && in.Op <= Op16:
+			parts = append(parts, fmt.Sprint(int(in.Op-Op1)+1))
+		case in.Op != Op0 && in.IsPush():
+			parts = append(parts, hex.EncodeToString(in.Data))
+		default:
+			parts = append(parts, opcodeNames[in.Op])
+		}
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// ScriptBuilder builds a script adding its opcodes one by one
+type ScriptBuilder struct {
+	script bytes.Buffer
+}
+
+// AddOp adds the opcodes to the script
+func (b *ScriptBuilder) AddOp(ops ...Opcode) *ScriptBuilder {
+	for _, op := range ops {
+		b.script.WriteByte(byte(op))
+	}
+	return b
+}
+
+// AddData adds the smallest push of the data, which is Op0 for empty data
+func (b *ScriptBuilder) AddData(data []byte) *ScriptBuilder {
+	switch {
+	case len(data) == 0:
+		b.script.WriteByte(byte(Op0))
+	case len(data) < int(OpPushData1):
+		b.script.WriteByte(byte(len(data)))
+	case len(data) <= 0xff:
+		b.script.WriteByte(byte(OpPushData1))
+		b.script.WriteByte(byte(len(data)))
+	default:
+		b.script.WriteByte(byte(OpPushData2))
+		binary.Write(&b.script, binary.BigEndian, uint16(len(data)))
+	}
+	b.script.Write(data)
+	return b
+}
+
+// AddInt adds the push of a number: Op1 to Op16 for the numbers from 1 to 16 and the
+// number encoded by scriptNum otherwise
+func (b *ScriptBuilder) AddInt(n uint32) *ScriptBuilder {
+	if n >= 1 && n <= 16 {
+		return b.AddOp(Op1 + Opcode(n-1))
+	}
+	return b.AddData(scriptNum(n))
+}
+
+// Script returns the built script
+func (b *ScriptBuilder) Script() []byte {
+	return append([]byte{}, b.script.Bytes()...)
+}
+
+// scriptNum encodes a number as the values used by the scripts: unsigned big endian
+// without leading zeros (so 0 is empty)
+func scriptNum(n uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, n)
+	return bytes.TrimLeft(buf, "\x00")
+}
+
+// P2PKHScript returns the locking script that pays to a public key hash (P2PKH): it can
+// be unlocked with a signature and the public key whose hash is pubKeyHash
+//
+//	DUP HASH160 <pubKeyHash> EQUALVERIFY CHECKSIG
+func P2PKHScript(pubKeyHash []byte) []byte {
+	b := &ScriptBuilder{}
+	b.AddOp(OpDup, OpHash160).AddData(pubKeyHash).AddOp(OpEqualVerify, OpCheckSig)
+	return b.Script()
+}
+
+// P2PKHUnlockingScript returns the unlocking script of a P2PKH output
+//
+//	<signature> <pubKey>
+func P2PKHUnlockingScript(signature, pubKey []byte) []byte {
+	b := &ScriptBuilder{}
+	return b.AddData(signature).AddData(pubKey).Script()
+}
+
+// P2SHScript returns the locking script that pays to a script hash (P2SH): it can be
+// unlocked with the script whose HASH160 is scriptHash, the redeem script, and the values
+// that make it succeed. The redeem script is executed after the locking script
+//
+//	HASH160 <scriptHash> EQUAL
+func P2SHScript(scriptHash []byte) []byte {
+	b := &ScriptBuilder{}
+	return b.AddOp(OpHash160).AddData(scriptHash).AddOp(OpEqual).Script()
+}
+
+// P2SHUnlockingScript returns the unlocking script of a P2SH output
+//
+//	<value 1> ... <value n> <redeemScript>
+func P2SHUnlockingScript(values [][]byte, redeemScript []byte) []byte {
+	b := &ScriptBuilder{}
+	for _, value := range values {
+		b.AddData(value)
+	}
+	return b.AddData(redeemScript).Script()
+}
+
+// MultisigScript returns the script that requires m signatures of the n public keys, in
+// the same order as the keys (see engine.checkMultiSig). It's used as the redeem script
+// of the P2SH multisig addresses, so it fails with ErrRedeemScriptSize if it can't be
+// pushed (with the P256 keys, above 7 keys)
+//
+//	<m> <pubKey 1> ... <pubKey n> <n> CHECKMULTISIG
+func MultisigScript(m int, pubKeys [][]byte) ([]byte, error) {
+	if len(pubKeys) == 0 || len(pubKeys) > 16 || m < 1 || m > len(pubKeys) {
+		return nil, fmt.Errorf("%w: %d of %d multisig", ErrBadScript, m, len(pubKeys))
+	}
+	b := &ScriptBuilder{}
+	b.AddInt(uint32(m))
+	for _, pubKey := range pubKeys {
+		b.AddData(pubKey)
+	}
+	script := b.AddInt(uint32(len(pubKeys))).AddOp(OpCheckMultiSig).Script()
+	if len(script) > MaxScriptElementSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrRedeemScriptSize, len(script))
+	}
+	return script, nil
+}
+
+// extractP2PKH returns the public key hash of a P2PKH locking script, or nil if the
+// script isn't one
+func extractP2PKH(script []byte) []byte {
+	instructions, err := ParseScript(script)
+	if err != nil || len(instructions) != 5 {
+		return nil
+	}
+	if instructions[0].Op != OpDup || instructions[1].Op != OpHash160 ||
+		len(instructions[2].Data) != pubKeyHashSize ||
+		instructions[3].Op != OpEqualVerify || instructions[4].Op != OpCheckSig {
+		return nil
+	}
+	return instructions[2].Data
+}
+
+// extractP2SH returns the script hash of a P2SH locking script, or nil if the script
+// isn't one
+func extractP2SH(script []byte) []byte {
+	instructions, err := ParseScript(script)
+	if err != nil || len(instructions) != 3 {
+		return nil
+	}
+	if instructions[0].Op != OpHash160 || len(instructions[1].Data) != pubKeyHashSize ||
+		instructions[2].Op != OpEqual {
+		return nil
+	}
+	return instructions[1].Data
+}
+
+// extractMultisig returns the amount of required signatures and the public keys of a
+// multisig script, or false if the script isn't one
+func extractMultisig(script []byte) (int, [][]byte, bool) {
+	instructions, err := ParseScript(script)
+	if err != nil || len(instructions) < 4 {
+		return 0, nil, false
+	}
+	last := len(instructions) - 1
+	m, n := instructions[0].Op, instructions[last-1].Op
+	if instructions[last].Op != OpCheckMultiSig || m < Op1 || m > Op16 || n < Op1 || n > Op16 ||
+		m > n || int(n-Op1)+1 != last-2 {
+		return 0, nil, false
+	}
+
+	var pubKeys [][]byte
+	for _, in := range instructions[1 : last-1] {
+		if len(in.Data) == 0 {
+			return 0, nil, false
+		}
+		pubKeys = append(pubKeys, in.Data)
+	}
+	return int(m-Op1) + 1, pubKeys, true
+}
+
+// HashLockScript returns a locking script that can be unlocked by revealing the preimage
+// of hash (its SHA-256) and signing with the key of pubKeyHash, so nobody else can spend
+// it after seeing the preimage
+//
+//	SHA256 <hash> EQUALVERIFY DUP HASH160 <pubKeyHash> EQUALVERIFY CHECKSIG
+func HashLockScript(hash, pubKeyHash []byte) []byte {
+	b := &ScriptBuilder{}
+	b.AddOp(OpSHA256).AddData(hash).AddOp(OpEqualVerify)
+	return append(b.Script(), P2PKHScript(pubKeyHash)...)
+}
+
+// TimeLockScript returns a locking script that can only be unlocked by the key of
+// pubKeyHash in a transaction whose lock time is at least height
+//
+//	<height> CHECKLOCKTIMEVERIFY DROP DUP HASH160 <pubKeyHash> EQUALVERIFY CHECKSIG
+func TimeLockScript(height uint32, pubKeyHash []byte) []byte {
+	b := &ScriptBuilder{}
+	b.AddInt(height).AddOp(OpCheckLockTimeVerify, OpDrop)
+	return append(b.Script(), P2PKHScript(pubKeyHash)...)
+}
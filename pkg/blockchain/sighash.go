@@ -0,0 +1,146 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SigHashType selects the parts of the transaction that a signature commits to. It's
+// appended to the signature, so each input can use a different one
+type SigHashType byte
+
+const (
+	// SigHashAll commits to all the inputs and all the outputs
+	SigHashAll SigHashType = 0x01
+	// SigHashNone commits to all the inputs but to none of the outputs, so anyone can
+	// choose where the coins go
+	SigHashNone SigHashType = 0x02
+	// SigHashSingle commits to all the inputs and to the output with the same index as
+	// the signed input, the rest of the outputs can change
+	SigHashSingle SigHashType = 0x03
+	// SigHashAnyoneCanPay is combined with the other types to commit only to the signed
+	// input, so anyone can add more inputs (e.g. to fund a payment between several parties)
+	SigHashAnyoneCanPay SigHashType = 0x80
+)
+
+var (
+	// ErrBadSigHashType is returned when the sighash type isn't a valid combination
+	ErrBadSigHashType = errors.New("transaction: invalid sighash type")
+	// ErrSigHashSingle is returned when an input is signed with SigHashSingle but there
+	// isn't an output with its same index
+	ErrSigHashSingle = errors.New("transaction: SINGLE signature without a matching output")
+)
+
+var sigHashNames = map[SigHashType]string{
+	SigHashAll:    "ALL",
+	SigHashNone:   "NONE",
+	SigHashSingle: "SINGLE",
+}
+
+// ParseSigHashType parses a sighash type written as ALL, NONE or SINGLE, optionally
+// followed by |ANYONECANPAY (case insensitive)
+func ParseSigHashType(s string) (SigHashType, error) {
+	name, modifier, found := strings.Cut(strings.ToUpper(s), "|")
+	var hashType SigHashType
+	for t, tName := range sigHashNames {
+		if tName == name {
+			hashType = t
+		}
+	}
+	if hashType == 0 || (found && modifier != "ANYONECANPAY") {
+		return 0, fmt.Errorf("%w: %q", ErrBadSigHashType, s)
+	}
+	if found {
+		hashType |= SigHashAnyoneCanPay
+	}
+	return hashType, nil
+}
+
+func (t SigHashType) String() string {
+	name, ok := sigHashNames[t.base()]
+	if !ok {
+		return fmt.Sprintf("INVALID(%#x)", byte(t))
+	}
+	if t.AnyoneCanPay() {
+		name += "|ANYONECANPAY"
+	}
+	return name
+}
+
+// base returns the type without the SigHashAnyoneCanPay modifier
+func (t SigHashType) base() SigHashType {
+	return t &^ SigHashAnyoneCanPay
+}
+
+// AnyoneCanPay checks if the type has the SigHashAnyoneCanPay modifier
+func (t SigHashType) AnyoneCanPay() bool {
+	return t&SigHashAnyoneCanPay != 0
+}
+
+// SigHashType returns the sighash type appended to the signature of the input, or 0 if
+// the input isn't signed
+func (txin *TxInput) SigHashType() SigHashType {
+	if len(txin.Signature) == 0 {
+		return 0
+	}
+	return SigHashType(txin.Signature[len(txin.Signature)-1])
+}
+
+// SigHash returns the digest signed by the input txinIdx, which spends prevOut. It always
+// commits to the version, the lock time, the sighash type and the lock and value of the
+// spent output. The type selects the rest:
+//
+//   - the outpoints of all the inputs and the index of the signed input, or only the
+//     outpoint of the signed input with SigHashAnyoneCanPay
+//   - all the outputs with SigHashAll, none with SigHashNone or the output with the same
+//     index as the signed input (and the index) with SigHashSingle
+//
+// The signatures and public keys aren't part of it, so the inputs can be signed in any order
+func (tx *Transaction) SigHash(txinIdx int, prevOut TxOutput, hashType SigHashType) ([]byte, error) {
+	if _, ok := sigHashNames[hashType.base()]; !ok {
+		return nil, ErrBadSigHashType
+	}
+	if txinIdx < 0 || txinIdx >= len(tx.Inputs) {
+		return nil, fmt.Errorf("transaction: input %d out of range", txinIdx)
+	}
+	if hashType.base() == SigHashSingle && txinIdx >= len(tx.Outputs) {
+		return nil, ErrSigHashSingle
+	}
+
+	e := &encoder{}
+	e.writeUint32(uint32(tx.Version))
+	e.writeUint32(uint32(tx.LockTime))
+	e.WriteByte(byte(hashType))
+
+	inputs := tx.Inputs
+	if hashType.AnyoneCanPay() {
+		inputs = tx.Inputs[txinIdx : txinIdx+1]
+	}
+	e.writeUint32(uint32(len(inputs)))
+	for _, txin := range inputs {
+		e.writeBytes(txin.PrevTxHash)
+		e.writeUint32(uint32(int32(txin.OutIdx)))
+	}
+	if !hashType.AnyoneCanPay() {
+		e.writeUint32(uint32(txinIdx))
+	}
+	prevOut.encode(e)
+
+	switch hashType.base() {
+	case SigHashAll:
+		e.writeUint32(uint32(len(tx.Outputs)))
+		for i := range tx.Outputs {
+			tx.Outputs[i].encode(e)
+		}
+	case SigHashNone:
+		e.writeUint32(0)
+	case SigHashSingle:
+		e.writeUint32(uint32(txinIdx))
+		tx.Outputs[txinIdx].encode(e)
+	}
+
+	hash := sha256.Sum256(e.Bytes())
+	return hash[:], nil
+}
@@ -0,0 +1,47 @@
+package blockchain
+
+// InitialSubsidy is the amount of tokens created by the coinbase of the blocks before
+// the first halving
+const InitialSubsidy = 100
+
+// HalvingInterval is the amount of blocks between each halving of the subsidy
+var HalvingInterval = 210000
+
+// BlockSubsidy returns the amount of tokens created by the coinbase of the block at the
+// height passed as argument. The subsidy halves every HalvingInterval blocks until it
+// reaches 0, so the supply is capped
+func BlockSubsidy(height int) int {
+	halvings := height / HalvingInterval
+	if height < 0 || halvings >= 63 {
+		return 0
+	}
+	return InitialSubsidy >> uint(halvings)
+}
+
+// Supply returns the total amount of tokens issued by the emission schedule from the
+// genesis until the block at the height passed as argument (inclusive)
+func Supply(height int) int {
+	supply := 0
+	for eraStart := 0; eraStart <= height; eraStart += HalvingInterval {
+		subsidy := BlockSubsidy(eraStart)
+		if subsidy == 0 {
+			break
+		}
+
+		blocks := HalvingInterval
+		if height-eraStart+1 < blocks {
+			blocks = height - eraStart + 1
+		}
+		supply += subsidy * blocks
+	}
+	return supply
+}
+
+// MaxSupply returns the amount of tokens that will exist once the subsidy reaches 0
+func MaxSupply() int {
+	supply := 0
+	for subsidy := InitialSubsidy; subsidy > 0; subsidy >>= 1 {
+		supply += subsidy * HalvingInterval
+	}
+	return supply
+}
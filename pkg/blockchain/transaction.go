@@ -2,41 +2,53 @@ package blockchain
 
 import (
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"jotacoin/pkg/utils"
 	"jotacoin/pkg/wallet"
-	"math/big"
 )
 
-// CoinbaseValue is the predefined amount of tokens for a coinbase
-const CoinbaseValue = 100
+const (
+	// TxVersion is the version of the transactions created by this node
+	TxVersion = 1
+	// LegacyTxVersion is the version of the transactions created before the canonical
+	// serialization, whose hashes were calculated over their gob encoding. They're only
+	// kept by the migration of the database (see migrateDB), the new blocks can't have them
+	LegacyTxVersion = 0
+)
 
 // Transaction represents a transaction in a blockchain. For more information:
 // https://www.oreilly.com/library/view/mastering-bitcoin/9781491902639/ch05.html
 type Transaction struct {
-	HashID  []byte
-	Inputs  []TxInput
-	Outputs []TxOutput
+	Version  int
+	HashID   []byte
+	Inputs   []TxInput
+	Outputs  []TxOutput
+	LockTime int // height from which the transaction can be in a block, 0 if it isn't locked
 }
 
-// NewCoinbaseTx creates a coinbase and it "gives" to a receiver
-func NewCoinbaseTx(to, data string) (*Transaction, error) {
+// NewCoinbaseTx creates a coinbase and it "gives" value to a receiver. The value can't be
+// greater than the block subsidy (see BlockSubsidy) plus the fees of the block transactions
+func NewCoinbaseTx(to, data string, value int) (*Transaction, error) {
 	if data == "" {
-		data = fmt.Sprintf("Coins to %s", to)
+		// random data makes each coinbase (and so its hash) unique
+		randData := make([]byte, 20)
+		_, err := rand.Read(randData)
+		if err != nil {
+			return nil, err
+		}
+		data = fmt.Sprintf("Coins to %s (%x)", to, randData)
 	}
 
-	txin := TxInput{[]byte{}, -1, nil, []byte(data)}
-	txout, err := NewTxOutput(CoinbaseValue, to)
+	txin := TxInput{[]byte{}, -1, nil, []byte(data), nil}
+	txout, err := NewTxOutput(value, to)
 	if err != nil {
 		return nil, err
 	}
 
-	tx := &Transaction{nil, []TxInput{txin}, []TxOutput{*txout}}
+	tx := &Transaction{TxVersion, nil, []TxInput{txin}, []TxOutput{*txout}, 0}
 	hash, err := tx.Hash()
 	if err != nil {
 		return nil, err
@@ -51,10 +63,32 @@ func (tx *Transaction) IsCoinbase() bool {
 	return len(tx.Inputs) == 1 && len(tx.Inputs[0].PrevTxHash) == 0 && tx.Inputs[0].OutIdx == -1
 }
 
-// NewTransaction creates a normal transaction (one sender and one receiver)
-func NewTransaction(from, to string, amount int, chain *Blockchain) (*Transaction, error) {
-	var inputs []TxInput
-	var outputs []TxOutput
+// TxOptions are the options used to build a transaction
+type TxOptions struct {
+	// Fee is the amount of tokens paid to the miner
+	Fee int
+	// FeeRate is the amount of tokens paid to the miner per 1000 bytes of the transaction.
+	// The fee paid is the highest between Fee and the fee given by FeeRate
+	FeeRate int
+	// SigHashType selects the parts of the transaction committed by the signatures, it's
+	// SigHashAll if it isn't set
+	SigHashType SigHashType
+}
+
+// feeForSize returns the fee (rounded up) paid by a transaction of size bytes
+func (opts TxOptions) feeForSize(size int) int {
+	return (opts.FeeRate*size + 999) / 1000
+}
+
+// NewTransaction creates a normal transaction (one sender and one receiver). The fee
+// is implicit: it's the difference between the inputs and the outputs
+func NewTransaction(
+	from, to string, amount int, opts TxOptions, chain *Blockchain,
+) (*Transaction, error) {
+	err := opts.checkAmount(amount)
+	if err != nil {
+		return nil, err
+	}
 
 	wallets, err := wallet.LoadFile()
 	if err != nil {
@@ -64,13 +98,71 @@ func NewTransaction(from, to string, amount int, chain *Blockchain) (*Transactio
 	if w == nil {
 		return nil, errors.New("wallet: wallet not found")
 	}
-	pubKeyHash, err := wallet.PublicKeyHash(w.PublicKey)
-	if err != nil {
-		return nil, err
+	hashType := opts.SigHashType
+	if hashType == 0 {
+		hashType = SigHashAll
+	}
+
+	return buildWithFee(opts, func(fee int) (*Transaction, int, error) {
+		tx, err := buildTransaction(from, to, amount, fee, TxInput{PubKey: w.PublicKey}, chain)
+		if err != nil {
+			return nil, 0, err
+		}
+		prevOuts, err := chain.PrevOutputs(tx)
+		if err != nil {
+			return nil, 0, err
+		}
+		err = tx.Sign(w.PrivateKey, prevOuts, hashType)
+		if err != nil {
+			return nil, 0, err
+		}
+		tx.HashID, err = tx.Hash()
+		if err != nil {
+			return nil, 0, err
+		}
+		size, err := tx.Size()
+		return tx, size, err
+	})
+}
+
+// checkAmount checks that the amount to pay and the fee are valid
+func (opts TxOptions) checkAmount(amount int) error {
+	if amount <= 0 || opts.Fee < 0 || opts.FeeRate < 0 {
+		return errors.New("transaction: amount must be positive and fee can't be negative")
+	}
+	return nil
+}
+
+// buildWithFee builds the transaction with build, which returns it and its size, paying
+// opts.Fee. The size depends on the amount of inputs, which depends on the fee, so the
+// transaction is built again until the fee covers the fee rate
+func buildWithFee(opts TxOptions, build func(fee int) (*Transaction, int, error)) (*Transaction, error) {
+	fee := opts.Fee
+	for {
+		tx, size, err := build(fee)
+		if err != nil {
+			return nil, err
+		}
+
+		if fee >= opts.feeForSize(size) {
+			return tx, nil
+		}
+		fee = opts.feeForSize(size)
 	}
+}
+
+// buildTransaction builds a transaction, without signing it, that pays amount to the
+// address to and the change to the address from, spending the outputs locked to from.
+// Each input is a copy of template (which has the fields that unlock the
+// outputs of from, like its public key) that spends one of the outputs
+func buildTransaction(
+	from, to string, amount, fee int, template TxInput, chain *Blockchain,
+) (*Transaction, error) {
+	var inputs []TxInput
+	var outputs []TxOutput
 
-	acc, spendableTxs := chain.FindSpendableTxOutputs(pubKeyHash, amount)
-	if acc < amount {
+	acc, spendableTxs := chain.FindSpendableTxOutputs(from, amount+fee)
+	if acc < amount+fee {
 		return nil, errors.New("transaction: not enough balance from the sender")
 	}
 
@@ -81,7 +173,8 @@ func NewTransaction(from, to string, amount int, chain *Blockchain) (*Transactio
 		}
 
 		for _, outIdx := range outsIdxs {
-			input := TxInput{prevTxID, outIdx, nil, w.PublicKey}
+			input := template
+			input.PrevTxHash, input.OutIdx = prevTxID, outIdx
 			inputs = append(inputs, input)
 		}
 	}
@@ -91,112 +184,114 @@ func NewTransaction(from, to string, amount int, chain *Blockchain) (*Transactio
 		return nil, err
 	}
 	outputs = append(outputs, *newOutput)
-	if acc > amount {
-		// if the accumulated is greater than the payment, there should be a change
-		newOutput, err = NewTxOutput(acc-amount, from)
+	if acc > amount+fee {
+		// if the accumulated is greater than the payment plus the fee, there should be a change
+		newOutput, err = NewTxOutput(acc-amount-fee, from)
 		if err != nil {
 			return nil, err
 		}
 		outputs = append(outputs, *newOutput)
 	}
 
-	tx := &Transaction{nil, inputs, outputs}
-	err = tx.Sign(w.PrivateKey)
-	if err != nil {
-		return nil, err
-	}
-	hash, err := tx.Hash()
-	if err != nil {
-		return nil, err
-	}
+	return &Transaction{TxVersion, nil, inputs, outputs, 0}, nil
+}
 
-	tx.HashID = hash
-	return tx, nil
+// Size returns the size, in bytes, of the serialized transaction
+func (tx *Transaction) Size() (int, error) {
+	return len(tx.Serialize()), nil
 }
 
-// Hash generates and returns the hash of the transaction disregarding the value
-// setted for the hash field.
+// Hash generates and returns the hash of the serialized transaction, which doesn't
+// include the hash field. The legacy transactions keep the hash they were created with
 func (tx *Transaction) Hash() ([]byte, error) {
-	var hash [sha256.Size]byte
-
-	txCopy := *tx
-	txCopy.HashID = []byte{}
-
-	txSerialized, err := utils.Serialize(txCopy)
-	if err != nil {
-		return []byte{}, err
+	if tx.Version == LegacyTxVersion {
+		return append([]byte{}, tx.HashID...), nil
 	}
-	hash = sha256.Sum256(txSerialized)
 
+	hash := sha256.Sum256(tx.Serialize())
 	return hash[:], nil
 }
 
-// Sign signs the transaction
-func (tx *Transaction) Sign(privKey *ecdsa.PrivateKey) error {
+// Sign signs every input of the transaction with the private key, committing to the
+// parts of the transaction selected by hashType. prevOuts are the outputs spent by the
+// inputs, in the same order (see Blockchain.PrevOutputs)
+func (tx *Transaction) Sign(privKey *ecdsa.PrivateKey, prevOuts []TxOutput, hashType SigHashType) error {
 	if tx.IsCoinbase() {
 		return nil
 	}
-
-	txCopy, err := tx.TrimmedCopy()
-	if err != nil {
-		return err
+	if len(prevOuts) != len(tx.Inputs) {
+		return errors.New("transaction: there must be a previous output for each input")
 	}
 
-	for txinIdx := range txCopy.Inputs {
-		signature, err := ecdsa.SignASN1(rand.Reader, privKey, txCopy.HashID)
+	for txinIdx := range tx.Inputs {
+		err := tx.SignInput(txinIdx, privKey, prevOuts[txinIdx], hashType)
 		if err != nil {
 			return err
 		}
-		tx.Inputs[txinIdx].Signature = signature
 	}
+	return nil
+}
 
+// SignInput signs the input txinIdx, which spends prevOut, so each input of a transaction
+// can be signed by a different key. The hashType is appended to the signature
+func (tx *Transaction) SignInput(
+	txinIdx int, privKey *ecdsa.PrivateKey, prevOut TxOutput, hashType SigHashType,
+) error {
+	signature, err := tx.InputSignature(txinIdx, privKey, prevOut, hashType)
+	if err != nil {
+		return err
+	}
+	tx.Inputs[txinIdx].Signature = signature
 	return nil
 }
 
-// Verify verifies if the transaction is valid according to the signature and public key
-func (tx *Transaction) Verify() bool {
-	if tx.IsCoinbase() {
-		return true
+// InputSignature returns the signature of the input txinIdx, with the hashType appended,
+// without adding it to the input. It's used to build the unlocking scripts
+func (tx *Transaction) InputSignature(
+	txinIdx int, privKey *ecdsa.PrivateKey, prevOut TxOutput, hashType SigHashType,
+) ([]byte, error) {
+	if tx.Version == LegacyTxVersion {
+		return nil, errors.New("transaction: legacy transactions can't be signed")
 	}
 
-	curve := elliptic.P256()
-	txCopy, err := tx.TrimmedCopy()
+	sigHash, err := tx.SigHash(txinIdx, prevOut, hashType)
 	if err != nil {
-		return false
+		return nil, err
 	}
+	signature, err := ecdsa.SignASN1(rand.Reader, privKey, sigHash)
+	if err != nil {
+		return nil, err
+	}
+	return append(signature, byte(hashType)), nil
+}
 
-	for _, txin := range tx.Inputs {
-		var x, y big.Int
-		keyLen := len(txin.PubKey)
-		x.SetBytes(txin.PubKey[:(keyLen / 2)])
-		y.SetBytes(txin.PubKey[(keyLen / 2):])
-
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if !ecdsa.VerifyASN1(&rawPubKey, txCopy.HashID, txin.Signature) {
+// Verify checks that every input can spend the output it spends, running their scripts
+// (see VerifyInput). prevOuts are the outputs spent by the inputs, in the same order.
+// The signatures of the legacy transactions were made over their gob encoding, which
+// can't be reproduced, so they're trusted as they were verified before being migrated
+func (tx *Transaction) Verify(prevOuts []TxOutput) bool {
+	if tx.IsCoinbase() || tx.Version == LegacyTxVersion {
+		return true
+	}
+	if len(prevOuts) != len(tx.Inputs) {
+		return false
+	}
+	for txinIdx := range tx.Inputs {
+		if tx.VerifyInput(txinIdx, prevOuts[txinIdx]) != nil {
 			return false
 		}
 	}
-
 	return true
 }
 
-// TrimmedCopy returns a copy of the transaction but without the signature
-func (tx *Transaction) TrimmedCopy() (Transaction, error) {
-	var txInputs []TxInput
-	var txOutputs []TxOutput
-
-	for _, txin := range tx.Inputs {
-		txInputs = append(txInputs, TxInput{txin.PrevTxHash, txin.OutIdx, nil, txin.PubKey})
-	}
-	for _, txout := range tx.Outputs {
-		txOutputs = append(txOutputs, TxOutput{txout.Value, txout.PubKeyHash})
-	}
-
-	txCopy := Transaction{nil, txInputs, txOutputs}
-	hash, err := txCopy.Hash()
-	if err != nil {
-		return Transaction{}, err
+// VerifyInput checks that the input txinIdx can spend prevOut: its unlocking script and
+// then the locking script of the output must run without failing and leave true on the
+// stack. With the P2PKH template, the input public key must be the one that locks the
+// output and the signature must be made with it, over the parts of the transaction
+// selected by its sighash type
+func (tx *Transaction) VerifyInput(txinIdx int, prevOut TxOutput) error {
+	if tx.IsCoinbase() || tx.Version == LegacyTxVersion {
+		return nil
 	}
-	txCopy.HashID = hash
-	return txCopy, nil
+	return verifyInput(tx, txinIdx, prevOut)
 }
@@ -14,6 +14,7 @@ type TxInput struct {
 	OutIdx     int    // idx of output in the transaction struct
 	Signature  []byte
 	PubKey     []byte
+	Script     []byte // unlocking script, only if Signature and PubKey are empty
 }
 
 // TxOutput represents an output of a transaction. For more information:
@@ -21,6 +22,68 @@ type TxInput struct {
 type TxOutput struct {
 	Value      int
 	PubKeyHash []byte
+	Script     []byte // locking script, only if PubKeyHash is empty
+}
+
+// pubKeyHashSize is the size of the public key hashes (see wallet.PublicKeyHash)
+const pubKeyHashSize = 20
+
+// UnlockingScript returns the script of the input or, if it doesn't have one, the P2PKH
+// unlocking script made of its Signature and PubKey
+func (txin *TxInput) UnlockingScript() []byte {
+	if len(txin.Script) > 0 {
+		return txin.Script
+	}
+	return P2PKHUnlockingScript(txin.Signature, txin.PubKey)
+}
+
+// LockingScript returns the script of the output or, if it doesn't have one, the P2PKH
+// script that locks it to its PubKeyHash
+func (txout *TxOutput) LockingScript() []byte {
+	if len(txout.Script) > 0 {
+		return txout.Script
+	}
+	return P2PKHScript(txout.PubKeyHash)
+}
+
+// AddressHash returns the hash that identifies who can spend the output, which the UTXO
+// set and the address index use along with its type (see addressKey): the public key
+// hash of the P2PKH outputs, the script hash of the P2SH outputs and the HASH160 of the
+// locking script otherwise
+func (txout *TxOutput) AddressHash() []byte {
+	if len(txout.Script) == 0 {
+		return txout.PubKeyHash
+	}
+	if pubKeyHash := extractP2PKH(txout.Script); pubKeyHash != nil {
+		return pubKeyHash
+	}
+	if scriptHash := extractP2SH(txout.Script); scriptHash != nil {
+		return scriptHash
+	}
+	hash, _ := wallet.PublicKeyHash(txout.Script)
+	return hash
+}
+
+// IsP2PKH checks if the output is locked to a public key hash, with or without script
+func (txout *TxOutput) IsP2PKH() bool {
+	return len(txout.Script) == 0 || extractP2PKH(txout.Script) != nil
+}
+
+// IsP2SH checks if the output is locked to a script hash
+func (txout *TxOutput) IsP2SH() bool {
+	return extractP2SH(txout.Script) != nil
+}
+
+// Address returns the address the output is locked to, or an empty string if its
+// locking script isn't a P2PKH nor a P2SH script
+func (txout *TxOutput) Address() string {
+	switch {
+	case txout.IsP2PKH():
+		return wallet.PubKeyHashToAddress(txout.AddressHash())
+	case txout.IsP2SH():
+		return wallet.ScriptHashToAddress(txout.AddressHash())
+	}
+	return ""
 }
 
 // UsesKey checks if the hash of TxInput.PubKey is the same as the input
@@ -34,23 +97,31 @@ func (txin *TxInput) UsesKey(publicKeyHash []byte) bool {
 
 // NewTxOutput creates a new output
 func NewTxOutput(value int, address string) (*TxOutput, error) {
-	txout := &TxOutput{value, nil}
+	txout := &TxOutput{value, nil, nil}
 	err := txout.Lock(address)
 	return txout, err
 }
 
-// Lock locks the output according to the address
+// Lock locks the output according to the address: to its public key hash or, if it's a
+// script hash address, with a P2SH script
 func (txout *TxOutput) Lock(address string) error {
 	fullHash, err := base58.Decode(address)
 	if err != nil {
 		return err
 	}
 
-	txout.PubKeyHash = fullHash[1 : len(fullHash)-wallet.ChecksumLength]
+	hash := fullHash[1 : len(fullHash)-wallet.ChecksumLength]
+	if wallet.IsScriptHashAddress(address) {
+		txout.PubKeyHash = nil
+		txout.Script = P2SHScript(hash)
+		return nil
+	}
+	txout.PubKeyHash = hash
+	txout.Script = nil
 	return nil
 }
 
 // IsLockedWithKey checks if the output is locked with the key passed in the args
 func (txout *TxOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Compare(txout.PubKeyHash, pubKeyHash) == 0
+	return txout.IsP2PKH() && bytes.Compare(txout.AddressHash(), pubKeyHash) == 0
 }
@@ -0,0 +1,150 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"jotacoin/pkg/database"
+	"jotacoin/pkg/utils"
+)
+
+const (
+	// txIndexPrefix is the prefix of the keys that map a transaction hash to its location
+	// in the main chain
+	txIndexPrefix = "txindex-"
+	// txIndexFlag is the key that marks that the transaction index is enabled
+	txIndexFlag = "txindexEnabled"
+)
+
+// TxLocation is the location of a transaction in the main chain: the block that contains
+// it and its position in the block
+type TxLocation struct {
+	BlockHash []byte
+	Position  int
+}
+
+func txIndexKey(txHash []byte) []byte {
+	return append([]byte(txIndexPrefix), txHash...)
+}
+
+func txIndexEnabled(txn database.Txn) (bool, error) {
+	_, err := txn.Get([]byte(txIndexFlag))
+	if err == database.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func getTxLocation(txn database.Txn, txHash []byte) (*TxLocation, error) {
+	val, err := txn.Get(txIndexKey(txHash))
+	if err == database.ErrKeyNotFound {
+		return nil, ErrTxNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var location TxLocation
+	decoder := gob.NewDecoder(bytes.NewReader(val))
+	err = decoder.Decode(&location)
+	return &location, err
+}
+
+// indexTransactions adds the transactions of a block connected to the main chain into
+// the transaction index, if it's enabled
+func indexTransactions(txn database.Txn, b *Block) error {
+	enabled, err := txIndexEnabled(txn)
+	if err != nil || !enabled {
+		return err
+	}
+
+	for i, tx := range b.Transactions {
+		serializedLocation, err := utils.Serialize(TxLocation{b.Hash, i})
+		if err != nil {
+			return err
+		}
+		err = txn.Set(txIndexKey(tx.HashID), serializedLocation)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unindexTransactions removes the transactions of a block disconnected from the main
+// chain from the transaction index, if it's enabled
+func unindexTransactions(txn database.Txn, b *Block) error {
+	enabled, err := txIndexEnabled(txn)
+	if err != nil || !enabled {
+		return err
+	}
+
+	for _, tx := range b.Transactions {
+		err = txn.Delete(txIndexKey(tx.HashID))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TxIndexEnabled checks if the transaction index is enabled
+func (chain *Blockchain) TxIndexEnabled() (bool, error) {
+	var enabled bool
+	err := chain.DB.View(func(txn database.Txn) error {
+		var err error
+		enabled, err = txIndexEnabled(txn)
+		return err
+	})
+	return enabled, err
+}
+
+// ReindexTransactions builds the transaction index going through all the blocks of the
+// main chain and enables it, so it's kept up to date as the blocks are connected and
+// disconnected
+func (chain *Blockchain) ReindexTransactions() error {
+	err := chain.DB.DropPrefix([]byte(txIndexPrefix))
+	if err != nil {
+		return err
+	}
+
+	batch := chain.DB.NewBatch()
+	err = chain.writeTxIndex(batch)
+	if err == nil {
+		err = batch.Set([]byte(txIndexFlag), []byte{})
+	}
+	if err != nil {
+		batch.Cancel()
+		return err
+	}
+
+	return batch.Flush()
+}
+
+func (chain *Blockchain) writeTxIndex(batch database.Batch) error {
+	if len(chain.LastHash) == 0 {
+		return nil
+	}
+
+	iter := chain.Iterator()
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return err
+		}
+
+		for i, tx := range block.Transactions {
+			serializedLocation, err := utils.Serialize(TxLocation{block.Hash, i})
+			if err != nil {
+				return err
+			}
+			err = batch.Set(txIndexKey(tx.HashID), serializedLocation)
+			if err != nil {
+				return err
+			}
+		}
+
+		if block.IsGenesis() {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"jotacoin/pkg/database"
+	"jotacoin/pkg/utils"
+	"jotacoin/pkg/wallet"
+)
+
+const (
+	// utxoPrefix is the prefix of the keys that map an outpoint (tx hash + output index)
+	// to the unspent output itself
+	utxoPrefix = "utxo-"
+	// utxoAddrPrefix is the prefix of the keys used to find the unspent outputs of an
+	// address (see addressKey) without going through the whole UTXO set
+	utxoAddrPrefix = "utxoaddr-"
+	outIdxLength   = 4
+)
+
+// Types of the address keys, which keep apart the outputs locked to a public key hash
+// and to a script with the same hash
+const (
+	pubKeyHashKey = 'p'
+	scriptHashKey = 's'
+	// bareScriptKey is the type of the outputs locked to other scripts, which don't
+	// have an address
+	bareScriptKey = 'b'
+)
+
+var errStopIteration = errors.New("utxo: stop iteration")
+
+func outpoint(txHash []byte, outIdx int) []byte {
+	idx := make([]byte, outIdxLength)
+	binary.BigEndian.PutUint32(idx, uint32(outIdx))
+	return append(append([]byte{}, txHash...), idx...)
+}
+
+func utxoKey(txHash []byte, outIdx int) []byte {
+	return append([]byte(utxoPrefix), outpoint(txHash, outIdx)...)
+}
+
+func utxoAddrKey(addrKey, txHash []byte, outIdx int) []byte {
+	key := append([]byte(utxoAddrPrefix), addrKey...)
+	return append(key, outpoint(txHash, outIdx)...)
+}
+
+// addressKey returns the key that the UTXO set and the address index use for the
+// address: the hash it's locked to preceded by its type
+func addressKey(address string) ([]byte, error) {
+	hash, err := wallet.AddressToPubKeyHash(address)
+	if err != nil {
+		return nil, err
+	}
+	if wallet.IsScriptHashAddress(address) {
+		return append([]byte{scriptHashKey}, hash...), nil
+	}
+	return append([]byte{pubKeyHashKey}, hash...), nil
+}
+
+// addressKey returns the key of the address the output is locked to, see addressKey
+func (txout *TxOutput) addressKey() []byte {
+	switch {
+	case txout.IsP2PKH():
+		return append([]byte{pubKeyHashKey}, txout.AddressHash()...)
+	case txout.IsP2SH():
+		return append([]byte{scriptHashKey}, txout.AddressHash()...)
+	default:
+		return append([]byte{bareScriptKey}, txout.AddressHash()...)
+	}
+}
+
+// parseUTXOAddrKey extracts the tx hash and the output index from a key generated
+// by utxoAddrKey
+func parseUTXOAddrKey(key []byte) ([]byte, int) {
+	idxStart := len(key) - outIdxLength
+	txHash := key[idxStart-sha256.Size : idxStart]
+	outIdx := int(binary.BigEndian.Uint32(key[idxStart:]))
+	return txHash, outIdx
+}
+
+// DeserializeTxOutput transforms a serialized output ([]byte) into a TxOutput
+func DeserializeTxOutput(data []byte) (TxOutput, error) {
+	var out TxOutput
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&out)
+	return out, err
+}
+
+func getUTXO(txn database.Txn, txHash []byte, outIdx int) (TxOutput, error) {
+	val, err := txn.Get(utxoKey(txHash, outIdx))
+	if err != nil {
+		return TxOutput{}, err
+	}
+	return DeserializeTxOutput(val)
+}
+
+func putUTXO(txn database.Txn, txHash []byte, outIdx int, out TxOutput) error {
+	serializedOut, err := utils.Serialize(out)
+	if err != nil {
+		return err
+	}
+	err = txn.Set(utxoKey(txHash, outIdx), serializedOut)
+	if err != nil {
+		return err
+	}
+	return txn.Set(utxoAddrKey(out.addressKey(), txHash, outIdx), []byte{})
+}
+
+// deleteUTXO removes the unspent output and returns it
+func deleteUTXO(txn database.Txn, txHash []byte, outIdx int) (TxOutput, error) {
+	out, err := getUTXO(txn, txHash, outIdx)
+	if err != nil {
+		return out, err
+	}
+	err = txn.Delete(utxoKey(txHash, outIdx))
+	if err != nil {
+		return out, err
+	}
+	return out, txn.Delete(utxoAddrKey(out.addressKey(), txHash, outIdx))
+}
+
+// updateUTXO applies the block to the UTXO set: the outputs spent by the block's inputs
+// are removed and the new outputs are added. The spent outputs are stored as the undo
+// data of the block
+func updateUTXO(txn database.Txn, b *Block) error {
+	var spent []TxOutput
+	for _, tx := range b.Transactions {
+		if !tx.IsCoinbase() {
+			for _, txin := range tx.Inputs {
+				out, err := deleteUTXO(txn, txin.PrevTxHash, txin.OutIdx)
+				if err != nil {
+					return err
+				}
+				spent = append(spent, out)
+			}
+		}
+
+		for outIdx, out := range tx.Outputs {
+			err := putUTXO(txn, tx.HashID, outIdx, out)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return putUndo(txn, b.Hash, spent)
+}
+
+// revertUTXO undoes updateUTXO: the outputs created by the block are removed and the
+// outputs spent by the block are restored. The transactions are reverted in reverse
+// order, so an output created and spent in the same block ends up removed
+func revertUTXO(txn database.Txn, b *Block) error {
+	spent, err := getUndo(txn, b.Hash)
+	if err != nil {
+		return err
+	}
+
+	for i := len(b.Transactions) - 1; i >= 0; i-- {
+		tx := b.Transactions[i]
+		for outIdx := range tx.Outputs {
+			_, err := deleteUTXO(txn, tx.HashID, outIdx)
+			if err != nil {
+				return err
+			}
+		}
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for j := len(tx.Inputs) - 1; j >= 0; j-- {
+			txin := tx.Inputs[j]
+			if len(spent) == 0 {
+				return fmt.Errorf("utxo: undo data of block %x is incomplete", b.Hash)
+			}
+			err := putUTXO(txn, txin.PrevTxHash, txin.OutIdx, spent[len(spent)-1])
+			if err != nil {
+				return err
+			}
+			spent = spent[:len(spent)-1]
+		}
+	}
+
+	return nil
+}
+
+// forEachUTXO calls fn for every unspent output locked to the address. If fn returns
+// errStopIteration the iteration stops without error
+func forEachUTXO(
+	txn database.Txn, address string, fn func(txHash []byte, outIdx int, out TxOutput) error,
+) error {
+	addrKey, err := addressKey(address)
+	if err != nil {
+		return err
+	}
+	prefix := append([]byte(utxoAddrPrefix), addrKey...)
+	err = txn.Iterate(prefix, func(key, val []byte) error {
+		// the prefix may also match a longer hash
+		if len(key) != len(prefix)+sha256.Size+outIdxLength {
+			return nil
+		}
+
+		txHash, outIdx := parseUTXOAddrKey(key)
+		out, err := getUTXO(txn, txHash, outIdx)
+		if err != nil {
+			return err
+		}
+		return fn(txHash, outIdx, out)
+	})
+	if err == errStopIteration {
+		return nil
+	}
+	return err
+}
+
+// ReindexUTXO rebuilds the whole UTXO set going through all the blocks of the chain
+func (chain *Blockchain) ReindexUTXO() error {
+	err := chain.DB.DropPrefix([]byte(utxoPrefix), []byte(utxoAddrPrefix))
+	if err != nil {
+		return err
+	}
+
+	batch := chain.DB.NewBatch()
+	err = chain.writeAllUTXO(batch)
+	if err != nil {
+		batch.Cancel()
+		return err
+	}
+
+	return batch.Flush()
+}
+
+func (chain *Blockchain) writeAllUTXO(batch database.Batch) error {
+	// The chain is iterated from the last block to the genesis, and the transactions of
+	// each block from the last one, so the inputs that spend an output are always found
+	// before the output itself
+	spentTxOutputs := make(map[string]bool)
+	iter := chain.Iterator()
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return err
+		}
+
+		for txIdx := len(block.Transactions) - 1; txIdx >= 0; txIdx-- {
+			tx := block.Transactions[txIdx]
+			for outIdx, out := range tx.Outputs {
+				if spentTxOutputs[string(outpoint(tx.HashID, outIdx))] {
+					continue
+				}
+
+				serializedOut, err := utils.Serialize(out)
+				if err != nil {
+					return err
+				}
+				err = batch.Set(utxoKey(tx.HashID, outIdx), serializedOut)
+				if err != nil {
+					return err
+				}
+				err = batch.Set(utxoAddrKey(out.addressKey(), tx.HashID, outIdx), []byte{})
+				if err != nil {
+					return err
+				}
+			}
+
+			if tx.IsCoinbase() {
+				continue
+			}
+			for _, txin := range tx.Inputs {
+				spentTxOutputs[string(outpoint(txin.PrevTxHash, txin.OutIdx))] = true
+			}
+		}
+
+		// if it's the genesis block, break
+		if block.IsGenesis() {
+			return nil
+		}
+	}
+}
+
+// UnspentOutput is an unspent output and the outpoint that identifies it
+type UnspentOutput struct {
+	TxHash []byte
+	OutIdx int
+	Output TxOutput
+}
+
+// ListUTXO returns the unspent outputs locked to the address
+func (chain *Blockchain) ListUTXO(address string) ([]UnspentOutput, error) {
+	var utxos []UnspentOutput
+
+	err := chain.DB.View(func(txn database.Txn) error {
+		return forEachUTXO(txn, address, func(txHash []byte, outIdx int, out TxOutput) error {
+			utxos = append(utxos, UnspentOutput{txHash, outIdx, out})
+			return nil
+		})
+	})
+	return utxos, err
+}
+
+// PrevOutputs returns the outputs of the UTXO set spent by the inputs of the transaction,
+// in the same order, which are needed to sign and verify it
+func (chain *Blockchain) PrevOutputs(tx *Transaction) ([]TxOutput, error) {
+	var prevOuts []TxOutput
+
+	err := chain.DB.View(func(txn database.Txn) error {
+		for _, txin := range tx.Inputs {
+			out, err := getUTXO(txn, txin.PrevTxHash, txin.OutIdx)
+			if err == database.ErrKeyNotFound {
+				return newValidationError(
+					ErrMissingInput, tx, fmt.Sprintf("output %x:%d", txin.PrevTxHash, txin.OutIdx),
+				)
+			}
+			if err != nil {
+				return err
+			}
+			prevOuts = append(prevOuts, out)
+		}
+		return nil
+	})
+	return prevOuts, err
+}
@@ -0,0 +1,428 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"jotacoin/pkg/database"
+	"math"
+	"time"
+)
+
+const (
+	// medianTimeBlocks is the amount of blocks used to calculate the median time past
+	medianTimeBlocks = 11
+	// MaxFutureBlockTime is how far in the future the timestamp of a block can be
+	MaxFutureBlockTime = 2 * time.Hour
+)
+
+var (
+	// ErrBadHeader is returned when the block version or height are invalid
+	ErrBadHeader = errors.New("validation: invalid block header")
+	// ErrBadTimestamp is returned when the block timestamp isn't greater than the median
+	// time past or when it's too far in the future
+	ErrBadTimestamp = errors.New("validation: invalid block timestamp")
+	// ErrBadMerkleRoot is returned when the header merkle root doesn't match the transactions
+	ErrBadMerkleRoot = errors.New("validation: merkle root does not match the transactions")
+	// ErrBadDifficulty is returned when the block difficulty bits aren't the ones required
+	// by the chain at that height
+	ErrBadDifficulty = errors.New("validation: wrong difficulty")
+	// ErrBadPoW is returned when the block hash doesn't satisfy the proof of work
+	ErrBadPoW = errors.New("validation: invalid proof of work")
+	// ErrBadPrevHash is returned when the parent of the block is unknown or, if the block
+	// must extend the tip of the chain, when it doesn't
+	ErrBadPrevHash = errors.New("validation: block does not extend a known block")
+	// ErrInvalidChain is returned when the block, or one of its ancestors, already failed
+	// to connect
+	ErrInvalidChain = errors.New("validation: block extends an invalid block")
+	// ErrKnownBlock is returned when the block is already stored
+	ErrKnownBlock = errors.New("validation: block already stored")
+	// ErrBadTxVersion is returned when the transaction version isn't TxVersion
+	ErrBadTxVersion = errors.New("validation: unsupported transaction version")
+	// ErrBadTxHash is returned when a transaction HashID doesn't match its content
+	ErrBadTxHash = errors.New("validation: transaction hash does not match its content")
+	// ErrBadTxScript is returned when an input or output script is malformed or when an
+	// input has both a script and a signature or public key
+	ErrBadTxScript = errors.New("validation: invalid transaction script")
+	// ErrBadSignature is returned when an input can't unlock the referenced output, either
+	// because of an invalid signature or because the scripts fail
+	ErrBadSignature = errors.New("validation: invalid signature")
+	// ErrNonFinalTx is returned when the transaction lock time is greater than the height
+	// of the block that would include it
+	ErrNonFinalTx = errors.New("validation: transaction is locked until a later height")
+	// ErrDoubleSpend is returned when the same output is spent more than once in a block
+	ErrDoubleSpend = errors.New("validation: output spent more than once")
+	// ErrMissingInput is returned when an input references an output that doesn't exist
+	// or that was already spent
+	ErrMissingInput = errors.New("validation: input references a missing or spent output")
+	// ErrValueOverflow is returned when the outputs are negative, overflow or exceed the inputs
+	ErrValueOverflow = errors.New("validation: invalid output values")
+	// ErrBadCoinbase is returned when the block doesn't have exactly one coinbase in the
+	// first position or when the coinbase pays more than allowed
+	ErrBadCoinbase = errors.New("validation: invalid coinbase")
+)
+
+// ValidationError is the error returned by the block validation. Err is always one of
+// the Err* values of this package, so it can be checked using errors.Is
+type ValidationError struct {
+	Err    error
+	TxHash []byte
+	Detail string
+}
+
+func (e *ValidationError) Error() string {
+	msg := e.Err.Error()
+	if e.Detail != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Detail)
+	}
+	if len(e.TxHash) > 0 {
+		msg = fmt.Sprintf("%s (tx %x)", msg, e.TxHash)
+	}
+	return msg
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+func newValidationError(err error, tx *Transaction, detail string) *ValidationError {
+	var txHash []byte
+	if tx != nil {
+		txHash = tx.HashID
+	}
+	return &ValidationError{err, txHash, detail}
+}
+
+// ValidateBlock checks if the block can be added on top of the current tip of the chain
+func (chain *Blockchain) ValidateBlock(b *Block) error {
+	return chain.DB.View(func(txn database.Txn) error {
+		lastHash, err := getLastHashTxn(txn)
+		if err == database.ErrKeyNotFound {
+			lastHash = []byte{}
+		} else if err != nil {
+			return err
+		}
+		if !bytes.Equal(b.Header.PrevHash, lastHash) {
+			return newValidationError(ErrBadPrevHash, nil, fmt.Sprintf("prev hash %x", b.Header.PrevHash))
+		}
+
+		return validateBlock(txn, b)
+	})
+}
+
+// ValidateTransaction checks if the transaction (which can't be a coinbase) is valid
+// against the current UTXO set and returns its fee
+func (chain *Blockchain) ValidateTransaction(tx *Transaction) (int, error) {
+	var fee int
+
+	err := chain.DB.View(func(txn database.Txn) error {
+		err := validateTxHash(tx)
+		if err != nil {
+			return err
+		}
+		err = validateScripts(tx)
+		if err != nil {
+			return err
+		}
+
+		// the transaction would be included in the next block
+		height := 0
+		lastHash, err := getLastHashTxn(txn)
+		if err == nil {
+			lastBlock, err := getBlockTxn(txn, lastHash)
+			if err != nil {
+				return err
+			}
+			height = lastBlock.Header.Height + 1
+		} else if err != database.ErrKeyNotFound {
+			return err
+		}
+
+		fee, err = validateTransaction(txn, tx, height, map[string]TxOutput{}, map[string]bool{})
+		return err
+	})
+
+	return fee, err
+}
+
+// validateHeader checks the header of the block against the previous block, which must
+// be stored (but doesn't need to be the tip)
+func validateHeader(txn database.Txn, b *Block) error {
+	err := checkHeader(txn, &b.Header, b.Hash)
+	if err != nil || b.IsGenesis() {
+		return err
+	}
+
+	_, err = getBlockTxn(txn, b.Header.PrevHash)
+	if err == database.ErrKeyNotFound {
+		return newValidationError(ErrBadPrevHash, nil, fmt.Sprintf("unknown parent %x", b.Header.PrevHash))
+	}
+	return err
+}
+
+// checkHeader checks the header against the header of the previous block, which must be
+// stored as a block or as a header, and checks the proof of work
+func checkHeader(txn database.Txn, header *BlockHeader, hash []byte) error {
+	if header.Version < 1 || header.Version > BlockVersion {
+		return newValidationError(ErrBadHeader, nil, fmt.Sprintf("unknown version %d", header.Version))
+	}
+
+	for _, h := range [][]byte{hash, header.PrevHash} {
+		invalid, err := isInvalid(txn, h)
+		if err != nil {
+			return err
+		}
+		if invalid {
+			return newValidationError(ErrInvalidChain, nil, fmt.Sprintf("block %x", h))
+		}
+	}
+
+	maxTimestamp := time.Now().Add(MaxFutureBlockTime).Unix()
+	if header.Timestamp > maxTimestamp {
+		return newValidationError(ErrBadTimestamp, nil, "timestamp too far in the future")
+	}
+
+	requiredBits := InitialDifficulty
+	if len(header.PrevHash) == 0 {
+		if header.Height != 0 {
+			return newValidationError(ErrBadHeader, nil, fmt.Sprintf("genesis height %d", header.Height))
+		}
+	} else {
+		prev, err := getHeaderTxn(txn, header.PrevHash)
+		if err == database.ErrKeyNotFound {
+			return newValidationError(ErrBadPrevHash, nil, fmt.Sprintf("unknown parent %x", header.PrevHash))
+		}
+		if err != nil {
+			return err
+		}
+		if header.Height != prev.Height+1 {
+			return newValidationError(ErrBadHeader, nil, fmt.Sprintf("height %d", header.Height))
+		}
+
+		mtp, err := medianTimePast(txn, header.PrevHash)
+		if err != nil {
+			return err
+		}
+		if header.Timestamp <= mtp {
+			return newValidationError(ErrBadTimestamp, nil, "timestamp not after the median time past")
+		}
+
+		requiredBits, err = nextDifficulty(txn, prev)
+		if err != nil {
+			return err
+		}
+	}
+
+	if header.Bits != requiredBits {
+		return newValidationError(
+			ErrBadDifficulty, nil, fmt.Sprintf("bits %d, required %d", header.Bits, requiredBits),
+		)
+	}
+	if !NewProof(&Block{Header: *header}).IsValid() || !bytes.Equal(header.Hash(), hash) {
+		return newValidationError(ErrBadPoW, nil, fmt.Sprintf("block %x", hash))
+	}
+
+	return nil
+}
+
+// validateBlock runs the whole validation pipeline of a block, whose parent must be the
+// tip, against the UTXO set stored in the database
+func validateBlock(txn database.Txn, b *Block) error {
+	err := validateHeader(txn, b)
+	if err != nil {
+		return err
+	}
+	err = validateStructure(b)
+	if err != nil {
+		return err
+	}
+	return validateTransactions(txn, b)
+}
+
+// validateStructure runs the checks that don't depend on the UTXO set, so they can be
+// done on the blocks of the side branches
+func validateStructure(b *Block) error {
+	if !bytes.Equal(b.Header.MerkleRoot, b.HashTransactions()) {
+		return newValidationError(ErrBadMerkleRoot, nil, fmt.Sprintf("block %x", b.Hash))
+	}
+
+	if len(b.Transactions) == 0 || !b.Transactions[0].IsCoinbase() {
+		return newValidationError(ErrBadCoinbase, nil, "first transaction is not a coinbase")
+	}
+	for _, tx := range b.Transactions {
+		err := validateTxHash(tx)
+		if err != nil {
+			return err
+		}
+		err = validateScripts(tx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTransactions validates the transactions of the block against the UTXO set,
+// which must be the one of the parent of the block
+func validateTransactions(txn database.Txn, b *Block) error {
+	// outputs created and spent by the previous transactions of this same block. The
+	// coinbase outputs can't be spent in the same block
+	created := make(map[string]TxOutput)
+	spent := make(map[string]bool)
+
+	fees := 0
+	for _, tx := range b.Transactions[1:] {
+		fee, err := validateTransaction(txn, tx, b.Header.Height, created, spent)
+		if err != nil {
+			return err
+		}
+		fees += fee
+
+		for outIdx, out := range tx.Outputs {
+			created[string(outpoint(tx.HashID, outIdx))] = out
+		}
+	}
+
+	// the coinbase is validated after the other transactions, because it can claim their fees
+	return validateCoinbase(b.Transactions[0], fees, b.Header.Height)
+}
+
+func validateTxHash(tx *Transaction) error {
+	// the legacy transactions are only in the migrated blocks, which aren't validated again
+	if tx.Version != TxVersion {
+		return newValidationError(ErrBadTxVersion, tx, fmt.Sprintf("version %d", tx.Version))
+	}
+	hash, err := tx.Hash()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash, tx.HashID) {
+		return newValidationError(ErrBadTxHash, tx, "")
+	}
+	return nil
+}
+
+// validateScripts checks that the scripts of the transaction can be parsed, that the
+// unlocking scripts only push values and that the inputs and outputs with a script
+// don't have the fields of the P2PKH template
+func validateScripts(tx *Transaction) error {
+	for _, txin := range tx.Inputs {
+		if txin.Script == nil {
+			continue
+		}
+		if len(txin.Signature) > 0 || len(txin.PubKey) > 0 {
+			return newValidationError(ErrBadTxScript, tx, "input with a script and a signature")
+		}
+		if !IsPushOnly(txin.Script) {
+			return newValidationError(ErrBadTxScript, tx, "unlocking script doesn't only push values")
+		}
+	}
+	for _, out := range tx.Outputs {
+		if out.Script == nil {
+			continue
+		}
+		if len(out.PubKeyHash) > 0 {
+			return newValidationError(ErrBadTxScript, tx, "output with a script and a public key hash")
+		}
+		if _, err := ParseScript(out.Script); err != nil {
+			return newValidationError(ErrBadTxScript, tx, err.Error())
+		}
+	}
+	return nil
+}
+
+// validateCoinbase checks that the coinbase doesn't pay more than the block subsidy plus
+// the fees of the block transactions
+func validateCoinbase(tx *Transaction, fees, height int) error {
+	total, err := sumOutputs(tx)
+	if err != nil {
+		return err
+	}
+
+	maxValue := BlockSubsidy(height) + fees
+	if total > maxValue {
+		return newValidationError(
+			ErrBadCoinbase, tx, fmt.Sprintf("pays %d, max is %d", total, maxValue),
+		)
+	}
+	return nil
+}
+
+// validateTransaction validates a transaction that isn't a coinbase, to be included in
+// a block at height, and returns its fee. created and spent are the outputs created and
+// spent by the previous transactions of the block, the spent outputs of this transaction
+// are added to spent
+func validateTransaction(
+	txn database.Txn, tx *Transaction, height int, created map[string]TxOutput, spent map[string]bool,
+) (int, error) {
+	if tx.IsCoinbase() {
+		return 0, newValidationError(ErrBadCoinbase, tx, "coinbase is not the first transaction")
+	}
+	if len(tx.Inputs) == 0 {
+		return 0, newValidationError(ErrMissingInput, tx, "transaction without inputs")
+	}
+	if tx.LockTime > height {
+		return 0, newValidationError(
+			ErrNonFinalTx, tx, fmt.Sprintf("lock time %d, height %d", tx.LockTime, height),
+		)
+	}
+
+	inputsTotal := 0
+	for txinIdx, txin := range tx.Inputs {
+		key := string(outpoint(txin.PrevTxHash, txin.OutIdx))
+		if spent[key] {
+			return 0, newValidationError(
+				ErrDoubleSpend, tx, fmt.Sprintf("output %x:%d", txin.PrevTxHash, txin.OutIdx),
+			)
+		}
+		spent[key] = true
+
+		prevOut, ok := created[key]
+		if !ok {
+			var err error
+			prevOut, err = getUTXO(txn, txin.PrevTxHash, txin.OutIdx)
+			if err == database.ErrKeyNotFound {
+				return 0, newValidationError(
+					ErrMissingInput, tx, fmt.Sprintf("output %x:%d", txin.PrevTxHash, txin.OutIdx),
+				)
+			}
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		err := tx.VerifyInput(txinIdx, prevOut)
+		if err != nil {
+			return 0, newValidationError(
+				ErrBadSignature, tx, fmt.Sprintf("input can't unlock %x:%d: %s", txin.PrevTxHash, txin.OutIdx, err),
+			)
+		}
+		inputsTotal += prevOut.Value
+	}
+
+	outputsTotal, err := sumOutputs(tx)
+	if err != nil {
+		return 0, err
+	}
+	if outputsTotal > inputsTotal {
+		return 0, newValidationError(
+			ErrValueOverflow, tx, fmt.Sprintf("outputs %d exceed inputs %d", outputsTotal, inputsTotal),
+		)
+	}
+
+	return inputsTotal - outputsTotal, nil
+}
+
+// sumOutputs returns the sum of the outputs values checking that none of them is negative
+// and that the sum doesn't overflow nor exceed the maximum supply
+func sumOutputs(tx *Transaction) (int, error) {
+	total := 0
+	maxSupply := MaxSupply()
+	for _, out := range tx.Outputs {
+		if out.Value < 0 || total > math.MaxInt-out.Value || total+out.Value > maxSupply {
+			return 0, newValidationError(ErrValueOverflow, tx, fmt.Sprintf("output value %d", out.Value))
+		}
+		total += out.Value
+	}
+	return total, nil
+}
@@ -1,12 +1,24 @@
 package cli
 
 import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"jotacoin/pkg/api"
 	"jotacoin/pkg/blockchain"
+	"jotacoin/pkg/database"
+	"jotacoin/pkg/network"
 	"jotacoin/pkg/wallet"
+	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func handleError(err error) {
@@ -54,26 +66,270 @@ func (cli *CommandLine) getBalance(address string) {
 	ws, err := wallet.LoadFile()
 	handleError(err)
 	w := ws.GetWallet(address)
-	if w == nil {
+	if w == nil && !wallet.IsScriptHashAddress(address) {
 		panic(errors.New("wallet does not exists"))
 	}
-	pubHash, err := wallet.PublicKeyHash(w.PublicKey)
+	// the multisig addresses don't have a wallet
+	_, err = wallet.AddressToPubKeyHash(address)
 	handleError(err)
-	balance := chain.GetBalance(pubHash)
+	balance := chain.GetBalance(address)
 	fmt.Printf("Balance: %d\n", balance)
 }
 
-func (cli *CommandLine) newTransaction(from, to string, amount int) {
+// history prints count entries of the history of an address, skipping the first skip
+// entries
+func (cli *CommandLine) history(address string, skip, count int) {
+	chain, err := blockchain.ContinueBlockchain()
+	handleError(err)
+
+	history, total, err := chain.AddressHistory(address, skip, count)
+	handleError(err)
+	fmt.Printf("Entries %d-%d of %d\n\n", skip+1, skip+len(history), total)
+	for _, entry := range history {
+		fmt.Printf("Tx Hash: %x\nHeight: %d\nReceived: %d\nSent: %d\nBalance: %d\n\n",
+			entry.TxHash, entry.Height, entry.Received, entry.Sent, entry.Balance)
+	}
+}
+
+func (cli *CommandLine) newTransaction(from, to string, amount int, opts blockchain.TxOptions) {
+	chain, err := blockchain.ContinueBlockchain()
+	handleError(err)
+	mempool, err := blockchain.NewMempool(chain)
+	handleError(err)
+
+	tx, err := blockchain.NewTransaction(from, to, amount, opts, chain)
+	handleError(err)
+	fee, err := mempool.Add(tx)
+	handleError(err)
+
+	fmt.Printf("Transaction added to the mempool!\nTx Hash: %x\nFee: %d\nInputs: %v\nOutputs: %v\n\n",
+		tx.HashID, fee, tx.Inputs, tx.Outputs)
+}
+
+// createMultisig prints the address and the redeem script of a multisig that requires
+// m signatures of the keys, which are addresses of the wallets or public keys in hex
+func (cli *CommandLine) createMultisig(m int, keys []string) {
+	ws, err := wallet.LoadFile()
+	if err != nil {
+		ws = wallet.Wallets{}
+	}
+
+	var pubKeys [][]byte
+	for _, key := range keys {
+		if w := ws.GetWallet(key); w != nil {
+			pubKeys = append(pubKeys, w.PublicKey)
+			continue
+		}
+		pubKey, err := hex.DecodeString(key)
+		handleError(err)
+		pubKeys = append(pubKeys, pubKey)
+	}
+
+	redeemScript, err := blockchain.MultisigScript(m, pubKeys)
+	handleError(err)
+	address, err := blockchain.MultisigAddress(redeemScript)
+	handleError(err)
+	fmt.Printf("Address: %s\nRedeem script: %x\n", address, redeemScript)
+}
+
+// newMultisigTransaction prints a transaction, without signatures, that pays amount from
+// the multisig address of the redeem script
+func (cli *CommandLine) newMultisigTransaction(redeemScriptHex, to string, amount int, opts blockchain.TxOptions) {
+	redeemScript, err := hex.DecodeString(redeemScriptHex)
+	handleError(err)
+	chain, err := blockchain.ContinueBlockchain()
+	handleError(err)
+
+	tx, err := blockchain.NewMultisigTransaction(redeemScript, to, amount, opts, chain)
+	handleError(err)
+	_, required, err := tx.Inputs[0].MultisigStatus()
+	handleError(err)
+	fmt.Printf("Transaction created, it needs %d signatures on each input\nTransaction: %x\n",
+		required, tx.Serialize())
+}
+
+// signMultisigTransaction adds the signature of the wallet of address to a multisig
+// transaction and, if it has all the required signatures, adds it to the mempool
+func (cli *CommandLine) signMultisigTransaction(txHex, address string, hashType blockchain.SigHashType) {
+	serializedTx, err := hex.DecodeString(txHex)
+	handleError(err)
+	tx, err := blockchain.DeserializeTransaction(serializedTx)
+	handleError(err)
+	ws, err := wallet.LoadFile()
+	handleError(err)
+	w := ws.GetWallet(address)
+	if w == nil {
+		panic(errors.New("wallet does not exists"))
+	}
+	chain, err := blockchain.ContinueBlockchain()
+	handleError(err)
+
+	prevOuts, err := chain.PrevOutputs(tx)
+	handleError(err)
+	signed, err := tx.SignMultisig(w, prevOuts, hashType)
+	handleError(err)
+	fmt.Printf("Signed inputs: %d\n", signed)
+	for txinIdx, in := range tx.Inputs {
+		if signatures, required, err := in.MultisigStatus(); err == nil {
+			fmt.Printf("Input %d: %d of %d signatures\n", txinIdx, signatures, required)
+		}
+	}
+
+	if !tx.Verify(prevOuts) {
+		fmt.Printf("Transaction: %x\n", tx.Serialize())
+		return
+	}
+	mempool, err := blockchain.NewMempool(chain)
+	handleError(err)
+	_, err = mempool.Add(tx)
+	handleError(err)
+	fmt.Printf("Transaction added to the mempool!\nTx Hash: %x\n", tx.HashID)
+}
+
+// createPSBT prints a PSBT that pays amount from the address from, which must have the
+// redeem script (in hex) if it's a multisig address
+func (cli *CommandLine) createPSBT(from, to string, amount int, redeemScriptHex string, opts blockchain.TxOptions) {
+	redeemScript, err := hex.DecodeString(redeemScriptHex)
+	handleError(err)
 	chain, err := blockchain.ContinueBlockchain()
 	handleError(err)
 
-	tx, err := blockchain.NewTransaction(from, to, amount, chain)
+	psbt, err := blockchain.CreatePSBT(from, to, amount, redeemScript, opts, chain)
 	handleError(err)
-	err = chain.AddBlock([]*blockchain.Transaction{tx})
+	printPSBT(psbt)
+}
+
+// signPSBT adds the signatures of the wallet of address to a PSBT. It doesn't use the
+// chain, so it can run on an offline machine
+func (cli *CommandLine) signPSBT(psbtHex, address string, hashType blockchain.SigHashType) {
+	psbt := decodePSBT(psbtHex)
+	ws, err := wallet.LoadFile()
 	handleError(err)
+	w := ws.GetWallet(address)
+	if w == nil {
+		panic(errors.New("wallet does not exists"))
+	}
 
-	fmt.Printf("Transaction done!\nTx Hash: %x\nInputs: %v\nOutputs: %v\n\n",
-		tx.HashID, tx.Inputs, tx.Outputs)
+	signed, err := psbt.Sign(w, hashType)
+	handleError(err)
+	fmt.Printf("Signed inputs: %d\n", signed)
+	printPSBT(psbt)
+}
+
+// combinePSBT prints a PSBT with the signatures of all the PSBTs, which must be copies
+// of the same PSBT
+func (cli *CommandLine) combinePSBT(psbtsHex []string) {
+	psbt := decodePSBT(psbtsHex[0])
+	for _, psbtHex := range psbtsHex[1:] {
+		err := psbt.Combine(decodePSBT(psbtHex))
+		handleError(err)
+	}
+	printPSBT(psbt)
+}
+
+// finalizePSBT prints the signed transaction of a PSBT with all its signatures
+func (cli *CommandLine) finalizePSBT(psbtHex string) {
+	tx, err := decodePSBT(psbtHex).Finalize()
+	handleError(err)
+	fmt.Printf("Tx Hash: %x\nTransaction: %x\n", tx.HashID, tx.Serialize())
+}
+
+// broadcast sends a signed transaction to the node whose JSON-RPC API is at rpcURL or,
+// if it's empty, adds it to the mempool
+func (cli *CommandLine) broadcast(txHex, rpcURL string) {
+	if rpcURL != "" {
+		var result api.SendResult
+		handleError(rpcCall(rpcURL, "sendrawtransaction", &result, txHex))
+		fmt.Printf("Transaction sent!\nTx Hash: %s\nFee: %d\n", result.Hash, result.Fee)
+		return
+	}
+
+	serializedTx, err := hex.DecodeString(txHex)
+	handleError(err)
+	tx, err := blockchain.DeserializeTransaction(serializedTx)
+	handleError(err)
+	chain, err := blockchain.ContinueBlockchain()
+	handleError(err)
+	mempool, err := blockchain.NewMempool(chain)
+	handleError(err)
+
+	fee, err := mempool.Add(tx)
+	handleError(err)
+	fmt.Printf("Transaction added to the mempool!\nTx Hash: %x\nFee: %d\n", tx.HashID, fee)
+}
+
+func decodePSBT(psbtHex string) *blockchain.PSBT {
+	serializedPSBT, err := hex.DecodeString(psbtHex)
+	handleError(err)
+	psbt, err := blockchain.DeserializePSBT(serializedPSBT)
+	handleError(err)
+	return psbt
+}
+
+func printPSBT(psbt *blockchain.PSBT) {
+	for txinIdx := range psbt.Inputs {
+		signatures, required := psbt.InputStatus(txinIdx)
+		fmt.Printf("Input %d: %d of %d signatures\n", txinIdx, signatures, required)
+	}
+	fmt.Printf("PSBT: %x\n", psbt.Serialize())
+}
+
+// rpcCall calls a method of the JSON-RPC API at url and decodes its result into result
+func rpcCall(url, method string, result any, params ...any) error {
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := json.Marshal(api.Request{JSONRPC: "2.0", Method: method, Params: rawParams, ID: json.RawMessage("1")})
+	if err != nil {
+		return err
+	}
+	httpResp, err := http.Post(url, "application/json", bytes.NewReader(req))
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	var resp api.Response
+	err = json.NewDecoder(httpResp.Body).Decode(&resp)
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	return json.Unmarshal(resp.Result, result)
+}
+
+func (cli *CommandLine) mine(address string) {
+	chain, err := blockchain.ContinueBlockchain()
+	handleError(err)
+	mempool, err := blockchain.NewMempool(chain)
+	handleError(err)
+
+	block, err := mempool.BlockTemplate(address)
+	handleError(err)
+	miner := blockchain.NewMiner(0)
+	err = miner.Mine(context.Background(), block)
+	handleError(err)
+	err = chain.AcceptBlock(block)
+	handleError(err)
+
+	fmt.Printf("Block mined!\nBlock Hash: %x\nTransactions: %d\nHash rate: %.0f H/s\n",
+		block.Hash, len(block.Transactions), miner.HashRate())
+}
+
+func (cli *CommandLine) showMempool() {
+	chain, err := blockchain.ContinueBlockchain()
+	handleError(err)
+	mempool, err := blockchain.NewMempool(chain)
+	handleError(err)
+
+	fmt.Printf("Transactions: %d\nSize: %d bytes\n\n", mempool.Count(), mempool.Size())
+	for _, entry := range mempool.Entries() {
+		fmt.Printf("Tx Hash: %x\nFee: %d\nSize: %d\nAdded at: %s\n\n",
+			entry.Tx.HashID, entry.Fee, entry.Size, time.Unix(entry.AddedAt, 0))
+	}
 }
 
 func (cli *CommandLine) newBlockchain(address string) {
@@ -83,6 +339,216 @@ func (cli *CommandLine) newBlockchain(address string) {
 	fmt.Println("New BlockChain created")
 }
 
+func (cli *CommandLine) supply(height int) {
+	if height < 0 {
+		chain, err := blockchain.ContinueBlockchain()
+		handleError(err)
+		height, err = chain.Height()
+		handleError(err)
+	}
+
+	fmt.Printf("Height: %d\nBlock subsidy: %d\nIssued supply: %d\nMax supply: %d\n",
+		height, blockchain.BlockSubsidy(height), blockchain.Supply(height), blockchain.MaxSupply())
+}
+
+func (cli *CommandLine) reindexUTXO() {
+	chain, err := blockchain.ContinueBlockchain()
+	handleError(err)
+
+	err = chain.ReindexUTXO()
+	handleError(err)
+
+	fmt.Println("UTXO set reindexed")
+}
+
+func (cli *CommandLine) getBlockHash(height int) {
+	chain, err := blockchain.ContinueBlockchain()
+	handleError(err)
+
+	hash, err := chain.BlockHashAt(height)
+	handleError(err)
+	fmt.Printf("%x\n", hash)
+}
+
+func (cli *CommandLine) getBlockCount() {
+	chain, err := blockchain.ContinueBlockchain()
+	handleError(err)
+
+	height, err := chain.Height()
+	handleError(err)
+	fmt.Println(height)
+}
+
+func (cli *CommandLine) reindexAddresses() {
+	chain, err := blockchain.ContinueBlockchain()
+	handleError(err)
+
+	err = chain.ReindexAddresses()
+	handleError(err)
+
+	fmt.Println("Address index reindexed")
+}
+
+func (cli *CommandLine) reindexTransactions() {
+	chain, err := blockchain.ContinueBlockchain()
+	handleError(err)
+
+	err = chain.ReindexTransactions()
+	handleError(err)
+
+	fmt.Println("Transaction index built")
+}
+
+// getTransaction prints a transaction of the main chain and its confirmations
+func (cli *CommandLine) getTransaction(hashParam string) {
+	txHash, err := hex.DecodeString(hashParam)
+	handleError(err)
+	chain, err := blockchain.ContinueBlockchain()
+	handleError(err)
+
+	tx, block, err := chain.FindTransaction(txHash)
+	handleError(err)
+	height, err := chain.Height()
+	handleError(err)
+
+	fmt.Printf("Transaction Hash: %x\nBlock: %x\nHeight: %d\nConfirmations: %d\nCoinbase: %t\n",
+		tx.HashID, block.Hash, block.Header.Height, height-block.Header.Height+1, tx.IsCoinbase())
+	fmt.Println("\nINPUTS:")
+	if !tx.IsCoinbase() {
+		for _, in := range tx.Inputs {
+			fmt.Printf("PrevTxHash: %x\nOutIdx: %d\n", in.PrevTxHash, in.OutIdx)
+			if in.Script != nil {
+				script, err := blockchain.DisasmScript(in.Script)
+				handleError(err)
+				fmt.Printf("Script: %s\n", script)
+				continue
+			}
+			pubKeyHash, err := wallet.PublicKeyHash(in.PubKey)
+			handleError(err)
+			fmt.Printf("Address: %s\n", wallet.PubKeyHashToAddress(pubKeyHash))
+		}
+	}
+	fmt.Println("\nOUTPUTS:")
+	for _, out := range tx.Outputs {
+		fmt.Printf("Amount: %d\n", out.Value)
+		if address := out.Address(); address != "" {
+			fmt.Printf("Address: %s\n", address)
+			continue
+		}
+		script, err := blockchain.DisasmScript(out.Script)
+		handleError(err)
+		fmt.Printf("Script: %s\n", script)
+	}
+}
+
+// startNode runs a node listening at localhost:port until it's interrupted. The node
+// connects to the peers passed as argument and, if minerAddress isn't empty, it mines the
+// transactions of the mempool. If there is no chain in dataDir, it's downloaded from the
+// peers. The JSON-RPC API, the REST API and the explorer are served at localhost at
+// their ports, unless they are 0. If txIndex is true, the transaction index is enabled
+func (cli *CommandLine) startNode(
+	port int, peers []string, minerAddress, dataDir string, rpcPort, restPort, explorerPort int, txIndex bool,
+) {
+	chain, err := blockchain.OpenBlockchainAt(dataDir)
+	handleError(err)
+	defer chain.DB.Close()
+	if txIndex {
+		enableTxIndex(chain)
+	}
+	mempool, err := blockchain.NewMempool(chain)
+	handleError(err)
+
+	server := network.NewServer(fmt.Sprintf("localhost:%d", port), chain, mempool)
+	err = server.Start()
+	handleError(err)
+	fmt.Printf("Node listening at %s\n", server.Addr)
+
+	for _, addr := range peers {
+		err = server.Connect(addr)
+		if err != nil {
+			fmt.Printf("Couldn't connect to %s: %v\n", addr, err)
+		}
+	}
+	if minerAddress != "" {
+		server.StartMining(minerAddress, 0)
+		fmt.Printf("Mining to %s\n", minerAddress)
+	}
+	rpcServer := api.NewServer(chain, mempool, server)
+	serveInBackground("JSON-RPC API", rpcPort, rpcServer)
+	serveInBackground("REST API", restPort, rpcServer.REST())
+	serveInBackground("Explorer", explorerPort, rpcServer.Explorer())
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case <-interrupt:
+			running = false
+		case <-ticker.C:
+			progress, err := server.SyncProgress()
+			handleError(err)
+			if !progress.Synced() {
+				fmt.Printf("Syncing: %s\n", progress)
+			}
+		}
+	}
+
+	err = server.Close()
+	handleError(err)
+	fmt.Println("Node stopped")
+}
+
+// startRPC serves the JSON-RPC API at localhost:port, without connecting to other nodes.
+// The REST API and the explorer are served at localhost at their ports, unless they are
+// 0. If txIndex is true, the transaction index is enabled
+func (cli *CommandLine) startRPC(port, restPort, explorerPort int, dataDir string, txIndex bool) {
+	chain, err := blockchain.ContinueBlockchainAt(dataDir)
+	handleError(err)
+	defer chain.DB.Close()
+	if txIndex {
+		enableTxIndex(chain)
+	}
+	mempool, err := blockchain.NewMempool(chain)
+	handleError(err)
+
+	rpcServer := api.NewServer(chain, mempool, nil)
+	serveInBackground("REST API", restPort, rpcServer.REST())
+	serveInBackground("Explorer", explorerPort, rpcServer.Explorer())
+
+	addr := fmt.Sprintf("localhost:%d", port)
+	fmt.Printf("JSON-RPC API at http://%s\n", addr)
+	err = rpcServer.ListenAndServe(addr)
+	handleError(err)
+}
+
+// enableTxIndex builds the transaction index of the chain, unless it's already enabled
+func enableTxIndex(chain *blockchain.Blockchain) {
+	enabled, err := chain.TxIndexEnabled()
+	handleError(err)
+	if enabled {
+		return
+	}
+
+	fmt.Println("Building the transaction index")
+	err = chain.ReindexTransactions()
+	handleError(err)
+}
+
+// serveInBackground serves the handler at localhost:port in a goroutine, unless port is 0
+func serveInBackground(name string, port int, handler http.Handler) {
+	if port == 0 {
+		return
+	}
+	addr := fmt.Sprintf("localhost:%d", port)
+	go func() {
+		err := http.ListenAndServe(addr, handler)
+		fmt.Printf("%s stopped: %v\n", name, err)
+	}()
+	fmt.Printf("%s at http://%s\n", name, addr)
+}
+
 func (cli *CommandLine) printAll() {
 	chain, err := blockchain.ContinueBlockchain()
 	handleError(err)
@@ -95,7 +561,10 @@ func (cli *CommandLine) printAll() {
 			break
 		}
 
-		fmt.Printf("Block hash: %x\n\n", block.Hash)
+		fmt.Printf("Block hash: %x\n", block.Hash)
+		fmt.Printf("Height: %d\nTimestamp: %s\nPrev hash: %x\nMerkle root: %x\nBits: %d\nNonce: %d\n\n",
+			block.Header.Height, time.Unix(block.Header.Timestamp, 0), block.Header.PrevHash,
+			block.Header.MerkleRoot, block.Header.Bits, block.Header.Nonce)
 		for _, tx := range block.Transactions {
 			fmt.Printf("Transaction Hash: %x\n\n", tx.HashID)
 
@@ -107,7 +576,7 @@ func (cli *CommandLine) printAll() {
 
 			fmt.Println("\nOUTPUTS:")
 			for _, out := range tx.Outputs {
-				fmt.Printf("Amount: %d\nPubKey: %x\n", out.Value, out.PubKeyHash)
+				fmt.Printf("Amount: %d\nPubKey: %x\n", out.Value, out.AddressHash())
 			}
 
 			pow := blockchain.NewProof(block)
@@ -128,14 +597,130 @@ func (cli *CommandLine) Run() {
 		cli.showWallets()
 	case "getbalance":
 		cli.getBalance(os.Args[2])
+	case "history":
+		flags := flag.NewFlagSet("history", flag.ExitOnError)
+		skip := flags.Int("skip", 0, "amount of entries to skip")
+		count := flags.Int("count", 20, "amount of entries to show, all of them if it's 0")
+		flags.Parse(os.Args[3:])
+		cli.history(os.Args[2], *skip, *count)
 	case "newtransaction":
 		amount, err := strconv.Atoi(os.Args[4])
 		if err != nil {
 			panic(err)
 		}
-		cli.newTransaction(os.Args[2], os.Args[3], amount)
+		opts := blockchain.TxOptions{}
+		flags := flag.NewFlagSet("newtransaction", flag.ExitOnError)
+		flags.IntVar(&opts.Fee, "fee", 0, "fee paid to the miner")
+		flags.IntVar(&opts.FeeRate, "feerate", 0, "fee paid to the miner per 1000 bytes")
+		sigHash := flags.String("sighash", "ALL",
+			"parts signed: ALL, NONE or SINGLE, optionally followed by |ANYONECANPAY")
+		flags.Parse(os.Args[5:])
+		opts.SigHashType, err = blockchain.ParseSigHashType(*sigHash)
+		handleError(err)
+		cli.newTransaction(os.Args[2], os.Args[3], amount, opts)
+	case "createmultisig":
+		m, err := strconv.Atoi(os.Args[2])
+		handleError(err)
+		cli.createMultisig(m, os.Args[3:])
+	case "newmultisigtx":
+		amount, err := strconv.Atoi(os.Args[4])
+		handleError(err)
+		opts := blockchain.TxOptions{}
+		flags := flag.NewFlagSet("newmultisigtx", flag.ExitOnError)
+		flags.IntVar(&opts.Fee, "fee", 0, "fee paid to the miner")
+		flags.IntVar(&opts.FeeRate, "feerate", 0, "fee paid to the miner per 1000 bytes")
+		flags.Parse(os.Args[5:])
+		cli.newMultisigTransaction(os.Args[2], os.Args[3], amount, opts)
+	case "signmultisigtx":
+		flags := flag.NewFlagSet("signmultisigtx", flag.ExitOnError)
+		sigHash := flags.String("sighash", "ALL",
+			"parts signed: ALL, NONE or SINGLE, optionally followed by |ANYONECANPAY")
+		flags.Parse(os.Args[4:])
+		hashType, err := blockchain.ParseSigHashType(*sigHash)
+		handleError(err)
+		cli.signMultisigTransaction(os.Args[2], os.Args[3], hashType)
+	case "createpsbt":
+		amount, err := strconv.Atoi(os.Args[4])
+		handleError(err)
+		opts := blockchain.TxOptions{}
+		flags := flag.NewFlagSet("createpsbt", flag.ExitOnError)
+		flags.IntVar(&opts.Fee, "fee", 0, "fee paid to the miner")
+		flags.IntVar(&opts.FeeRate, "feerate", 0, "fee paid to the miner per 1000 bytes")
+		redeemScript := flags.String("redeemscript", "", "redeem script of the multisig address in hex")
+		flags.Parse(os.Args[5:])
+		cli.createPSBT(os.Args[2], os.Args[3], amount, *redeemScript, opts)
+	case "signpsbt":
+		flags := flag.NewFlagSet("signpsbt", flag.ExitOnError)
+		sigHash := flags.String("sighash", "ALL",
+			"parts signed: ALL, NONE or SINGLE, optionally followed by |ANYONECANPAY")
+		flags.Parse(os.Args[4:])
+		hashType, err := blockchain.ParseSigHashType(*sigHash)
+		handleError(err)
+		cli.signPSBT(os.Args[2], os.Args[3], hashType)
+	case "combinepsbt":
+		cli.combinePSBT(os.Args[2:])
+	case "finalizepsbt":
+		cli.finalizePSBT(os.Args[2])
+	case "broadcast":
+		flags := flag.NewFlagSet("broadcast", flag.ExitOnError)
+		rpcURL := flags.String("rpc", "", "URL of the JSON-RPC API of a node, e.g. http://localhost:8332")
+		flags.Parse(os.Args[3:])
+		cli.broadcast(os.Args[2], *rpcURL)
+	case "mine":
+		cli.mine(os.Args[2])
+	case "mempool":
+		cli.showMempool()
 	case "newblockchain":
 		cli.newBlockchain(os.Args[2])
+	case "supply":
+		// without a height, the supply at the last block of the chain is shown
+		height := -1
+		if len(os.Args) > 2 {
+			var err error
+			height, err = strconv.Atoi(os.Args[2])
+			handleError(err)
+		}
+		cli.supply(height)
+	case "reindexutxo":
+		cli.reindexUTXO()
+	case "getblockhash":
+		height, err := strconv.Atoi(os.Args[2])
+		handleError(err)
+		cli.getBlockHash(height)
+	case "getblockcount":
+		cli.getBlockCount()
+	case "reindexaddr":
+		cli.reindexAddresses()
+	case "reindextx":
+		cli.reindexTransactions()
+	case "gettx":
+		cli.getTransaction(os.Args[2])
+	case "startnode":
+		flags := flag.NewFlagSet("startnode", flag.ExitOnError)
+		port := flags.Int("port", 3000, "port where the node listens to connections")
+		connect := flags.String("connect", "", "comma separated addresses of the peers")
+		miner := flags.String("miner", "", "address rewarded for the mined blocks")
+		dataDir := flags.String("datadir", database.DBPath, "folder of the database")
+		rpcPort := flags.Int("rpcport", 0, "port of the JSON-RPC API, disabled if it's 0")
+		restPort := flags.Int("restport", 0, "port of the REST API, disabled if it's 0")
+		explorerPort := flags.Int("explorerport", 0, "port of the web explorer, disabled if it's 0")
+		txIndex := flags.Bool("txindex", false, "enable the transaction index")
+		flags.Parse(os.Args[2:])
+
+		var peers []string
+		if *connect != "" {
+			peers = strings.Split(*connect, ",")
+		}
+		cli.startNode(*port, peers, *miner, *dataDir, *rpcPort, *restPort, *explorerPort, *txIndex)
+	case "startrpc":
+		flags := flag.NewFlagSet("startrpc", flag.ExitOnError)
+		port := flags.Int("port", 8332, "port of the JSON-RPC API")
+		restPort := flags.Int("restport", 0, "port of the REST API, disabled if it's 0")
+		explorerPort := flags.Int("explorerport", 0, "port of the web explorer, disabled if it's 0")
+		dataDir := flags.String("datadir", database.DBPath, "folder of the database")
+		txIndex := flags.Bool("txindex", false, "enable the transaction index")
+		flags.Parse(os.Args[2:])
+		cli.startRPC(*port, *restPort, *explorerPort, *dataDir, *txIndex)
 	case "print":
 		cli.printAll()
 	default:
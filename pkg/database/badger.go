@@ -0,0 +1,91 @@
+package database
+
+import "github.com/dgraph-io/badger"
+
+// BadgerStore is a Store on disk, backed by badger
+type BadgerStore struct {
+	db *badger.DB
+}
+
+// OpenBadgerStore opens (or creates) the badger database stored in the folder passed as
+// argument
+func OpenBadgerStore(path string) (*BadgerStore, error) {
+	opts := badger.DefaultOptions(path)
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BadgerStore{db}, nil
+}
+
+// View runs fn in a read-only transaction
+func (s *BadgerStore) View(fn func(txn Txn) error) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		return fn(badgerTxn{txn})
+	})
+}
+
+// Update runs fn in a read-write transaction
+func (s *BadgerStore) Update(fn func(txn Txn) error) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return fn(badgerTxn{txn})
+	})
+}
+
+// NewBatch creates a batch of writes
+func (s *BadgerStore) NewBatch() Batch {
+	return s.db.NewWriteBatch()
+}
+
+// DropPrefix removes all the keys with any of the prefixes
+func (s *BadgerStore) DropPrefix(prefixes ...[]byte) error {
+	return s.db.DropPrefix(prefixes...)
+}
+
+// Close closes the database
+func (s *BadgerStore) Close() error {
+	return s.db.Close()
+}
+
+type badgerTxn struct {
+	txn *badger.Txn
+}
+
+func (t badgerTxn) Get(key []byte) ([]byte, error) {
+	item, err := t.txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+func (t badgerTxn) Set(key, value []byte) error {
+	return t.txn.Set(key, value)
+}
+
+func (t badgerTxn) Delete(key []byte) error {
+	return t.txn.Delete(key)
+}
+
+func (t badgerTxn) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	it := t.txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		err = fn(item.KeyCopy(nil), value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,8 +2,7 @@ package database
 
 import (
 	"os"
-
-	"github.com/dgraph-io/badger"
+	"path/filepath"
 )
 
 var (
@@ -12,16 +11,14 @@ var (
 	DBFile = "./db/MANIFEST"
 )
 
-// ConnectDB connects to the database
-func ConnectDB(path string) *badger.DB {
-	opts := badger.DefaultOptions(path)
-	opts.Logger = nil
-	db, err := badger.Open(opts)
+// ConnectDB connects to the badger database stored in the folder passed as argument
+func ConnectDB(path string) Store {
+	store, err := OpenBadgerStore(path)
 	if err != nil {
 		panic(err)
 	}
 
-	return db
+	return store
 }
 
 // DBExists checks if the database already exists
@@ -29,3 +26,9 @@ func DBexists() bool {
 	_, err := os.Stat(DBFile)
 	return !os.IsNotExist(err)
 }
+
+// DBexistsAt checks if the database stored in the folder passed as argument already exists
+func DBexistsAt(path string) bool {
+	_, err := os.Stat(filepath.Join(path, "MANIFEST"))
+	return !os.IsNotExist(err)
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+)
+
+var errReadOnly = errors.New("database: read-only transaction")
+
+// MemoryStore is a Store kept in memory, which is lost when it's closed. The read-write
+// transactions are serialized and the reads see the last committed state
+type MemoryStore struct {
+	mu       sync.RWMutex // protects data
+	updateMu sync.Mutex   // serializes the read-write transactions
+	data     map[string][]byte
+}
+
+// NewMemoryStore creates an empty store in memory
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string][]byte)}
+}
+
+// View runs fn in a read-only transaction
+func (s *MemoryStore) View(fn func(txn Txn) error) error {
+	return fn(&memoryTxn{store: s})
+}
+
+// Update runs fn in a read-write transaction, its writes are applied at once if fn
+// doesn't return an error
+func (s *MemoryStore) Update(fn func(txn Txn) error) error {
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
+
+	txn := &memoryTxn{store: s, writes: make(map[string][]byte)}
+	err := fn(txn)
+	if err != nil {
+		return err
+	}
+	s.apply(txn.writes)
+	return nil
+}
+
+// NewBatch creates a batch of writes
+func (s *MemoryStore) NewBatch() Batch {
+	return &memoryBatch{store: s, writes: make(map[string][]byte)}
+}
+
+// DropPrefix removes all the keys with any of the prefixes
+func (s *MemoryStore) DropPrefix(prefixes ...[]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key := range s.data {
+		for _, prefix := range prefixes {
+			if bytes.HasPrefix([]byte(key), prefix) {
+				delete(s.data, key)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Close discards the data of the store
+func (s *MemoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data = make(map[string][]byte)
+	return nil
+}
+
+// apply writes the values, a nil value deletes the key
+func (s *MemoryStore) apply(writes map[string][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, value := range writes {
+		if value == nil {
+			delete(s.data, key)
+		} else {
+			s.data[key] = value
+		}
+	}
+}
+
+type memoryTxn struct {
+	store  *MemoryStore
+	writes map[string][]byte // nil in the read-only transactions
+}
+
+func (t *memoryTxn) Get(key []byte) ([]byte, error) {
+	value, ok := t.writes[string(key)]
+	if !ok {
+		t.store.mu.RLock()
+		value, ok = t.store.data[string(key)]
+		t.store.mu.RUnlock()
+	}
+	if !ok || value == nil {
+		return nil, ErrKeyNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (t *memoryTxn) Set(key, value []byte) error {
+	if t.writes == nil {
+		return errReadOnly
+	}
+	t.writes[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (t *memoryTxn) Delete(key []byte) error {
+	if t.writes == nil {
+		return errReadOnly
+	}
+	t.writes[string(key)] = nil
+	return nil
+}
+
+func (t *memoryTxn) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	// the keys are collected first, so fn can use the transaction
+	t.store.mu.RLock()
+	var keys []string
+	for key := range t.store.data {
+		if _, ok := t.writes[key]; !ok && bytes.HasPrefix([]byte(key), prefix) {
+			keys = append(keys, key)
+		}
+	}
+	t.store.mu.RUnlock()
+	for key, value := range t.writes {
+		if value != nil && bytes.HasPrefix([]byte(key), prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, err := t.Get([]byte(key))
+		if err == ErrKeyNotFound {
+			// removed by another transaction
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = fn([]byte(key), value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type memoryBatch struct {
+	store  *MemoryStore
+	writes map[string][]byte
+}
+
+func (b *memoryBatch) Set(key, value []byte) error {
+	b.writes[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (b *memoryBatch) Delete(key []byte) error {
+	b.writes[string(key)] = nil
+	return nil
+}
+
+func (b *memoryBatch) Flush() error {
+	b.store.apply(b.writes)
+	b.writes = make(map[string][]byte)
+	return nil
+}
+
+func (b *memoryBatch) Cancel() {
+	b.writes = make(map[string][]byte)
+}
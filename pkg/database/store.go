@@ -0,0 +1,41 @@
+package database
+
+import "errors"
+
+// ErrKeyNotFound is returned when the key isn't in the store
+var ErrKeyNotFound = errors.New("database: key not found")
+
+// Store is a key-value store with atomic transactions, where the chain keeps its blocks,
+// its tip, the UTXO set and the indexes
+type Store interface {
+	// View runs fn in a read-only transaction
+	View(fn func(txn Txn) error) error
+	// Update runs fn in a read-write transaction, which is committed if fn doesn't return
+	// an error and discarded otherwise
+	Update(fn func(txn Txn) error) error
+	// NewBatch creates a batch of writes, which isn't atomic but can be larger than a
+	// transaction
+	NewBatch() Batch
+	// DropPrefix removes all the keys with any of the prefixes
+	DropPrefix(prefixes ...[]byte) error
+	Close() error
+}
+
+// Txn is a transaction of a Store
+type Txn interface {
+	// Get returns the value of the key, or ErrKeyNotFound
+	Get(key []byte) ([]byte, error)
+	Set(key, value []byte) error
+	Delete(key []byte) error
+	// Iterate calls fn for every key with the prefix in ascending order. If fn returns an
+	// error the iteration stops and the error is returned
+	Iterate(prefix []byte, fn func(key, value []byte) error) error
+}
+
+// Batch is a batch of writes of a Store, which are written when it's flushed
+type Batch interface {
+	Set(key, value []byte) error
+	Delete(key []byte) error
+	Flush() error
+	Cancel()
+}
@@ -0,0 +1,150 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"jotacoin/pkg/blockchain"
+	"jotacoin/pkg/utils"
+)
+
+const (
+	// ProtocolVersion is the version of the wire protocol
+	ProtocolVersion = 2
+	// commandLength is the size of the command field of the messages
+	commandLength = 12
+	// maxPayloadSize is the maximum size of the payload of a message
+	maxPayloadSize = 32 << 20
+	// maxInvItems is the maximum amount of items in an inv message
+	maxInvItems = 500
+	// maxHeaders is the maximum amount of headers in a headers message
+	maxHeaders = 2000
+)
+
+// Commands of the wire protocol
+const (
+	cmdVersion    = "version"
+	cmdVerack     = "verack"
+	cmdGetBlocks  = "getblocks"
+	cmdGetHeaders = "getheaders"
+	cmdHeaders    = "headers"
+	cmdInv        = "inv"
+	cmdGetData    = "getdata"
+	cmdBlock      = "block"
+	cmdTx         = "tx"
+)
+
+// Types of the items of the inv and getdata messages
+const (
+	invBlock = "block"
+	invTx    = "tx"
+)
+
+var (
+	errPayloadTooBig = errors.New("network: message payload too big")
+	// errBadMessage is returned when a payload can't be decoded or misses its content. The
+	// peer that sends it is disconnected
+	errBadMessage = errors.New("network: malformed message")
+)
+
+// versionMsg is the first message sent by both sides of a connection
+type versionMsg struct {
+	Version    int
+	BestHeight int
+	AddrFrom   string // address where the sender listens to connections
+}
+
+// verackMsg acknowledges the version of the other side of the connection
+type verackMsg struct {
+	Version int
+}
+
+// getBlocksMsg asks for the hashes of the blocks after the first hash of the locator
+// that is in the main chain of the receiver
+type getBlocksMsg struct {
+	Locator [][]byte
+}
+
+// getHeadersMsg asks for the headers of the blocks after the first hash of the locator
+// that is in the main chain of the receiver
+type getHeadersMsg struct {
+	Locator [][]byte
+}
+
+// headersMsg contains consecutive headers of the main chain, in ascending order
+type headersMsg struct {
+	Headers []blockchain.BlockHeader
+}
+
+// invMsg announces blocks or transactions
+type invMsg struct {
+	Type  string
+	Items [][]byte
+}
+
+// getDataMsg asks for the content of blocks or transactions
+type getDataMsg struct {
+	Type  string
+	Items [][]byte
+}
+
+type blockMsg struct {
+	Block *blockchain.Block
+}
+
+type txMsg struct {
+	Tx *blockchain.Transaction
+}
+
+// writeMessage writes a message: the command padded to commandLength bytes, the
+// payload size as a big endian uint32 and then the payload (encoded with gob, which
+// encodes the blocks and transactions with their canonical serialization)
+func writeMessage(w io.Writer, command string, payload any) error {
+	data, err := utils.Serialize(payload)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxPayloadSize {
+		return errPayloadTooBig
+	}
+
+	msg := make([]byte, commandLength+4, commandLength+4+len(data))
+	copy(msg, command)
+	binary.BigEndian.PutUint32(msg[commandLength:], uint32(len(data)))
+	msg = append(msg, data...)
+
+	_, err = w.Write(msg)
+	return err
+}
+
+// readMessage reads a message written by writeMessage, returning the command and the
+// payload still encoded
+func readMessage(r io.Reader) (string, []byte, error) {
+	header := make([]byte, commandLength+4)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return "", nil, err
+	}
+
+	command := string(bytes.TrimRight(header[:commandLength], "\x00"))
+	size := binary.BigEndian.Uint32(header[commandLength:])
+	if size > maxPayloadSize {
+		return "", nil, errPayloadTooBig
+	}
+
+	payload := make([]byte, size)
+	_, err = io.ReadFull(r, payload)
+	return command, payload, err
+}
+
+func decodePayload(payload []byte, msg any) error {
+	decoder := gob.NewDecoder(bytes.NewReader(payload))
+	err := decoder.Decode(msg)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errBadMessage, err)
+	}
+	return nil
+}
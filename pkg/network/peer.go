@@ -0,0 +1,359 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"jotacoin/pkg/blockchain"
+	"log"
+	"net"
+	"sync"
+)
+
+var errBadHandshake = errors.New("network: bad handshake")
+
+// peer is a connection with another node
+type peer struct {
+	addr string
+	conn net.Conn
+
+	// mu guards the fields below and serializes the writes into the connection
+	mu         sync.Mutex
+	ready      bool // the handshake is done
+	bestHeight int
+}
+
+func (p *peer) send(command string, payload any) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return writeMessage(p.conn, command, payload)
+}
+
+func (p *peer) isReady() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.ready
+}
+
+func (p *peer) setReady() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ready = true
+}
+
+// handlePeer does the handshake with the peer and then handles its messages until the
+// connection is closed or the peer breaks the protocol. The side that opens the
+// connection sends its version first
+func (s *Server) handlePeer(p *peer, outbound bool) {
+	if outbound {
+		err := s.sendVersion(p)
+		if err != nil {
+			log.Printf("network: sending version to %s: %v", p.addr, err)
+			return
+		}
+	}
+
+	for {
+		command, payload, err := readMessage(p.conn)
+		if err != nil {
+			return
+		}
+
+		err = s.handleMessage(p, command, payload, outbound)
+		if err != nil {
+			log.Printf("network: handling %s from %s: %v", command, p.addr, err)
+			if errors.Is(err, errBadHandshake) || errors.Is(err, errBadMessage) {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) handleMessage(p *peer, command string, payload []byte, outbound bool) error {
+	if !p.isReady() && command != cmdVersion && command != cmdVerack {
+		return fmt.Errorf("%w: %s before verack", errBadHandshake, command)
+	}
+
+	switch command {
+	case cmdVersion:
+		return s.handleVersion(p, payload, outbound)
+	case cmdVerack:
+		return s.handleVerack(p)
+	case cmdGetBlocks:
+		return s.handleGetBlocks(p, payload)
+	case cmdGetHeaders:
+		return s.handleGetHeaders(p, payload)
+	case cmdHeaders:
+		return s.handleHeaders(p, payload)
+	case cmdInv:
+		return s.handleInv(p, payload)
+	case cmdGetData:
+		return s.handleGetData(p, payload)
+	case cmdBlock:
+		return s.handleBlock(p, payload)
+	case cmdTx:
+		return s.handleTx(p, payload)
+	default:
+		return fmt.Errorf("network: unknown command %q", command)
+	}
+}
+
+func (s *Server) sendVersion(p *peer) error {
+	height, err := s.Height()
+	if err != nil {
+		return err
+	}
+	return p.send(cmdVersion, versionMsg{ProtocolVersion, height, s.Addr})
+}
+
+// handleVersion answers the version of the peer with a verack (and with its own version,
+// if the peer opened the connection)
+func (s *Server) handleVersion(p *peer, payload []byte, outbound bool) error {
+	var msg versionMsg
+	err := decodePayload(payload, &msg)
+	if err != nil {
+		return err
+	}
+	if msg.Version != ProtocolVersion {
+		return fmt.Errorf("%w: protocol version %d", errBadHandshake, msg.Version)
+	}
+
+	p.mu.Lock()
+	p.bestHeight = msg.BestHeight
+	p.mu.Unlock()
+
+	if !outbound {
+		err = s.sendVersion(p)
+		if err != nil {
+			return err
+		}
+	}
+	return p.send(cmdVerack, verackMsg{ProtocolVersion})
+}
+
+// handleVerack completes the handshake: asks for the headers the peer has (if it has
+// more than this node) and announces the transactions of the mempool
+func (s *Server) handleVerack(p *peer) error {
+	p.setReady()
+
+	progress, err := s.SyncProgress()
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	peerHeight := p.bestHeight
+	p.mu.Unlock()
+	if peerHeight > progress.HeaderHeight {
+		err = s.sync.requestHeaders(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	var txs [][]byte
+	for _, entry := range s.Mempool.Entries() {
+		txs = append(txs, entry.Tx.HashID)
+	}
+	for len(txs) > 0 {
+		n := len(txs)
+		if n > maxInvItems {
+			n = maxInvItems
+		}
+		err = p.send(cmdInv, invMsg{invTx, txs[:n]})
+		if err != nil {
+			return err
+		}
+		txs = txs[n:]
+	}
+	return nil
+}
+
+// handleGetBlocks announces the blocks that follow the last block the peer has in common
+// with this node
+func (s *Server) handleGetBlocks(p *peer, payload []byte) error {
+	var msg getBlocksMsg
+	err := decodePayload(payload, &msg)
+	if err != nil {
+		return err
+	}
+
+	s.chainMu.Lock()
+	hashes, err := s.Chain.HashesAfter(msg.Locator, maxInvItems)
+	s.chainMu.Unlock()
+	if err != nil || len(hashes) == 0 {
+		return err
+	}
+	return p.send(cmdInv, invMsg{invBlock, hashes})
+}
+
+// handleGetHeaders sends the headers of the blocks that follow the last block the peer
+// has in common with this node
+func (s *Server) handleGetHeaders(p *peer, payload []byte) error {
+	var msg getHeadersMsg
+	err := decodePayload(payload, &msg)
+	if err != nil {
+		return err
+	}
+
+	s.chainMu.Lock()
+	hashes, err := s.Chain.HashesAfter(msg.Locator, maxHeaders)
+	var headers []blockchain.BlockHeader
+	if err == nil && len(hashes) > 0 {
+		headers, err = s.Chain.Headers(hashes)
+	}
+	s.chainMu.Unlock()
+	if err != nil || len(headers) == 0 {
+		return err
+	}
+	return p.send(cmdHeaders, headersMsg{headers})
+}
+
+func (s *Server) handleHeaders(p *peer, payload []byte) error {
+	var msg headersMsg
+	err := decodePayload(payload, &msg)
+	if err != nil {
+		return err
+	}
+	return s.sync.handleHeaders(p, msg.Headers)
+}
+
+// handleInv asks for the announced blocks and transactions that this node doesn't have
+func (s *Server) handleInv(p *peer, payload []byte) error {
+	var msg invMsg
+	err := decodePayload(payload, &msg)
+	if err != nil {
+		return err
+	}
+	if len(msg.Items) > maxInvItems {
+		return fmt.Errorf("network: inv with %d items", len(msg.Items))
+	}
+
+	var missing [][]byte
+	s.chainMu.Lock()
+	for _, hash := range msg.Items {
+		switch msg.Type {
+		case invBlock:
+			if !s.Chain.HasBlock(hash) {
+				missing = append(missing, hash)
+			}
+		case invTx:
+			if s.Mempool.Get(hash) == nil {
+				missing = append(missing, hash)
+			}
+		}
+	}
+	s.chainMu.Unlock()
+
+	if len(missing) == 0 {
+		return nil
+	}
+	return p.send(cmdGetData, getDataMsg{msg.Type, missing})
+}
+
+// handleGetData sends the requested blocks and transactions (of the mempool)
+func (s *Server) handleGetData(p *peer, payload []byte) error {
+	var msg getDataMsg
+	err := decodePayload(payload, &msg)
+	if err != nil {
+		return err
+	}
+	if len(msg.Items) > maxInvItems {
+		return fmt.Errorf("network: getdata with %d items", len(msg.Items))
+	}
+
+	for _, hash := range msg.Items {
+		switch msg.Type {
+		case invBlock:
+			s.chainMu.Lock()
+			b, err := s.Chain.GetBlock(hash)
+			s.chainMu.Unlock()
+			if err != nil {
+				continue
+			}
+			err = p.send(cmdBlock, blockMsg{b})
+			if err != nil {
+				return err
+			}
+		case invTx:
+			tx := s.Mempool.Get(hash)
+			if tx == nil {
+				continue
+			}
+			err = p.send(cmdTx, txMsg{tx})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// handleBlock adds the block into the chain and relays it. If this node is missing its
+// ancestors, their headers are asked to the peer. The blocks requested by the sync
+// manager are handled by it
+func (s *Server) handleBlock(p *peer, payload []byte) error {
+	var msg blockMsg
+	err := decodePayload(payload, &msg)
+	if err != nil {
+		return err
+	}
+	b := msg.Block
+	if b == nil {
+		return fmt.Errorf("%w: block message without block", errBadMessage)
+	}
+	if s.sync.handleBlock(p, b) {
+		return nil
+	}
+
+	s.chainMu.Lock()
+	known := s.Chain.HasBlock(b.Hash)
+	missingParent := !b.IsGenesis() && !s.Chain.HasBlock(b.Header.PrevHash)
+	if !known && !missingParent {
+		err = s.Chain.AcceptBlock(b)
+	}
+	s.chainMu.Unlock()
+
+	if known {
+		return nil
+	}
+	if missingParent {
+		return s.sync.requestHeaders(p)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("network: new block %x (height %d) from %s", b.Hash, b.Header.Height, p.addr)
+	s.broadcast(cmdInv, invMsg{invBlock, [][]byte{b.Hash}}, p)
+	s.signalNewTip()
+	return nil
+}
+
+// handleTx adds the transaction into the mempool and relays it
+func (s *Server) handleTx(p *peer, payload []byte) error {
+	var msg txMsg
+	err := decodePayload(payload, &msg)
+	if err != nil {
+		return err
+	}
+	tx := msg.Tx
+	if tx == nil {
+		return fmt.Errorf("%w: tx message without transaction", errBadMessage)
+	}
+
+	s.chainMu.Lock()
+	_, err = s.Mempool.Add(tx)
+	s.chainMu.Unlock()
+	if errors.Is(err, blockchain.ErrTxInMempool) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.broadcast(cmdInv, invMsg{invTx, [][]byte{tx.HashID}}, p)
+	s.signalNewTip()
+	return nil
+}
@@ -0,0 +1,330 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"jotacoin/pkg/blockchain"
+	"log"
+	"net"
+	"sync"
+)
+
+// Server is a node of the network: it listens to connections from other nodes, keeps the
+// chain and the mempool in sync with its peers and relays blocks and transactions
+type Server struct {
+	// Addr is the address where the server listens to connections
+	Addr    string
+	Chain   *blockchain.Blockchain
+	Mempool *blockchain.Mempool
+
+	// chainMu serializes the access to the chain and the mempool
+	chainMu  sync.Mutex
+	peersMu  sync.Mutex
+	peers    map[string]*peer
+	listener net.Listener
+	sync     *syncManager
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// newTip is signaled when the chain tip or the mempool changes, so the miner
+	// (if it's running) restarts with a new block template
+	newTip       chan struct{}
+	miningMu     sync.Mutex
+	cancelMining context.CancelFunc
+}
+
+// NewServer creates a server that will listen to connections at addr
+func NewServer(addr string, chain *blockchain.Blockchain, mempool *blockchain.Mempool) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		Addr:    addr,
+		Chain:   chain,
+		Mempool: mempool,
+		peers:   make(map[string]*peer),
+		ctx:     ctx,
+		cancel:  cancel,
+		newTip:  make(chan struct{}, 1),
+	}
+	s.sync = newSyncManager(s)
+	return s
+}
+
+// Start starts listening to connections. If the port of Addr is 0, Addr is updated with
+// the port chosen by the system
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.Addr = listener.Addr().String()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.sync.run()
+	}()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.addPeer(conn, "", false)
+		}
+	}()
+
+	return nil
+}
+
+// Connect connects to the node listening at addr
+func (s *Server) Connect(addr string) error {
+	if addr == s.Addr {
+		return errors.New("network: can't connect to itself")
+	}
+
+	s.peersMu.Lock()
+	_, connected := s.peers[addr]
+	s.peersMu.Unlock()
+	if connected {
+		return nil
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.addPeer(conn, addr, true)
+	return nil
+}
+
+// Close disconnects from all the peers and stops the server
+func (s *Server) Close() error {
+	s.cancel()
+
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	s.peersMu.Lock()
+	for _, p := range s.peers {
+		p.conn.Close()
+	}
+	s.peersMu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// Lock locks the chain and the mempool, so they can be used while the server runs
+func (s *Server) Lock() {
+	s.chainMu.Lock()
+}
+
+// Unlock unlocks the chain and the mempool
+func (s *Server) Unlock() {
+	s.chainMu.Unlock()
+}
+
+// Peers returns the addresses of the connected peers
+func (s *Server) Peers() []string {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+
+	var addrs []string
+	for addr, p := range s.peers {
+		if p.isReady() {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// SyncProgress returns how far the chain is from the best chain known by the node
+func (s *Server) SyncProgress() (SyncProgress, error) {
+	s.chainMu.Lock()
+	height, err := s.Chain.Height()
+	if err != nil {
+		s.chainMu.Unlock()
+		return SyncProgress{}, err
+	}
+	header, _, err := s.Chain.BestHeader()
+	s.chainMu.Unlock()
+	if err != nil {
+		return SyncProgress{}, err
+	}
+
+	headerHeight := -1
+	if header != nil {
+		headerHeight = header.Height
+	}
+	return SyncProgress{headerHeight, height, s.sync.inFlightCount(), len(s.Peers())}, nil
+}
+
+// Height returns the height of the last block of the chain, or -1 if it's empty
+func (s *Server) Height() (int, error) {
+	s.chainMu.Lock()
+	defer s.chainMu.Unlock()
+
+	return s.Chain.Height()
+}
+
+// LastHash returns the hash of the last block of the chain
+func (s *Server) LastHash() []byte {
+	s.chainMu.Lock()
+	defer s.chainMu.Unlock()
+
+	return s.Chain.LastHash
+}
+
+// SubmitTransaction adds the transaction into the mempool and relays it to the peers.
+// It returns the fee of the transaction
+func (s *Server) SubmitTransaction(tx *blockchain.Transaction) (int, error) {
+	s.chainMu.Lock()
+	fee, err := s.Mempool.Add(tx)
+	s.chainMu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	s.broadcast(cmdInv, invMsg{invTx, [][]byte{tx.HashID}}, nil)
+	s.signalNewTip()
+	return fee, nil
+}
+
+// SubmitBlock adds the block into the chain and relays it to the peers
+func (s *Server) SubmitBlock(b *blockchain.Block) error {
+	s.chainMu.Lock()
+	err := s.Chain.AcceptBlock(b)
+	s.chainMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.broadcast(cmdInv, invMsg{invBlock, [][]byte{b.Hash}}, nil)
+	s.signalNewTip()
+	return nil
+}
+
+// StartMining starts mining blocks with the transactions of the mempool, rewarding the
+// address passed as argument. A block is only mined when the mempool isn't empty, and the
+// mining restarts whenever a new block arrives
+func (s *Server) StartMining(address string, workers int) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.mine(address, blockchain.NewMiner(workers))
+	}()
+	s.signalNewTip()
+}
+
+func (s *Server) mine(address string, miner *blockchain.Miner) {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.newTip:
+		}
+
+		s.chainMu.Lock()
+		var b *blockchain.Block
+		var err error
+		if s.Mempool.Count() > 0 {
+			b, err = s.Mempool.BlockTemplate(address)
+		}
+		s.chainMu.Unlock()
+		if b == nil || err != nil {
+			if err != nil {
+				log.Printf("network: creating block template: %v", err)
+			}
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(s.ctx)
+		s.miningMu.Lock()
+		s.cancelMining = cancel
+		s.miningMu.Unlock()
+
+		err = miner.Mine(ctx, b)
+		cancel()
+		if err != nil {
+			// cancelled by a new block, the newTip signal makes it start again
+			continue
+		}
+
+		log.Printf("network: mined block %x (%.0f H/s)", b.Hash, miner.HashRate())
+		err = s.SubmitBlock(b)
+		if err != nil {
+			log.Printf("network: mined block rejected: %v", err)
+		}
+	}
+}
+
+// signalNewTip aborts the current mining (if any) and tells the miner to start again
+func (s *Server) signalNewTip() {
+	s.miningMu.Lock()
+	if s.cancelMining != nil {
+		s.cancelMining()
+	}
+	s.miningMu.Unlock()
+
+	select {
+	case s.newTip <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Server) addPeer(conn net.Conn, addr string, outbound bool) {
+	if addr == "" {
+		addr = conn.RemoteAddr().String()
+	}
+	p := &peer{addr: addr, conn: conn}
+
+	s.peersMu.Lock()
+	s.peers[addr] = p
+	s.peersMu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.handlePeer(p, outbound)
+
+		s.peersMu.Lock()
+		if s.peers[p.addr] == p {
+			delete(s.peers, p.addr)
+		}
+		s.peersMu.Unlock()
+		conn.Close()
+		s.sync.removePeer(p)
+	}()
+}
+
+// readyPeers returns the peers that completed the handshake, except the one passed as
+// argument
+func (s *Server) readyPeers(except *peer) []*peer {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+
+	var peers []*peer
+	for _, p := range s.peers {
+		if p != except && p.isReady() {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+// broadcast sends the message to all the peers, except the one passed as argument
+func (s *Server) broadcast(command string, payload any, except *peer) {
+	for _, p := range s.readyPeers(except) {
+		err := p.send(command, payload)
+		if err != nil {
+			log.Printf("network: sending %s to %s: %v", command, p.addr, err)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"jotacoin/pkg/blockchain"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// blockDownloadWindow is the amount of blocks, after the last stored block, that can
+	// be downloaded at the same time
+	blockDownloadWindow = 1024
+	// maxBlocksInFlight is the maximum amount of blocks requested to a peer at the same time
+	maxBlocksInFlight = 16
+	// blockRequestTimeout is how long a peer has to send a requested block before it's
+	// requested to another peer
+	blockRequestTimeout = 10 * time.Second
+	// syncInterval is how often the timed out requests are retried
+	syncInterval = time.Second
+)
+
+// SyncProgress describes how far the chain of the node is from the best chain known
+type SyncProgress struct {
+	HeaderHeight int // height of the best header, -1 if there are no headers yet
+	BlockHeight  int // height of the tip of the chain, -1 if the chain is empty
+	InFlight     int // blocks requested and not received yet
+	Peers        int
+}
+
+// Synced checks if all the blocks of the best header chain are downloaded
+func (p SyncProgress) Synced() bool {
+	return p.BlockHeight >= p.HeaderHeight
+}
+
+func (p SyncProgress) String() string {
+	percent := 100.0
+	if p.HeaderHeight > 0 {
+		percent = 100 * float64(p.BlockHeight+1) / float64(p.HeaderHeight+1)
+	}
+	return fmt.Sprintf("blocks %d/%d (%.1f%%), %d in flight, %d peers",
+		p.BlockHeight, p.HeaderHeight, percent, p.InFlight, p.Peers)
+}
+
+type blockRequest struct {
+	peer   *peer
+	sentAt time.Time
+}
+
+type receivedBlock struct {
+	block *blockchain.Block
+	peer  *peer
+}
+
+// syncManager downloads the chain from the peers, headers first: the headers are
+// downloaded, validated (they contain the proof of work) and stored, and then the blocks
+// of the best header chain are requested in parallel to several peers. As the headers
+// are stored, the download resumes after a restart
+type syncManager struct {
+	s *Server
+
+	mu       sync.Mutex
+	inFlight map[string]*blockRequest  // block hash -> request
+	received map[string]*receivedBlock // blocks downloaded before their parent
+
+	// connectMu serializes the connection of the downloaded blocks
+	connectMu sync.Mutex
+}
+
+func newSyncManager(s *Server) *syncManager {
+	return &syncManager{
+		s:        s,
+		inFlight: make(map[string]*blockRequest),
+		received: make(map[string]*receivedBlock),
+	}
+}
+
+// run retries the timed out requests until the server is closed. It also starts the
+// download of the missing blocks when the sync is resumed after a restart
+func (sm *syncManager) run() {
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sm.s.ctx.Done():
+			return
+		case <-ticker.C:
+			sm.requestBlocks()
+		}
+	}
+}
+
+// requestHeaders asks the peer for the headers after the best header of this node
+func (sm *syncManager) requestHeaders(p *peer) error {
+	sm.s.chainMu.Lock()
+	locator, err := sm.s.Chain.HeaderLocator()
+	sm.s.chainMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return p.send(cmdGetHeaders, getHeadersMsg{locator})
+}
+
+// handleHeaders stores the headers sent by the peer, asks for more headers if there
+// can be more and requests the blocks of the new headers
+func (sm *syncManager) handleHeaders(p *peer, headers []blockchain.BlockHeader) error {
+	if len(headers) > maxHeaders {
+		return fmt.Errorf("network: headers with %d items", len(headers))
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+
+	sm.s.chainMu.Lock()
+	err := sm.s.Chain.AddHeaders(headers)
+	sm.s.chainMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	last := headers[len(headers)-1]
+	p.mu.Lock()
+	if last.Height > p.bestHeight {
+		p.bestHeight = last.Height
+	}
+	p.mu.Unlock()
+
+	if len(headers) == maxHeaders {
+		err = sm.requestHeaders(p)
+	}
+	sm.requestBlocks()
+	return err
+}
+
+// requestBlocks requests the missing blocks of the best header chain, splitting them
+// between the peers. The requests that timed out are sent again, maybe to another peer
+func (sm *syncManager) requestBlocks() {
+	sm.s.chainMu.Lock()
+	missing, err := sm.s.Chain.MissingBlocks(blockDownloadWindow)
+	sm.s.chainMu.Unlock()
+	if err != nil {
+		log.Printf("network: finding the missing blocks: %v", err)
+		return
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	peers := sm.s.readyPeers(nil)
+	requests := make(map[*peer][][]byte)
+	now := time.Now()
+
+	sm.mu.Lock()
+	load := make(map[*peer]int)
+	for _, req := range sm.inFlight {
+		load[req.peer]++
+	}
+	for _, header := range missing {
+		hash := header.Hash()
+		if _, ok := sm.received[string(hash)]; ok {
+			continue
+		}
+		if req, ok := sm.inFlight[string(hash)]; ok {
+			if now.Sub(req.sentAt) < blockRequestTimeout {
+				continue
+			}
+			load[req.peer]--
+			delete(sm.inFlight, string(hash))
+		}
+
+		p := leastLoadedPeer(peers, load, header.Height)
+		if p == nil {
+			continue
+		}
+		load[p]++
+		sm.inFlight[string(hash)] = &blockRequest{p, now}
+		requests[p] = append(requests[p], hash)
+	}
+	sm.mu.Unlock()
+
+	for p, hashes := range requests {
+		err := p.send(cmdGetData, getDataMsg{invBlock, hashes})
+		if err != nil {
+			log.Printf("network: requesting blocks to %s: %v", p.addr, err)
+		}
+	}
+}
+
+// leastLoadedPeer returns the peer with less blocks in flight that has the block at the
+// height passed as argument, or nil if all of them have too many blocks in flight
+func leastLoadedPeer(peers []*peer, load map[*peer]int, height int) *peer {
+	var best *peer
+	for _, p := range peers {
+		p.mu.Lock()
+		bestHeight := p.bestHeight
+		p.mu.Unlock()
+		if bestHeight < height || load[p] >= maxBlocksInFlight {
+			continue
+		}
+		if best == nil || load[p] < load[best] {
+			best = p
+		}
+	}
+	return best
+}
+
+// handleBlock takes the block sent by the peer if it was requested by the sync manager,
+// returning false otherwise. The blocks can arrive in any order, so they're kept until
+// their parent is stored
+func (sm *syncManager) handleBlock(p *peer, b *blockchain.Block) bool {
+	sm.mu.Lock()
+	_, requested := sm.inFlight[string(b.Hash)]
+	if requested {
+		delete(sm.inFlight, string(b.Hash))
+		sm.received[string(b.Hash)] = &receivedBlock{b, p}
+	}
+	sm.mu.Unlock()
+	if !requested {
+		return false
+	}
+
+	sm.connectBlocks()
+	sm.requestBlocks()
+	return true
+}
+
+// connectBlocks adds into the chain the downloaded blocks that follow the last stored
+// block of the best header chain
+func (sm *syncManager) connectBlocks() {
+	sm.connectMu.Lock()
+	defer sm.connectMu.Unlock()
+
+	sm.s.chainMu.Lock()
+	missing, err := sm.s.Chain.MissingBlocks(blockDownloadWindow)
+	sm.s.chainMu.Unlock()
+	if err != nil {
+		log.Printf("network: finding the missing blocks: %v", err)
+		return
+	}
+
+	var last *blockchain.Block
+	for _, header := range missing {
+		hash := string(header.Hash())
+		sm.mu.Lock()
+		r := sm.received[hash]
+		delete(sm.received, hash)
+		sm.mu.Unlock()
+		if r == nil {
+			break
+		}
+
+		sm.s.chainMu.Lock()
+		err = sm.s.Chain.AcceptBlock(r.block)
+		sm.s.chainMu.Unlock()
+		if err != nil {
+			sm.rejectBlock(r, err)
+			break
+		}
+		last = r.block
+	}
+	if last == nil {
+		return
+	}
+
+	progress, err := sm.s.SyncProgress()
+	if err == nil {
+		log.Printf("network: sync %s", progress)
+	}
+	sm.s.broadcast(cmdInv, invMsg{invBlock, [][]byte{last.Hash}}, nil)
+	sm.s.signalNewTip()
+}
+
+// rejectBlock handles a downloaded block that couldn't be added into the chain. If it's
+// invalid, its branch is flagged so it isn't requested again and the peer that sent it
+// is disconnected. Otherwise the block is requested again, maybe to another peer
+func (sm *syncManager) rejectBlock(r *receivedBlock, err error) {
+	var validationErr *blockchain.ValidationError
+	if !errors.As(err, &validationErr) || errors.Is(err, blockchain.ErrKnownBlock) {
+		log.Printf("network: downloaded block %x rejected: %v", r.block.Hash, err)
+		return
+	}
+
+	log.Printf("network: invalid block %x from %s: %v", r.block.Hash, r.peer.addr, err)
+	// if the transactions don't match the merkle root, the header may still be valid
+	if !errors.Is(err, blockchain.ErrBadMerkleRoot) {
+		sm.s.chainMu.Lock()
+		err = sm.s.Chain.InvalidateBlock(r.block.Hash)
+		sm.s.chainMu.Unlock()
+		if err != nil {
+			log.Printf("network: invalidating block %x: %v", r.block.Hash, err)
+		}
+	}
+	r.peer.conn.Close()
+}
+
+// removePeer forgets the blocks requested to the peer, so they're requested to others
+func (sm *syncManager) removePeer(p *peer) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	for hash, req := range sm.inFlight {
+		if req.peer == p {
+			delete(sm.inFlight, hash)
+		}
+	}
+}
+
+func (sm *syncManager) inFlightCount() int {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	return len(sm.inFlight)
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
 
 	"github.com/mr-tron/base58"
 	"golang.org/x/crypto/ripemd160"
@@ -16,6 +17,9 @@ const (
 	// of the wallet's address
 	ChecksumLength = 4
 	version        = byte(0x00)
+	// scriptHashVersion is the version of the addresses locked to the hash of a script,
+	// like the multisig addresses
+	scriptHashVersion = byte(0x05)
 )
 
 // Wallet stores the Private key and the public key. More info at:
@@ -32,11 +36,43 @@ func (w *Wallet) Address() (string, error) {
 		return "", err
 	}
 
-	versionedHash := append([]byte{version}, pubHash...)
+	return PubKeyHashToAddress(pubHash), nil
+}
+
+// PubKeyHashToAddress returns the address of the public key hash
+func PubKeyHashToAddress(pubKeyHash []byte) string {
+	return encodeAddress(version, pubKeyHash)
+}
+
+// ScriptHashToAddress returns the address of the script hash (HASH160 of the script)
+func ScriptHashToAddress(scriptHash []byte) string {
+	return encodeAddress(scriptHashVersion, scriptHash)
+}
+
+func encodeAddress(version byte, hash []byte) string {
+	versionedHash := append([]byte{version}, hash...)
 	checksumVal := checksum(versionedHash)
 
 	fullHash := append(versionedHash, checksumVal...)
-	return base58.Encode(fullHash), nil
+	return base58.Encode(fullHash)
+}
+
+// IsScriptHashAddress checks if the address is locked to a script hash instead of to a
+// public key hash
+func IsScriptHashAddress(address string) bool {
+	fullHash, err := base58.Decode(address)
+	return err == nil && len(fullHash) > 0 && fullHash[0] == scriptHashVersion
+}
+
+// AddressToPubKeyHash returns the public key hash of the address, checking its checksum.
+// For the script hash addresses it returns the script hash
+func AddressToPubKeyHash(address string) ([]byte, error) {
+	fullHash, err := base58.Decode(address)
+	if err != nil || len(fullHash) <= 1+ChecksumLength || !ValidateAddress(address) {
+		return nil, errors.New("wallet: invalid address")
+	}
+
+	return fullHash[1 : len(fullHash)-ChecksumLength], nil
 }
 
 // NewWallet creates a new wallet with random keys
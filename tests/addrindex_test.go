@@ -0,0 +1,77 @@
+package tests
+
+import (
+	"jotacoin/pkg/blockchain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddressIndex(t *testing.T) {
+	chainA, chainB := newForks(t)
+	defer chainA.DB.Close()
+	defer chainB.DB.Close()
+
+	// branch A: a block that sends 5 to address2 and an empty block
+	tx := newSignedTx(chainA)
+	assert.Equal(t, nil, chainA.AddBlock([]*blockchain.Transaction{newCoinbase(), tx}))
+	assert.Equal(t, nil, chainA.AddBlock([]*blockchain.Transaction{newCoinbase()}))
+
+	history, total, err := chainA.AddressHistory(address1, 0, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, total)
+	assert.Equal(t, []int{0, 1, 1, 2}, historyHeights(history))
+	assert.Equal(t, tx.HashID, history[2].TxHash)
+	assert.Equal(t, blockchain.InitialSubsidy, history[2].Sent)
+	assert.Equal(t, blockchain.InitialSubsidy-5, history[2].Received)
+	balance1, _ := balances(chainA)
+	assert.Equal(t, balance1, history[3].Balance)
+
+	history, total, err = chainA.AddressHistory(address2, 0, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, 5, history[0].Balance)
+
+	// a page keeps the running balance of the previous entries
+	page, total, err := chainA.AddressHistory(address1, 1, 2)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, total)
+	assert.Equal(t, []int{1, 1}, historyHeights(page))
+	assert.Equal(t, 2*blockchain.InitialSubsidy-5, page[1].Balance)
+	page, total, err = chainA.AddressHistory(address1, 4, 2)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, total)
+	assert.Equal(t, 0, len(page))
+
+	// the rebuilt index is the same
+	assert.Equal(t, nil, chainA.ReindexAddresses())
+	rebuilt, _, err := chainA.AddressHistory(address1, 0, 0)
+	assert.Equal(t, nil, err)
+	history, _, err = chainA.AddressHistory(address1, 0, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, history, rebuilt)
+
+	// the entries of the disconnected blocks are removed after a reorg
+	for i := 0; i < 3; i++ {
+		block := mineBlock(chainB, []*blockchain.Transaction{newCoinbase()})
+		assert.Equal(t, nil, chainB.AcceptBlock(block))
+		assert.Equal(t, nil, chainA.AcceptBlock(block))
+	}
+	assert.Equal(t, chainB.LastHash, chainA.LastHash)
+	history, total, err = chainA.AddressHistory(address1, 0, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, total)
+	assert.Equal(t, []int{0, 1, 2, 3}, historyHeights(history))
+	assert.Equal(t, 4*blockchain.InitialSubsidy, history[3].Balance)
+	_, total, err = chainA.AddressHistory(address2, 0, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, total)
+}
+
+func historyHeights(history []blockchain.HistoryEntry) []int {
+	var heights []int
+	for _, entry := range history {
+		heights = append(heights, entry.Height)
+	}
+	return heights
+}
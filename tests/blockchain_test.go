@@ -3,33 +3,29 @@ package tests
 import (
 	"fmt"
 	"jotacoin/pkg/blockchain"
-	"jotacoin/pkg/wallet"
+	"jotacoin/pkg/database"
 	"testing"
 
-	"github.com/dgraph-io/badger"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestAddBlock(t *testing.T) {
-	chain, err := blockchain.ContinueBlockchain()
-	if err != nil {
-		panic(err)
-	}
+	chain := newTestChain()
 	defer chain.DB.Close()
-	tx, err := blockchain.NewTransaction(address1, address2, 10, chain)
+	tx, err := blockchain.NewTransaction(address1, address2, 10, blockchain.TxOptions{}, chain)
+	assert.Equal(t, nil, err)
+	cbtx, err := blockchain.NewCoinbaseTx(address1, "", blockchain.InitialSubsidy)
+	assert.Equal(t, nil, err)
+	err = chain.AddBlock([]*blockchain.Transaction{cbtx, tx})
 	assert.Equal(t, nil, err)
-	chain.AddBlock([]*blockchain.Transaction{tx})
 
-	chain.DB.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lastHash"))
+	chain.DB.View(func(txn database.Txn) error {
+		lastHash, err := txn.Get([]byte("lastHash"))
 		if err != nil {
 			panic(err)
 		}
-
-		return item.Value(func(val []byte) error {
-			assert.Equal(t, chain.LastHash, val)
-			return nil
-		})
+		assert.Equal(t, chain.LastHash, lastHash)
+		return nil
 	})
 
 	// Go through all the blocks created
@@ -63,31 +59,18 @@ func TestAddBlock(t *testing.T) {
 	assert.GreaterOrEqual(t, blocksAmount, 2)
 }
 
-// Run it after TestAddBlock
 func TestGetBalance(t *testing.T) {
-	wallets, err := wallet.LoadFile()
-	if err != nil {
-		panic(err)
-	}
-	w1 := wallets.GetWallet(address1)
-	w2 := wallets.GetWallet(address2)
-	pubKeyHash1, err := wallet.PublicKeyHash(w1.PublicKey)
-	if err != nil {
-		panic(err)
-	}
-	pubKeyHash2, err := wallet.PublicKeyHash(w2.PublicKey)
-	if err != nil {
-		panic(err)
-	}
-
-	chain, err := blockchain.ContinueBlockchain()
+	chain, err := blockchain.NewBlockchainWithStore(database.NewMemoryStore(), address1)
 	if err != nil {
 		panic(err)
 	}
 	defer chain.DB.Close()
+	tx, err := blockchain.NewTransaction(address1, address2, 10, blockchain.TxOptions{}, chain)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, chain.AddBlock([]*blockchain.Transaction{newCoinbase(), tx}))
 
-	balance1 := chain.GetBalance(pubKeyHash1)
-	balance2 := chain.GetBalance(pubKeyHash2)
-	assert.Equal(t, 90, balance1)
+	balance1, balance2 := balances(chain)
+	// 100 from the genesis - 10 sent + 100 from mining the block
+	assert.Equal(t, 190, balance1)
 	assert.Equal(t, 10, balance2)
 }
@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"errors"
+	"jotacoin/pkg/blockchain"
+	"jotacoin/pkg/database"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestChain creates a chain in memory, isolated from the other tests, where address1
+// has the outputs of the genesis and of two more blocks
+func newTestChain() *blockchain.Blockchain {
+	chain, err := blockchain.NewBlockchainWithStore(database.NewMemoryStore(), address1)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 2; i++ {
+		err = chain.AddBlock([]*blockchain.Transaction{newCoinbase()})
+		if err != nil {
+			panic(err)
+		}
+	}
+	return chain
+}
+
+func TestMemoryStoreUpdate(t *testing.T) {
+	store := database.NewMemoryStore()
+	defer store.Close()
+
+	err := store.Update(func(txn database.Txn) error {
+		return txn.Set([]byte("a"), []byte("1"))
+	})
+	assert.Equal(t, nil, err)
+
+	// the writes of a failed transaction are discarded
+	failure := errors.New("failure")
+	err = store.Update(func(txn database.Txn) error {
+		txn.Set([]byte("a"), []byte("2"))
+		txn.Delete([]byte("a"))
+		_, err := txn.Get([]byte("a"))
+		assert.Equal(t, database.ErrKeyNotFound, err)
+		return failure
+	})
+	assert.Equal(t, failure, err)
+
+	store.View(func(txn database.Txn) error {
+		val, err := txn.Get([]byte("a"))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, []byte("1"), val)
+		assert.NotEqual(t, nil, txn.Set([]byte("b"), []byte("1")))
+		return nil
+	})
+}
+
+func TestMemoryStoreIterate(t *testing.T) {
+	store := database.NewMemoryStore()
+	defer store.Close()
+
+	batch := store.NewBatch()
+	for _, key := range []string{"p-c", "p-a", "q-a", "p-b"} {
+		assert.Equal(t, nil, batch.Set([]byte(key), []byte(key)))
+	}
+	assert.Equal(t, nil, batch.Flush())
+
+	// the pending writes of the transaction are iterated along with the stored keys
+	var keys []string
+	store.Update(func(txn database.Txn) error {
+		txn.Delete([]byte("p-b"))
+		txn.Set([]byte("p-d"), []byte("p-d"))
+		return txn.Iterate([]byte("p-"), func(key, value []byte) error {
+			keys = append(keys, string(key))
+			return nil
+		})
+	})
+	assert.Equal(t, []string{"p-a", "p-c", "p-d"}, keys)
+
+	assert.Equal(t, nil, store.DropPrefix([]byte("p-")))
+	keys = nil
+	store.View(func(txn database.Txn) error {
+		return txn.Iterate(nil, func(key, value []byte) error {
+			keys = append(keys, string(key))
+			return nil
+		})
+	})
+	assert.Equal(t, []string{"q-a"}, keys)
+}
@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"errors"
+	"jotacoin/pkg/blockchain"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mineBlockAt mines a block with only a coinbase on top of the chain tip, using the
+// timestamp and the difficulty bits passed as arguments
+func mineBlockAt(chain *blockchain.Blockchain, timestamp int64, bits int) *blockchain.Block {
+	lastBlock, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		panic(err)
+	}
+
+	header := blockchain.BlockHeader{
+		Version:   blockchain.BlockVersion,
+		Height:    lastBlock.Header.Height + 1,
+		Timestamp: timestamp,
+		PrevHash:  lastBlock.Hash,
+		Bits:      bits,
+	}
+	return blockchain.NewBlock([]*blockchain.Transaction{newCoinbase()}, header)
+}
+
+func TestDifficultyRetarget(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+
+	retargetInterval, targetBlockTime := blockchain.RetargetInterval, blockchain.TargetBlockTime
+	blockchain.RetargetInterval = 2
+	blockchain.TargetBlockTime = 100 * time.Second
+	defer func() {
+		blockchain.RetargetInterval, blockchain.TargetBlockTime = retargetInterval, targetBlockTime
+	}()
+
+	lastBlock, err := chain.GetBlock(chain.LastHash)
+	assert.Equal(t, nil, err)
+	timestamp := lastBlock.Header.Timestamp
+
+	// each mined block is 1 second after the previous one, except when passing a delay
+	mine := func(delay int64) {
+		bits, err := chain.NextDifficulty()
+		assert.Equal(t, nil, err)
+		timestamp += 1 + delay
+		assert.Equal(t, nil, chain.AcceptBlock(mineBlockAt(chain, timestamp, bits)))
+	}
+
+	// the retarget happens on even heights, so the tip must be at an even height
+	if lastBlock.Header.Height%2 != 0 {
+		mine(0)
+	}
+	startBits, err := chain.NextDifficulty()
+	assert.Equal(t, nil, err)
+
+	// a block mined 1 second after the previous one makes the difficulty go up
+	mine(0)
+	bits, err := chain.NextDifficulty()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, startBits+2, bits)
+
+	// blocks that don't claim the required difficulty are rejected
+	block := mineBlockAt(chain, timestamp+1, bits-1)
+	assert.True(t, errors.Is(chain.ValidateBlock(block), blockchain.ErrBadDifficulty))
+
+	// a block mined 1000 seconds after the previous one makes the difficulty go down
+	mine(0)
+	mine(1000)
+	bits, err = chain.NextDifficulty()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, startBits, bits)
+}
@@ -0,0 +1,257 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"jotacoin/pkg/blockchain"
+	"jotacoin/pkg/database"
+	"jotacoin/pkg/utils"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionSerialization(t *testing.T) {
+	tx := &blockchain.Transaction{
+		Version: blockchain.TxVersion,
+		Inputs: []blockchain.TxInput{{
+			PrevTxHash: bytes.Repeat([]byte{1}, 32), OutIdx: 0, Signature: []byte{2}, PubKey: []byte{3},
+		}},
+		Outputs: []blockchain.TxOutput{{Value: 5, PubKeyHash: bytes.Repeat([]byte{4}, 20)}},
+	}
+
+	// the format is part of the consensus, so it can't change
+	expected := "00000001" + "00000001" +
+		"00000020" + strings.Repeat("01", 32) + "00000000" + "0000000102" + "0000000103" + "00000000" +
+		"00000001" + "0000000000000005" + "00000014" + strings.Repeat("04", 20) + "00000000" +
+		"00000000"
+	assert.Equal(t, expected, hex.EncodeToString(tx.Serialize()))
+
+	hash, err := tx.Hash()
+	assert.Equal(t, nil, err)
+	expectedHash := sha256.Sum256(tx.Serialize())
+	assert.Equal(t, expectedHash[:], hash)
+
+	tx.HashID = hash
+	decoded, err := blockchain.DeserializeTransaction(tx.Serialize())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, tx, decoded)
+
+	// truncated data, trailing data and unknown versions are rejected
+	_, err = blockchain.DeserializeTransaction(tx.Serialize()[:40])
+	assert.ErrorIs(t, err, blockchain.ErrMalformedData)
+	_, err = blockchain.DeserializeTransaction(append(tx.Serialize(), 0))
+	assert.ErrorIs(t, err, blockchain.ErrMalformedData)
+	_, err = blockchain.DeserializeTransaction(append([]byte{0, 0, 0, 2}, tx.Serialize()[4:]...))
+	assert.ErrorIs(t, err, blockchain.ErrMalformedData)
+}
+
+func TestBlockSerialization(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+
+	block := mineBlock(chain, []*blockchain.Transaction{newCoinbase(), newSignedTx(chain)})
+	decoded, err := blockchain.DeserializeBlock(block.Serialize())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, block.Serialize(), decoded.Serialize())
+	assert.Equal(t, block.Hash, decoded.Hash)
+	for i, tx := range block.Transactions {
+		assert.Equal(t, tx.HashID, decoded.Transactions[i].HashID)
+	}
+
+	// gob, used by the network messages, encodes the block with the same serialization
+	serializedBlock, err := utils.Serialize(block)
+	assert.Equal(t, nil, err)
+	assert.True(t, bytes.Contains(serializedBlock, block.Serialize()))
+
+	genesis, err := chain.BlockAt(0)
+	assert.Equal(t, nil, err)
+	assert.True(t, genesis.IsGenesis())
+}
+
+// legacyTx and legacyBlock have the fields of Transaction and Block before the canonical
+// serialization, so gob encodes them as they were stored
+type legacyTx struct {
+	HashID  []byte
+	Inputs  []blockchain.TxInput
+	Outputs []blockchain.TxOutput
+}
+
+type legacyBlock struct {
+	Header       blockchain.BlockHeader
+	Hash         []byte
+	Transactions []*legacyTx
+}
+
+func TestMigrateLegacyDB(t *testing.T) {
+	chain := newTestChain()
+	chain.AddBlock([]*blockchain.Transaction{newCoinbase(), newSignedTx(chain)})
+	balance1, balance2 := balances(chain)
+	store := chain.DB
+
+	// store the blocks and a mempool entry as they were stored with gob
+	var hashes [][]byte
+	iter := chain.Iterator()
+	for {
+		block, err := iter.Next()
+		assert.Equal(t, nil, err)
+		legacy := legacyBlock{block.Header, block.Hash, nil}
+		for _, tx := range block.Transactions {
+			legacy.Transactions = append(legacy.Transactions, &legacyTx{tx.HashID, tx.Inputs, tx.Outputs})
+		}
+		serializedBlock, err := utils.Serialize(legacy)
+		assert.Equal(t, nil, err)
+		hashes = append(hashes, block.Hash)
+
+		store.Update(func(txn database.Txn) error {
+			return txn.Set(block.Hash, serializedBlock)
+		})
+		if block.IsGenesis() {
+			break
+		}
+	}
+	mempoolTx := newSignedTx(chain)
+	serializedEntry, err := utils.Serialize(struct {
+		Tx      *legacyTx
+		Fee     int
+		Size    int
+		AddedAt int64
+	}{&legacyTx{mempoolTx.HashID, mempoolTx.Inputs, mempoolTx.Outputs}, 0, 100, 0})
+	assert.Equal(t, nil, err)
+	store.Update(func(txn database.Txn) error {
+		txn.Set(append([]byte("mempool-"), mempoolTx.HashID...), serializedEntry)
+		return txn.Delete([]byte("dbVersion"))
+	})
+
+	migrated, err := blockchain.OpenBlockchainWithStore(store)
+	assert.Equal(t, nil, err)
+	defer migrated.DB.Close()
+
+	for _, hash := range hashes {
+		block, err := migrated.GetBlock(hash)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, hash, block.Hash)
+		assert.Equal(t, block.Header.MerkleRoot, block.HashTransactions())
+		for _, tx := range block.Transactions {
+			assert.Equal(t, blockchain.LegacyTxVersion, tx.Version)
+		}
+	}
+	migratedBalance1, migratedBalance2 := balances(migrated)
+	assert.Equal(t, balance1, migratedBalance1)
+	assert.Equal(t, balance2, migratedBalance2)
+
+	mempool, err := blockchain.NewMempool(migrated)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, mempool.Count())
+
+	// the migrated outputs can be spent by new transactions, but the legacy transactions
+	// aren't accepted in new blocks
+	tx := newSignedTx(migrated)
+	assert.Equal(t, nil, migrated.AddBlock([]*blockchain.Transaction{newCoinbase(), tx}))
+	_, newBalance2 := balances(migrated)
+	assert.Equal(t, balance2+5, newBalance2)
+
+	legacy := newSignedTx(migrated)
+	legacy.Version = blockchain.LegacyTxVersion
+	_, err = migrated.ValidateTransaction(legacy)
+	assert.ErrorIs(t, err, blockchain.ErrBadTxVersion)
+}
+
+// preHeaderBlock has the fields of Block before it had a header
+type preHeaderBlock struct {
+	Hash         []byte
+	Transactions []*legacyTx
+	PrevHash     []byte
+	Nonce        int
+}
+
+// storeLegacyBlocks stores again the blocks of the chain encoded with gob, as returned by
+// encode, and removes the version of the database. It returns the stored values by hash
+func storeLegacyBlocks(chain *blockchain.Blockchain, encode func(b *blockchain.Block, txs []*legacyTx) any) map[string][]byte {
+	stored := make(map[string][]byte)
+	iter := chain.Iterator()
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			panic(err)
+		}
+		var txs []*legacyTx
+		for _, tx := range block.Transactions {
+			txs = append(txs, &legacyTx{tx.HashID, tx.Inputs, tx.Outputs})
+		}
+		serializedBlock, err := utils.Serialize(encode(block, txs))
+		if err != nil {
+			panic(err)
+		}
+		stored[string(block.Hash)] = serializedBlock
+		if block.IsGenesis() {
+			break
+		}
+	}
+
+	err := chain.DB.Update(func(txn database.Txn) error {
+		for hash, serializedBlock := range stored {
+			if err := txn.Set([]byte(hash), serializedBlock); err != nil {
+				return err
+			}
+		}
+		return txn.Delete([]byte("dbVersion"))
+	})
+	if err != nil {
+		panic(err)
+	}
+	return stored
+}
+
+func TestMigratePreHeaderDB(t *testing.T) {
+	path := t.TempDir()
+	chain, err := blockchain.NewBlockchainAt(path, address1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, chain.AddBlock([]*blockchain.Transaction{newCoinbase(), newSignedTx(chain)}))
+
+	// the blocks without header are refused and left as they were
+	stored := storeLegacyBlocks(chain, func(b *blockchain.Block, txs []*legacyTx) any {
+		return preHeaderBlock{b.Hash, txs, b.Header.PrevHash, int(b.Header.Nonce) + 1}
+	})
+	chain.DB.Close()
+	_, err = blockchain.OpenBlockchainWithStore(database.ConnectDB(path))
+	assert.ErrorIs(t, err, blockchain.ErrPreHeaderDB)
+
+	store := database.ConnectDB(path)
+	defer store.Close()
+	err = store.View(func(txn database.Txn) error {
+		for hash, serializedBlock := range stored {
+			val, err := txn.Get([]byte(hash))
+			assert.Equal(t, nil, err)
+			assert.Equal(t, serializedBlock, val)
+		}
+		_, err := txn.Get([]byte("dbVersion"))
+		assert.Equal(t, database.ErrKeyNotFound, err)
+		return nil
+	})
+	assert.Equal(t, nil, err)
+
+	// the same happens if only some of the blocks don't have a header
+	chain = newTestChain()
+	storeLegacyBlocks(chain, func(b *blockchain.Block, txs []*legacyTx) any {
+		if b.IsGenesis() {
+			return preHeaderBlock{b.Hash, txs, nil, 1}
+		}
+		return legacyBlock{b.Header, b.Hash, txs}
+	})
+	_, err = blockchain.OpenBlockchainWithStore(chain.DB)
+	assert.ErrorIs(t, err, blockchain.ErrPreHeaderDB)
+
+	// and a block whose header is zero isn't migrated
+	chain = newTestChain()
+	storeLegacyBlocks(chain, func(b *blockchain.Block, txs []*legacyTx) any {
+		if b.IsGenesis() {
+			return legacyBlock{blockchain.BlockHeader{}, b.Hash, txs}
+		}
+		return legacyBlock{b.Header, b.Hash, txs}
+	})
+	_, err = blockchain.OpenBlockchainWithStore(chain.DB)
+	assert.ErrorContains(t, err, "no header")
+}
@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"jotacoin/pkg/api"
+	"jotacoin/pkg/blockchain"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// explorerGet gets the page and returns its status, its body and the path of the final URL
+func explorerGet(url string) (int, string, string) {
+	resp, err := http.Get(url)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		panic(err)
+	}
+	return resp.StatusCode, string(body), resp.Request.URL.Path
+}
+
+func TestExplorer(t *testing.T) {
+	chain, err := blockchain.NewBlockchainAt(t.TempDir(), address1)
+	if err != nil {
+		panic(err)
+	}
+	defer chain.DB.Close()
+	mempool, err := blockchain.NewMempool(chain)
+	assert.Equal(t, nil, err)
+	server := httptest.NewServer(api.NewExplorer(chain, mempool, nil))
+	defer server.Close()
+
+	genesisHash := hex.EncodeToString(chain.LastHash)
+	tx, err := blockchain.NewTransaction(address1, address2, 10, blockchain.TxOptions{Fee: 1}, chain)
+	assert.Equal(t, nil, err)
+	_, err = mempool.Add(tx)
+	assert.Equal(t, nil, err)
+	block, err := mempool.BlockTemplate(address1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, blockchain.NewMiner(0).Mine(context.Background(), block))
+	assert.Equal(t, nil, chain.AcceptBlock(block))
+	blockHash := hex.EncodeToString(block.Hash)
+	txHash := hex.EncodeToString(tx.HashID)
+
+	status, body, _ := explorerGet(server.URL + "/")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `href="/block/`+blockHash+`"`)
+	assert.Contains(t, body, `href="/block/`+genesisHash+`"`)
+
+	status, body, _ = explorerGet(server.URL + "/block/" + blockHash)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `href="/block/`+genesisHash+`"`)
+	assert.Contains(t, body, `href="/tx/`+txHash+`"`)
+
+	// the inputs are decoded into the address that spends them
+	status, body, _ = explorerGet(server.URL + "/tx/" + txHash)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `href="/address/`+address1+`"`)
+	assert.Contains(t, body, `href="/address/`+address2+`"`)
+
+	status, body, _ = explorerGet(server.URL + "/address/" + address2)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "<td>10</td>")
+	assert.Contains(t, body, "+10")
+
+	// the search redirects to the page of the result
+	_, _, path := explorerGet(server.URL + "/search?q=1")
+	assert.Equal(t, "/block/"+blockHash, path)
+	_, _, path = explorerGet(server.URL + "/search?q=" + txHash)
+	assert.Equal(t, "/tx/"+txHash, path)
+	_, _, path = explorerGet(server.URL + "/search?q=" + address1)
+	assert.Equal(t, "/address/"+address1, path)
+
+	status, _, _ = explorerGet(server.URL + "/search?q=5")
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _, _ = explorerGet(server.URL + "/block/zz")
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _, _ = explorerGet(server.URL + "/tx/00")
+	assert.Equal(t, http.StatusNotFound, status)
+}
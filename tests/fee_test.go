@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"jotacoin/pkg/blockchain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionFee(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+
+	tx, err := blockchain.NewTransaction(address1, address2, 5, blockchain.TxOptions{Fee: 7}, chain)
+	assert.Equal(t, nil, err)
+	fee, err := chain.ValidateTransaction(tx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 7, fee)
+
+	tx, err = blockchain.NewTransaction(address1, address2, 5, blockchain.TxOptions{FeeRate: 10}, chain)
+	assert.Equal(t, nil, err)
+	fee, err = chain.ValidateTransaction(tx)
+	assert.Equal(t, nil, err)
+	size, err := tx.Size()
+	assert.Equal(t, nil, err)
+	assert.GreaterOrEqual(t, fee*1000, size*10)
+}
+
+func TestBlockCollectsFees(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+
+	mempool, err := blockchain.NewMempool(chain)
+	assert.Equal(t, nil, err)
+
+	lowFee, err := blockchain.NewTransaction(address1, address2, 1, blockchain.TxOptions{Fee: 1}, chain)
+	assert.Equal(t, nil, err)
+	fee, err := mempool.Add(lowFee)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, fee)
+	highFee, err := blockchain.NewTransaction(address1, address2, 1, blockchain.TxOptions{Fee: 20}, chain)
+	assert.Equal(t, nil, err)
+	fee, err = mempool.Add(highFee)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 20, fee)
+
+	entries := mempool.Entries()
+	assert.Equal(t, highFee.HashID, entries[0].Tx.HashID)
+	assert.Equal(t, lowFee.HashID, entries[1].Tx.HashID)
+
+	// the coinbase can't claim more than the reward plus the fees
+	txs, fees := mempool.SelectTransactions(blockchain.MaxBlockTxsSize)
+	assert.Equal(t, 21, fees)
+	greedy, err := blockchain.NewCoinbaseTx(address1, "", blockchain.InitialSubsidy+fees+1)
+	assert.Equal(t, nil, err)
+	block, err := chain.MineBlock(append([]*blockchain.Transaction{greedy}, txs...))
+	assert.Equal(t, nil, err)
+	assert.True(t, errors.Is(chain.ValidateBlock(block), blockchain.ErrBadCoinbase))
+
+	block, err = mempool.BlockTemplate(address1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, blockchain.InitialSubsidy+21, block.Transactions[0].Outputs[0].Value)
+	assert.Equal(t, nil, blockchain.NewMiner(0).Mine(context.Background(), block))
+	assert.Equal(t, nil, chain.AcceptBlock(block))
+}
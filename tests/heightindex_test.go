@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"jotacoin/pkg/blockchain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// forwardHashes returns the hashes of the main chain from the height passed as argument
+func forwardHashes(chain *blockchain.Blockchain, height int) [][]byte {
+	var hashes [][]byte
+	iter := chain.ForwardIterator(height)
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			panic(err)
+		}
+		if block == nil {
+			return hashes
+		}
+		hashes = append(hashes, block.Hash)
+	}
+}
+
+func TestHeightIndex(t *testing.T) {
+	pathA, pathB := t.TempDir(), t.TempDir()
+	chainA, err := blockchain.NewBlockchainAt(pathA, address1)
+	if err != nil {
+		panic(err)
+	}
+	genesisHash := chainA.LastHash
+	chainA.DB.Close()
+	copyDir(pathA, pathB)
+	chainA, err = blockchain.ContinueBlockchainAt(pathA)
+	assert.Equal(t, nil, err)
+	chainB, err := blockchain.ContinueBlockchainAt(pathB)
+	assert.Equal(t, nil, err)
+	defer chainB.DB.Close()
+
+	var branchA [][]byte
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, nil, chainA.AddBlock([]*blockchain.Transaction{newCoinbase()}))
+		branchA = append(branchA, chainA.LastHash)
+	}
+
+	block, err := chainA.BlockAt(1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, branchA[0], block.Hash)
+	hash, err := chainA.BlockHashAt(2)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, branchA[1], hash)
+	_, err = chainA.BlockHashAt(3)
+	assert.Equal(t, blockchain.ErrBlockNotFound, err)
+	_, err = chainA.BlockAt(-1)
+	assert.Equal(t, blockchain.ErrBlockNotFound, err)
+	assert.Equal(t, [][]byte{genesisHash, branchA[0], branchA[1]}, forwardHashes(chainA, 0))
+	assert.Equal(t, [][]byte{branchA[1]}, forwardHashes(chainA, 2))
+
+	// the index follows the main chain after a reorg
+	branchB := [][]byte{genesisHash}
+	for i := 0; i < 3; i++ {
+		block := mineBlock(chainB, []*blockchain.Transaction{newCoinbase()})
+		assert.Equal(t, nil, chainB.AcceptBlock(block))
+		assert.Equal(t, nil, chainA.AcceptBlock(block))
+		branchB = append(branchB, block.Hash)
+	}
+	assert.Equal(t, branchB, forwardHashes(chainA, 0))
+
+	// a chain stored without the index gets it when it's opened
+	assert.Equal(t, nil, chainA.DB.DropPrefix([]byte("height-")))
+	_, err = chainA.BlockHashAt(0)
+	assert.Equal(t, blockchain.ErrBlockNotFound, err)
+	chainA.DB.Close()
+	chainA, err = blockchain.ContinueBlockchainAt(pathA)
+	assert.Equal(t, nil, err)
+	defer chainA.DB.Close()
+	assert.Equal(t, branchB, forwardHashes(chainA, 0))
+}
@@ -2,7 +2,6 @@ package tests
 
 import (
 	"io/ioutil"
-	"jotacoin/pkg/blockchain"
 	"jotacoin/pkg/database"
 	"jotacoin/pkg/wallet"
 	"log"
@@ -21,7 +20,7 @@ func init() {
 	database.DBFile = "./../dbtest/MANIFEST"
 	wallet.WalletFilePath = "./../dbtestwallets/"
 
-	// sets the addresses for test and creates the blockchain
+	// sets the addresses for test
 	ws := wallet.Wallets{}
 	address1, err = ws.AddWallet()
 	if err != nil {
@@ -35,9 +34,4 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
-	chain, err := blockchain.NewBlockchain(address1)
-	if err != nil {
-		panic(err)
-	}
-	defer chain.DB.Close()
 }
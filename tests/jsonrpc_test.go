@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"jotacoin/pkg/api"
+	"jotacoin/pkg/blockchain"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func rpcPost(url, body string) *http.Response {
+	resp, err := http.Post(url, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		panic(err)
+	}
+	return resp
+}
+
+// rpcCall calls the method and decodes its result into result (if there is no error)
+func rpcCall(url, method string, result any, params ...any) *api.Error {
+	if params == nil {
+		params = []any{}
+	}
+	req, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": 1, "method": method, "params": params})
+	if err != nil {
+		panic(err)
+	}
+	httpResp := rpcPost(url, string(req))
+	defer httpResp.Body.Close()
+
+	var resp api.Response
+	err = json.NewDecoder(httpResp.Body).Decode(&resp)
+	if err != nil {
+		panic(err)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result != nil {
+		err = json.Unmarshal(resp.Result, result)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return nil
+}
+
+func TestJSONRPC(t *testing.T) {
+	chain, err := blockchain.NewBlockchainAt(t.TempDir(), address1)
+	if err != nil {
+		panic(err)
+	}
+	defer chain.DB.Close()
+	mempool, err := blockchain.NewMempool(chain)
+	assert.Equal(t, nil, err)
+	server := httptest.NewServer(api.NewServer(chain, mempool, nil))
+	defer server.Close()
+
+	var info api.ChainInfo
+	assert.Nil(t, rpcCall(server.URL, "getchaininfo", &info))
+	assert.Equal(t, 0, info.Height)
+	assert.Equal(t, hex.EncodeToString(chain.LastHash), info.BestHash)
+	assert.Equal(t, blockchain.InitialDifficulty, info.Bits)
+
+	var count int
+	assert.Nil(t, rpcCall(server.URL, "getblockcount", &count))
+	assert.Equal(t, 0, count)
+	var hash string
+	assert.Nil(t, rpcCall(server.URL, "getblockhash", &hash, 0))
+	assert.Equal(t, info.BestHash, hash)
+	var block api.BlockView
+	assert.Nil(t, rpcCall(server.URL, "getblock", &block, hash))
+	assert.Equal(t, 0, block.Height)
+	assert.Equal(t, 1, block.Confirmations)
+	assert.Equal(t, address1, block.Transactions[0].Outputs[0].Address)
+
+	var balance int
+	assert.Nil(t, rpcCall(server.URL, "getbalance", &balance, address1))
+	assert.Equal(t, blockchain.InitialSubsidy, balance)
+
+	// a transaction sent through the API goes into the mempool until it's mined
+	var sent api.SendResult
+	assert.Nil(t, rpcCall(server.URL, "sendtransaction", &sent, address1, address2, 10, 1))
+	assert.Equal(t, 1, sent.Fee)
+	var mempoolInfo api.MempoolInfo
+	assert.Nil(t, rpcCall(server.URL, "getmempoolinfo", &mempoolInfo))
+	assert.Equal(t, api.MempoolInfo{Size: 1, Bytes: mempool.Size(), Fees: 1}, mempoolInfo)
+	var tx api.TxView
+	assert.Nil(t, rpcCall(server.URL, "gettransaction", &tx, sent.Hash))
+	assert.Equal(t, 0, tx.Confirmations)
+	assert.Equal(t, "ALL", tx.Inputs[0].SigHash)
+
+	newBlock, err := mempool.BlockTemplate(address1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, blockchain.NewMiner(0).Mine(context.Background(), newBlock))
+	assert.Equal(t, nil, chain.AcceptBlock(newBlock))
+	assert.Nil(t, rpcCall(server.URL, "gettransaction", &tx, sent.Hash))
+	assert.Equal(t, 1, tx.Confirmations)
+	assert.Equal(t, hex.EncodeToString(newBlock.Hash), tx.BlockHash)
+	assert.Nil(t, rpcCall(server.URL, "getbalance", &balance, address2))
+	assert.Equal(t, 10, balance)
+	var history api.HistoryPage
+	assert.Nil(t, rpcCall(server.URL, "gethistory", &history, address2))
+	assert.Equal(t, 1, history.Total)
+	assert.Equal(t, sent.Hash, history.Entries[0].TxHash)
+	assert.Equal(t, 10, history.Entries[0].Balance)
+
+	var address string
+	assert.Nil(t, rpcCall(server.URL, "newaddress", &address))
+	var wallets []api.WalletInfo
+	assert.Nil(t, rpcCall(server.URL, "listwallets", &wallets))
+	assert.Contains(t, wallets, api.WalletInfo{Address: address, Balance: 0})
+	assert.Contains(t, wallets, api.WalletInfo{Address: address2, Balance: 10})
+
+	// errors
+	assert.Equal(t, api.CodeMethodNotFound, rpcCall(server.URL, "unknown", nil).Code)
+	assert.Equal(t, api.CodeInvalidParams, rpcCall(server.URL, "getblock", nil, "zz").Code)
+	assert.Equal(t, api.CodeInvalidParams, rpcCall(server.URL, "getbalance", nil).Code)
+	assert.Equal(t, api.CodeNotFound, rpcCall(server.URL, "getblock", nil, "00").Code)
+	assert.Equal(t, api.CodeNotFound, rpcCall(server.URL, "getblockhash", nil, 5).Code)
+	assert.Equal(t, api.CodeRejected, rpcCall(server.URL, "sendtransaction", nil, address2, address1, 1000).Code)
+	assert.Equal(t, api.CodeInvalidParams,
+		rpcCall(server.URL, "sendtransaction", nil, address1, address2, 1, 0, 0, "ANY").Code)
+
+	var resp api.Response
+	httpResp := rpcPost(server.URL, "{")
+	assert.Equal(t, nil, json.NewDecoder(httpResp.Body).Decode(&resp))
+	httpResp.Body.Close()
+	assert.Equal(t, api.CodeParseError, resp.Error.Code)
+
+	// a batch gets a response per request, except for the notifications
+	var responses []api.Response
+	httpResp = rpcPost(server.URL, `[
+		{"jsonrpc": "2.0", "id": 1, "method": "getblockhash", "params": [1]},
+		{"jsonrpc": "2.0", "method": "getchaininfo"},
+		{"jsonrpc": "2.0", "id": 2, "method": "getbalance", "params": []}
+	]`)
+	assert.Equal(t, nil, json.NewDecoder(httpResp.Body).Decode(&responses))
+	httpResp.Body.Close()
+	assert.Equal(t, 2, len(responses))
+	assert.Equal(t, `"`+hex.EncodeToString(newBlock.Hash)+`"`, string(responses[0].Result))
+	assert.Equal(t, api.CodeInvalidParams, responses[1].Error.Code)
+
+	httpResp = rpcPost(server.URL, `{"jsonrpc": "2.0", "method": "getchaininfo"}`)
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, httpResp.StatusCode)
+}
@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"jotacoin/pkg/blockchain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMempool(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+
+	// both are created before the mempool exists, so they spend the same outputs
+	tx1 := newSignedTx(chain)
+	conflicting := newSignedTx(chain)
+
+	mempool, err := blockchain.NewMempool(chain)
+	assert.Equal(t, nil, err)
+	_, err = mempool.Add(tx1)
+	assert.Equal(t, nil, err)
+	_, err = mempool.Add(tx1)
+	assert.True(t, errors.Is(err, blockchain.ErrTxInMempool))
+	_, err = mempool.Add(conflicting)
+	assert.True(t, errors.Is(err, blockchain.ErrMempoolConflict))
+
+	// now the outputs spent by tx1 are skipped
+	tx2 := newSignedTx(chain)
+	_, err = mempool.Add(tx2)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, mempool.Count())
+
+	// the mempool is stored in the database, the reloaded one replaces the old one
+	size := mempool.Size()
+	mempool, err = blockchain.NewMempool(chain)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, mempool.Count())
+	assert.Equal(t, size, mempool.Size())
+
+	block, err := mempool.BlockTemplate(address1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(block.Transactions))
+	assert.Equal(t, nil, blockchain.NewMiner(0).Mine(context.Background(), block))
+	assert.Equal(t, nil, chain.AcceptBlock(block))
+	assert.Equal(t, 0, mempool.Count())
+	assert.Equal(t, 0, mempool.Size())
+}
+
+func TestMempoolFull(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+
+	mempool, err := blockchain.NewMempool(chain)
+	assert.Equal(t, nil, err)
+	_, err = mempool.Add(newSignedTx(chain))
+	assert.Equal(t, nil, err)
+
+	maxMempoolSize := blockchain.MaxMempoolSize
+	blockchain.MaxMempoolSize = mempool.Size() + 1
+	defer func() { blockchain.MaxMempoolSize = maxMempoolSize }()
+
+	// the new transaction doesn't pay a higher fee rate, so nothing is evicted
+	tx := newSignedTx(chain)
+	_, err = mempool.Add(tx)
+	assert.True(t, errors.Is(err, blockchain.ErrMempoolFull))
+	assert.Equal(t, 1, mempool.Count())
+
+	for _, entry := range mempool.Entries() {
+		assert.Equal(t, nil, mempool.Remove(entry.Tx.HashID))
+	}
+	assert.Equal(t, 0, mempool.Count())
+}
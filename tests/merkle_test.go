@@ -0,0 +1,49 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"jotacoin/pkg/blockchain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerkleProof(t *testing.T) {
+	for leavesAmount := 1; leavesAmount <= 9; leavesAmount++ {
+		var leaves [][]byte
+		for i := 0; i < leavesAmount; i++ {
+			leaf := sha256.Sum256([]byte{byte(i)})
+			leaves = append(leaves, leaf[:])
+		}
+
+		tree := blockchain.NewMerkleTree(leaves)
+		for i, leaf := range leaves {
+			proof, err := tree.Proof(i)
+			assert.Equal(t, nil, err)
+			assert.True(t, blockchain.VerifyMerkleProof(tree.Root(), leaf, proof))
+
+			// a proof is only valid for its own leaf
+			other := leaves[(i+1)%len(leaves)]
+			if leavesAmount > 1 {
+				assert.False(t, blockchain.VerifyMerkleProof(tree.Root(), other, proof))
+			}
+		}
+	}
+}
+
+func TestBlockMerkleProof(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+
+	block, err := chain.MineBlock([]*blockchain.Transaction{newCoinbase(), newSignedTx(chain)})
+	assert.Equal(t, nil, err)
+
+	for _, tx := range block.Transactions {
+		proof, err := block.MerkleProof(tx.HashID)
+		assert.Equal(t, nil, err)
+		assert.True(t, blockchain.VerifyMerkleProof(block.Header.MerkleRoot, tx.HashID, proof))
+	}
+
+	_, err = block.MerkleProof([]byte("unknown"))
+	assert.NotEqual(t, nil, err)
+}
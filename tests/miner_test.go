@@ -0,0 +1,46 @@
+package tests
+
+import (
+	"context"
+	"jotacoin/pkg/blockchain"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMinerMine(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+
+	block, err := chain.NewBlockTemplate([]*blockchain.Transaction{newCoinbase()})
+	assert.Equal(t, nil, err)
+
+	miner := blockchain.NewMiner(4)
+	err = miner.Mine(context.Background(), block)
+	assert.Equal(t, nil, err)
+	assert.True(t, blockchain.NewProof(block).IsValid())
+	assert.Equal(t, nil, chain.ValidateBlock(block))
+	assert.Greater(t, miner.Hashes(), uint64(0))
+	assert.Greater(t, miner.HashRate(), float64(0))
+}
+
+func TestMinerCancel(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+
+	block, err := chain.NewBlockTemplate([]*blockchain.Transaction{newCoinbase()})
+	assert.Equal(t, nil, err)
+	// it's too difficult to be mined before the timeout
+	block.Header.Bits = 64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	miner := blockchain.NewMiner(2)
+	err = miner.Mine(ctx, block)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Greater(t, miner.Hashes(), uint64(0))
+}
@@ -0,0 +1,193 @@
+package tests
+
+import (
+	"jotacoin/pkg/blockchain"
+	"jotacoin/pkg/wallet"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultisigAddress(t *testing.T) {
+	w1, w2 := loadWallet(address1), loadWallet(address2)
+	pubKeys := [][]byte{w1.PublicKey, w2.PublicKey}
+
+	for _, m := range []int{0, 3} {
+		_, err := blockchain.MultisigScript(m, pubKeys)
+		assert.ErrorIs(t, err, blockchain.ErrBadScript)
+	}
+
+	redeemScript, err := blockchain.MultisigScript(2, pubKeys)
+	assert.Equal(t, nil, err)
+	address, err := blockchain.MultisigAddress(redeemScript)
+	assert.Equal(t, nil, err)
+	assert.True(t, wallet.IsScriptHashAddress(address))
+	assert.False(t, wallet.IsScriptHashAddress(address1))
+
+	// the outputs paid to the address are locked to the hash of the redeem script
+	out, err := blockchain.NewTxOutput(5, address)
+	assert.Equal(t, nil, err)
+	assert.True(t, out.IsP2SH())
+	assert.Equal(t, address, out.Address())
+	assert.Equal(t, addressHash(address), out.AddressHash())
+
+	_, err = blockchain.MultisigAddress(blockchain.P2PKHScript(addressHash(address1)))
+	assert.ErrorIs(t, err, blockchain.ErrBadScript)
+}
+
+func TestMultisigSpend(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+	w1, w2 := loadWallet(address1), loadWallet(address2)
+	w3, err := wallet.NewWallet()
+	assert.Equal(t, nil, err)
+
+	// 2 of 3 multisig funded by address1
+	redeemScript, err := blockchain.MultisigScript(2, [][]byte{w1.PublicKey, w2.PublicKey, w3.PublicKey})
+	assert.Equal(t, nil, err)
+	address, err := blockchain.MultisigAddress(redeemScript)
+	assert.Equal(t, nil, err)
+	funding, err := blockchain.NewTransaction(address1, address, 50, blockchain.TxOptions{}, chain)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, chain.AddBlock([]*blockchain.Transaction{newCoinbase(), funding}))
+	assert.Equal(t, 50, chain.GetBalance(address))
+
+	opts := blockchain.TxOptions{Fee: 1}
+	tx, err := blockchain.NewMultisigTransaction(redeemScript, address2, 20, opts, chain)
+	assert.Equal(t, nil, err)
+	prevOuts := prevOutputs(chain, tx)
+	assert.False(t, tx.Verify(prevOuts))
+
+	// the co-signers can sign in any order, each one of them once
+	signed, err := tx.SignMultisig(w3, prevOuts, blockchain.SigHashAll)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, signed)
+	signatures, required, err := tx.Inputs[0].MultisigStatus()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, signatures)
+	assert.Equal(t, 2, required)
+	_, err = chain.ValidateTransaction(tx)
+	assert.ErrorIs(t, err, blockchain.ErrBadSignature)
+
+	signed, err = tx.SignMultisig(w3, prevOuts, blockchain.SigHashAll)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, signed)
+	other, err := wallet.NewWallet()
+	assert.Equal(t, nil, err)
+	signed, err = tx.SignMultisig(other, prevOuts, blockchain.SigHashAll)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, signed)
+
+	// the partially signed transaction is passed serialized to the next co-signer
+	tx, err = blockchain.DeserializeTransaction(tx.Serialize())
+	assert.Equal(t, nil, err)
+	signed, err = tx.SignMultisig(w1, prevOuts, blockchain.SigHashAll)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, signed)
+	assert.True(t, tx.Verify(prevOuts))
+
+	// once complete, more signatures aren't added
+	signed, err = tx.SignMultisig(w2, prevOuts, blockchain.SigHashAll)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, signed)
+
+	fee, err := chain.ValidateTransaction(tx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, fee)
+	assert.Equal(t, nil, chain.AddBlock([]*blockchain.Transaction{newCoinbase(), tx}))
+	_, balance2 := balances(chain)
+	assert.Equal(t, 20, balance2)
+	assert.Equal(t, 29, chain.GetBalance(address))
+}
+
+func TestMultisigSize(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+
+	wallets := []*wallet.Wallet{loadWallet(address1), loadWallet(address2)}
+	for len(wallets) < 8 {
+		w, err := wallet.NewWallet()
+		assert.Equal(t, nil, err)
+		wallets = append(wallets, w)
+	}
+	var pubKeys [][]byte
+	for _, w := range wallets {
+		pubKeys = append(pubKeys, w.PublicKey)
+	}
+
+	// the redeem script of 8 keys can't be pushed, so it's rejected
+	_, err := blockchain.MultisigScript(1, pubKeys)
+	assert.ErrorIs(t, err, blockchain.ErrRedeemScriptSize)
+	b := &blockchain.ScriptBuilder{}
+	b.AddInt(1)
+	for _, pubKey := range pubKeys {
+		b.AddData(pubKey)
+	}
+	_, err = blockchain.MultisigAddress(b.AddInt(8).AddOp(blockchain.OpCheckMultiSig).Script())
+	assert.ErrorIs(t, err, blockchain.ErrRedeemScriptSize)
+
+	// a 7 of 7 multisig can be spent
+	redeemScript, err := blockchain.MultisigScript(7, pubKeys[:7])
+	assert.Equal(t, nil, err)
+	address, err := blockchain.MultisigAddress(redeemScript)
+	assert.Equal(t, nil, err)
+	funding, err := blockchain.NewTransaction(address1, address, 50, blockchain.TxOptions{}, chain)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, chain.AddBlock([]*blockchain.Transaction{newCoinbase(), funding}))
+
+	tx, err := blockchain.NewMultisigTransaction(redeemScript, address2, 20, blockchain.TxOptions{Fee: 1}, chain)
+	assert.Equal(t, nil, err)
+	prevOuts := prevOutputs(chain, tx)
+	for _, w := range wallets[:7] {
+		signed, err := tx.SignMultisig(w, prevOuts, blockchain.SigHashAll)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 1, signed)
+	}
+	_, err = chain.ValidateTransaction(tx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, chain.AddBlock([]*blockchain.Transaction{newCoinbase(), tx}))
+	assert.Equal(t, 29, chain.GetBalance(address))
+}
+
+func TestScriptHashCollision(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+	w1, w2 := loadWallet(address1), loadWallet(address2)
+
+	// outputs locked to a script hash equal to the public key hash of address2 aren't its
+	// coins, so they don't block it from spending
+	pubKeyHash2 := addressHash(address2)
+	collision := wallet.ScriptHashToAddress(pubKeyHash2)
+	dust, err := blockchain.NewTransaction(address1, collision, 5, blockchain.TxOptions{}, chain)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, chain.AddBlock([]*blockchain.Transaction{newCoinbase(), dust}))
+	funding, err := blockchain.NewTransaction(address1, address2, 10, blockchain.TxOptions{}, chain)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, chain.AddBlock([]*blockchain.Transaction{newCoinbase(), funding}))
+
+	assert.Equal(t, 10, chain.GetBalance(address2))
+	assert.Equal(t, 5, chain.GetBalance(collision))
+	history, _, err := chain.AddressHistory(address2, 0, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(history))
+	tx, err := blockchain.NewTransaction(address2, address1, 10, blockchain.TxOptions{}, chain)
+	assert.Equal(t, nil, err)
+	_, err = chain.ValidateTransaction(tx)
+	assert.Equal(t, nil, err)
+
+	// and the other way around, for the multisig addresses
+	redeemScript, err := blockchain.MultisigScript(1, [][]byte{w1.PublicKey, w2.PublicKey})
+	assert.Equal(t, nil, err)
+	address, err := blockchain.MultisigAddress(redeemScript)
+	assert.Equal(t, nil, err)
+	dust, err = blockchain.NewTransaction(address1, wallet.PubKeyHashToAddress(addressHash(address)), 5,
+		blockchain.TxOptions{}, chain)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, chain.AddBlock([]*blockchain.Transaction{newCoinbase(), dust}))
+
+	assert.Equal(t, 0, chain.GetBalance(address))
+	_, err = blockchain.NewMultisigTransaction(redeemScript, address2, 1, blockchain.TxOptions{}, chain)
+	assert.ErrorContains(t, err, "not enough balance")
+	_, err = blockchain.CreatePSBT(address, address2, 1, redeemScript, blockchain.TxOptions{}, chain)
+	assert.ErrorContains(t, err, "not enough balance")
+}
@@ -0,0 +1,174 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"jotacoin/pkg/blockchain"
+	"jotacoin/pkg/network"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// copyDir copies the files of the database folder, so two nodes can share the genesis
+func copyDir(src, dst string) {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		panic(err)
+	}
+	for _, entry := range entries {
+		in, err := os.Open(filepath.Join(src, entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		out, err := os.Create(filepath.Join(dst, entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		_, err = io.Copy(out, in)
+		if err != nil {
+			panic(err)
+		}
+		in.Close()
+		out.Close()
+	}
+}
+
+// startNode starts a node listening at a random port of localhost
+func startNode(path string) *network.Server {
+	chain, err := blockchain.ContinueBlockchainAt(path)
+	if err != nil {
+		panic(err)
+	}
+	mempool, err := blockchain.NewMempool(chain)
+	if err != nil {
+		panic(err)
+	}
+
+	server := network.NewServer("localhost:0", chain, mempool)
+	err = server.Start()
+	if err != nil {
+		panic(err)
+	}
+	return server
+}
+
+func stopNode(server *network.Server) {
+	server.Close()
+	server.Chain.DB.Close()
+}
+
+func TestNetwork(t *testing.T) {
+	pathA, pathB := t.TempDir(), t.TempDir()
+	chain, err := blockchain.NewBlockchainAt(pathA, address1)
+	if err != nil {
+		panic(err)
+	}
+	chain.DB.Close()
+	copyDir(pathA, pathB)
+
+	// node A gets ahead of node B before they connect
+	nodeA := startNode(pathA)
+	defer stopNode(nodeA)
+	for i := 0; i < 3; i++ {
+		err = nodeA.SubmitBlock(mineBlock(nodeA.Chain, []*blockchain.Transaction{newCoinbase()}))
+		assert.Equal(t, nil, err)
+	}
+
+	nodeB := startNode(pathB)
+	defer stopNode(nodeB)
+	assert.Equal(t, nil, nodeB.Connect(nodeA.Addr))
+	assert.Eventually(t, func() bool {
+		return bytes.Equal(nodeA.LastHash(), nodeB.LastHash())
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, len(nodeA.Peers()))
+	assert.Equal(t, 1, len(nodeB.Peers()))
+
+	// a transaction sent to B is relayed to A, which mines it and relays the block to B
+	nodeA.StartMining(address2, 1)
+	tx, err := blockchain.NewTransaction(address1, address2, 5, blockchain.TxOptions{Fee: 1}, nodeB.Chain)
+	assert.Equal(t, nil, err)
+	_, err = nodeB.SubmitTransaction(tx)
+	assert.Equal(t, nil, err)
+	assert.Eventually(t, func() bool {
+		height, err := nodeB.Height()
+		return err == nil && height == 4 && nodeB.Mempool.Count() == 0
+	}, 10*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, nodeA.LastHash(), nodeB.LastHash())
+	block, err := nodeB.Chain.GetBlock(nodeB.LastHash())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(block.Transactions))
+	assert.Equal(t, tx.HashID, block.Transactions[1].HashID)
+}
+
+// writeRawMessage writes a message with the wire format of the nodes, whose payloads are
+// matched by field name when decoded
+func writeRawMessage(conn net.Conn, command string, payload any) error {
+	var data bytes.Buffer
+	err := gob.NewEncoder(&data).Encode(payload)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, 16)
+	copy(header, command)
+	binary.BigEndian.PutUint32(header[12:], uint32(data.Len()))
+	_, err = conn.Write(append(header, data.Bytes()...))
+	return err
+}
+
+// dialNode opens a connection to the node and completes the handshake
+func dialNode(t *testing.T, server *network.Server) net.Conn {
+	conn, err := net.Dial("tcp", server.Addr)
+	assert.Equal(t, nil, err)
+	version := struct {
+		Version    int
+		BestHeight int
+		AddrFrom   string
+	}{network.ProtocolVersion, 0, ""}
+	assert.Equal(t, nil, writeRawMessage(conn, "version", version))
+	assert.Equal(t, nil, writeRawMessage(conn, "verack", struct{ Version int }{network.ProtocolVersion}))
+	assert.Eventually(t, func() bool {
+		return len(server.Peers()) == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	return conn
+}
+
+func TestNetworkEmptyPayload(t *testing.T) {
+	path := t.TempDir()
+	chain, err := blockchain.NewBlockchainAt(path, address1)
+	if err != nil {
+		panic(err)
+	}
+	chain.DB.Close()
+	node := startNode(path)
+	defer stopNode(node)
+
+	// the peers that send a block or a tx message without its content are disconnected
+	payloads := map[string]any{
+		"block": struct{ Block *blockchain.Block }{},
+		"tx":    struct{ Tx *blockchain.Transaction }{},
+	}
+	for command, payload := range payloads {
+		conn := dialNode(t, node)
+		assert.Equal(t, nil, writeRawMessage(conn, command, payload))
+		assert.Eventually(t, func() bool {
+			return len(node.Peers()) == 0
+		}, 10*time.Second, 10*time.Millisecond)
+		conn.Close()
+	}
+
+	// and the node keeps working
+	err = node.SubmitBlock(mineBlock(node.Chain, []*blockchain.Transaction{newCoinbase()}))
+	assert.Equal(t, nil, err)
+	height, err := node.Height()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, height)
+	dialNode(t, node).Close()
+}
@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"encoding/hex"
+	"jotacoin/pkg/api"
+	"jotacoin/pkg/blockchain"
+	"jotacoin/pkg/wallet"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// roundTrip serializes and decodes the PSBT, as it's passed between the signers
+func roundTrip(t *testing.T, psbt *blockchain.PSBT) *blockchain.PSBT {
+	decoded, err := blockchain.DeserializePSBT(psbt.Serialize())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, psbt.Serialize(), decoded.Serialize())
+	return decoded
+}
+
+func TestPSBT(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+
+	opts := blockchain.TxOptions{FeeRate: 10}
+	psbt, err := blockchain.CreatePSBT(address1, address2, 5, nil, opts, chain)
+	assert.Equal(t, nil, err)
+	psbt = roundTrip(t, psbt)
+	_, err = psbt.Finalize()
+	assert.ErrorIs(t, err, blockchain.ErrPSBTIncomplete)
+
+	// the signer only needs the PSBT and its wallet
+	signed, err := psbt.Sign(loadWallet(address2), blockchain.SigHashAll)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, signed)
+	signed, err = psbt.Sign(loadWallet(address1), blockchain.SigHashAll)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(psbt.Inputs), signed)
+	signatures, required := psbt.InputStatus(0)
+	assert.Equal(t, 1, signatures)
+	assert.Equal(t, 1, required)
+
+	tx, err := roundTrip(t, psbt).Finalize()
+	assert.Equal(t, nil, err)
+	fee, err := chain.ValidateTransaction(tx)
+	assert.Equal(t, nil, err)
+	size, err := tx.Size()
+	assert.Equal(t, nil, err)
+	assert.True(t, fee*1000 >= opts.FeeRate*size)
+
+	// the malformed PSBTs are rejected
+	_, err = blockchain.DeserializePSBT(psbt.Serialize()[1:])
+	assert.ErrorIs(t, err, blockchain.ErrMalformedData)
+	_, err = blockchain.DeserializePSBT(append(psbt.Serialize(), 0))
+	assert.ErrorIs(t, err, blockchain.ErrMalformedData)
+}
+
+func TestPSBTMultisig(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+	w1, w2 := loadWallet(address1), loadWallet(address2)
+	w3, err := wallet.NewWallet()
+	assert.Equal(t, nil, err)
+
+	redeemScript, err := blockchain.MultisigScript(2, [][]byte{w1.PublicKey, w2.PublicKey, w3.PublicKey})
+	assert.Equal(t, nil, err)
+	address, err := blockchain.MultisigAddress(redeemScript)
+	assert.Equal(t, nil, err)
+	funding, err := blockchain.NewTransaction(address1, address, 50, blockchain.TxOptions{}, chain)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, chain.AddBlock([]*blockchain.Transaction{newCoinbase(), funding}))
+
+	otherScript, err := blockchain.MultisigScript(1, [][]byte{w1.PublicKey})
+	assert.Equal(t, nil, err)
+	_, err = blockchain.CreatePSBT(address, address2, 20, otherScript, blockchain.TxOptions{}, chain)
+	assert.NotEqual(t, nil, err)
+
+	psbt, err := blockchain.CreatePSBT(address, address2, 20, redeemScript, blockchain.TxOptions{Fee: 1}, chain)
+	assert.Equal(t, nil, err)
+
+	// two co-signers sign their own copies, which are combined
+	copy1, copy3 := roundTrip(t, psbt), roundTrip(t, psbt)
+	signed, err := copy1.Sign(w1, blockchain.SigHashAll)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, signed)
+	signed, err = copy3.Sign(w3, blockchain.SigHashAll)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, signed)
+	_, err = copy1.Finalize()
+	assert.ErrorIs(t, err, blockchain.ErrPSBTIncomplete)
+
+	assert.Equal(t, nil, copy1.Combine(copy3))
+	signatures, required := copy1.InputStatus(0)
+	assert.Equal(t, 2, signatures)
+	assert.Equal(t, 2, required)
+	other, err := blockchain.CreatePSBT(address1, address2, 5, nil, blockchain.TxOptions{}, chain)
+	assert.Equal(t, nil, err)
+	assert.ErrorIs(t, copy1.Combine(other), blockchain.ErrPSBTMismatch)
+
+	// the redeem script must be the one whose hash locks the spent output
+	tampered := roundTrip(t, copy1)
+	tampered.Inputs[0].RedeemScript = otherScript
+	_, err = tampered.Sign(w1, blockchain.SigHashAll)
+	assert.ErrorIs(t, err, blockchain.ErrPSBTRedeemScript)
+	assert.ErrorContains(t, err, "input 0")
+	_, err = tampered.Finalize()
+	assert.ErrorIs(t, err, blockchain.ErrPSBTRedeemScript)
+
+	tx, err := copy1.Finalize()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, chain.AddBlock([]*blockchain.Transaction{newCoinbase(), tx}))
+	_, balance2 := balances(chain)
+	assert.Equal(t, 20, balance2)
+	assert.Equal(t, 29, chain.GetBalance(address))
+}
+
+func TestSendRawTransaction(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+	mempool, err := blockchain.NewMempool(chain)
+	assert.Equal(t, nil, err)
+	server := httptest.NewServer(api.NewServer(chain, mempool, nil))
+	defer server.Close()
+
+	psbt, err := blockchain.CreatePSBT(address1, address2, 5, nil, blockchain.TxOptions{Fee: 2}, chain)
+	assert.Equal(t, nil, err)
+	_, err = psbt.Sign(loadWallet(address1), blockchain.SigHashAll)
+	assert.Equal(t, nil, err)
+	tx, err := psbt.Finalize()
+	assert.Equal(t, nil, err)
+
+	var sent api.SendResult
+	assert.Nil(t, rpcCall(server.URL, "sendrawtransaction", &sent, hex.EncodeToString(tx.Serialize())))
+	assert.Equal(t, hex.EncodeToString(tx.HashID), sent.Hash)
+	assert.Equal(t, 2, sent.Fee)
+	assert.Equal(t, 1, mempool.Count())
+
+	assert.Equal(t, api.CodeRejected,
+		rpcCall(server.URL, "sendrawtransaction", nil, hex.EncodeToString(tx.Serialize())).Code)
+	assert.Equal(t, api.CodeInvalidParams, rpcCall(server.URL, "sendrawtransaction", nil, "00").Code)
+}
@@ -0,0 +1,172 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"jotacoin/pkg/blockchain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newForks creates two chains with the same genesis in temporary folders
+func newForks(t *testing.T) (*blockchain.Blockchain, *blockchain.Blockchain) {
+	pathA, pathB := t.TempDir(), t.TempDir()
+	chain, err := blockchain.NewBlockchainAt(pathA, address1)
+	if err != nil {
+		panic(err)
+	}
+	chain.DB.Close()
+	copyDir(pathA, pathB)
+
+	chainA, err := blockchain.ContinueBlockchainAt(pathA)
+	if err != nil {
+		panic(err)
+	}
+	chainB, err := blockchain.ContinueBlockchainAt(pathB)
+	if err != nil {
+		panic(err)
+	}
+	return chainA, chainB
+}
+
+func balances(chain *blockchain.Blockchain) (int, int) {
+	return chain.GetBalance(address1), chain.GetBalance(address2)
+}
+
+func TestReorg(t *testing.T) {
+	chainA, chainB := newForks(t)
+	defer chainA.DB.Close()
+	defer chainB.DB.Close()
+	mempool, err := blockchain.NewMempool(chainA)
+	assert.Equal(t, nil, err)
+
+	// branch A: a block with a transaction and an empty block
+	tx := newSignedTx(chainA)
+	assert.Equal(t, nil, chainA.AddBlock([]*blockchain.Transaction{newCoinbase(), tx}))
+	assert.Equal(t, nil, chainA.AddBlock([]*blockchain.Transaction{newCoinbase()}))
+	tipA := chainA.LastHash
+	balance1, balance2 := balances(chainA)
+	assert.Equal(t, 295, balance1)
+	assert.Equal(t, 5, balance2)
+
+	// branch B: three empty blocks
+	var branchB []*blockchain.Block
+	for i := 0; i < 3; i++ {
+		block := mineBlock(chainB, []*blockchain.Transaction{newCoinbase()})
+		assert.Equal(t, nil, chainB.AcceptBlock(block))
+		branchB = append(branchB, block)
+	}
+
+	// while branch B doesn't have more work, its blocks are kept as a side branch
+	assert.Equal(t, nil, chainA.AcceptBlock(branchB[0]))
+	assert.Equal(t, nil, chainA.AcceptBlock(branchB[1]))
+	assert.Equal(t, tipA, chainA.LastHash)
+	assert.True(t, chainA.HasBlock(branchB[1].Hash))
+	assert.True(t, errors.Is(chainA.AcceptBlock(branchB[1]), blockchain.ErrKnownBlock))
+
+	// the third block makes branch B the heaviest one
+	assert.Equal(t, nil, chainA.AcceptBlock(branchB[2]))
+	assert.Equal(t, branchB[2].Hash, chainA.LastHash)
+	height, err := chainA.Height()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, height)
+	workA, err := chainA.ChainWork()
+	assert.Equal(t, nil, err)
+	workB, err := chainB.ChainWork()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, workB, workA)
+
+	// the UTXO set was rolled back to the fork point and then forward through branch B,
+	// and the transaction of the disconnected branch is back in the mempool
+	balance1, balance2 = balances(chainA)
+	assert.Equal(t, 400, balance1)
+	assert.Equal(t, 0, balance2)
+	assert.NotEqual(t, (*blockchain.Transaction)(nil), mempool.Get(tx.HashID))
+	assert.Equal(t, nil, chainA.ReindexUTXO())
+	reindexed1, reindexed2 := balances(chainA)
+	assert.Equal(t, balance1, reindexed1)
+	assert.Equal(t, balance2, reindexed2)
+
+	// the transaction can be mined again on top of the new chain
+	block, err := mempool.BlockTemplate(address1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(block.Transactions))
+	assert.Equal(t, nil, blockchain.NewMiner(0).Mine(context.Background(), block))
+	assert.Equal(t, nil, chainA.AcceptBlock(block))
+	assert.Equal(t, 0, mempool.Count())
+	balance1, balance2 = balances(chainA)
+	assert.Equal(t, 495, balance1)
+	assert.Equal(t, 5, balance2)
+}
+
+func TestReorgInvalidBranch(t *testing.T) {
+	chainA, chainB := newForks(t)
+	defer chainA.DB.Close()
+	defer chainB.DB.Close()
+
+	assert.Equal(t, nil, chainA.AddBlock([]*blockchain.Transaction{newCoinbase()}))
+	tipA := chainA.LastHash
+
+	// the second block of branch B pays too much, which can only be detected when the
+	// branch is connected
+	first := mineBlock(chainB, []*blockchain.Transaction{newCoinbase()})
+	assert.Equal(t, nil, chainB.AcceptBlock(first))
+	cbtx, err := blockchain.NewCoinbaseTx(address1, "", blockchain.InitialSubsidy+1)
+	assert.Equal(t, nil, err)
+	invalid := mineBlock(chainB, []*blockchain.Transaction{cbtx})
+
+	assert.Equal(t, nil, chainA.AcceptBlock(first))
+	assert.True(t, errors.Is(chainA.AcceptBlock(invalid), blockchain.ErrBadCoinbase))
+	assert.Equal(t, tipA, chainA.LastHash)
+	assert.False(t, chainA.HasBlock(invalid.Hash))
+	balance1, _ := balances(chainA)
+	assert.Equal(t, 200, balance1)
+}
+
+// mineChild mines a block with only a coinbase on top of the block passed as argument,
+// which doesn't need to be stored
+func mineChild(parent *blockchain.Block) *blockchain.Block {
+	header := blockchain.BlockHeader{
+		Version:   blockchain.BlockVersion,
+		Height:    parent.Header.Height + 1,
+		Timestamp: parent.Header.Timestamp + 1,
+		PrevHash:  parent.Hash,
+		Bits:      parent.Header.Bits,
+	}
+	return blockchain.NewBlock([]*blockchain.Transaction{newCoinbase()}, header)
+}
+
+func TestReorgInvalidDescendants(t *testing.T) {
+	chainA, chainB := newForks(t)
+	defer chainA.DB.Close()
+	defer chainB.DB.Close()
+
+	assert.Equal(t, nil, chainA.AddBlock([]*blockchain.Transaction{newCoinbase()}))
+	assert.Equal(t, nil, chainA.AddBlock([]*blockchain.Transaction{newCoinbase()}))
+	tipA := chainA.LastHash
+
+	// the second block of branch B pays too much, but it's only detected when its child
+	// makes branch B heavier than branch A
+	first := mineBlock(chainB, []*blockchain.Transaction{newCoinbase()})
+	assert.Equal(t, nil, chainB.AcceptBlock(first))
+	cbtx, err := blockchain.NewCoinbaseTx(address1, "", blockchain.InitialSubsidy+1)
+	assert.Equal(t, nil, err)
+	invalid := mineBlock(chainB, []*blockchain.Transaction{cbtx})
+	child := mineChild(invalid)
+	grandchild := mineChild(child)
+
+	assert.Equal(t, nil, chainA.AcceptBlock(first))
+	assert.Equal(t, nil, chainA.AcceptBlock(invalid))
+	assert.ErrorIs(t, chainA.AcceptBlock(child), blockchain.ErrBadCoinbase)
+	assert.Equal(t, tipA, chainA.LastHash)
+
+	// the branch is flagged, so its blocks and headers are rejected without connecting
+	// the invalid block again
+	assert.ErrorIs(t, chainA.AcceptBlock(child), blockchain.ErrInvalidChain)
+	assert.ErrorIs(t, chainA.AcceptBlock(grandchild), blockchain.ErrInvalidChain)
+	assert.ErrorIs(t, chainA.AddHeaders([]blockchain.BlockHeader{grandchild.Header}), blockchain.ErrInvalidChain)
+	assert.Equal(t, tipA, chainA.LastHash)
+	balance1, _ := balances(chainA)
+	assert.Equal(t, 300, balance1)
+}
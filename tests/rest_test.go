@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"jotacoin/pkg/api"
+	"jotacoin/pkg/blockchain"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// restGet gets the path and decodes the response into result, returning the status
+func restGet(url string, result any) int {
+	resp, err := http.Get(url)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	if result != nil {
+		err = json.NewDecoder(resp.Body).Decode(result)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestREST(t *testing.T) {
+	chain, err := blockchain.NewBlockchainAt(t.TempDir(), address1)
+	if err != nil {
+		panic(err)
+	}
+	defer chain.DB.Close()
+	mempool, err := blockchain.NewMempool(chain)
+	assert.Equal(t, nil, err)
+	server := httptest.NewServer(api.NewREST(chain, mempool, nil))
+	defer server.Close()
+
+	genesisHash := hex.EncodeToString(chain.LastHash)
+	tx, err := blockchain.NewTransaction(address1, address2, 10, blockchain.TxOptions{Fee: 1}, chain)
+	assert.Equal(t, nil, err)
+	_, err = mempool.Add(tx)
+	assert.Equal(t, nil, err)
+	block, err := mempool.BlockTemplate(address1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, blockchain.NewMiner(0).Mine(context.Background(), block))
+	assert.Equal(t, nil, chain.AcceptBlock(block))
+	blockHash := hex.EncodeToString(block.Hash)
+	txHash := hex.EncodeToString(tx.HashID)
+
+	var tip api.TipView
+	assert.Equal(t, http.StatusOK, restGet(server.URL+"/tip", &tip))
+	assert.Equal(t, api.TipView{Height: 1, Hash: blockHash}, tip)
+
+	var blockView api.BlockView
+	assert.Equal(t, http.StatusOK, restGet(server.URL+"/blocks/"+genesisHash, &blockView))
+	assert.Equal(t, 0, blockView.Height)
+	assert.Equal(t, 2, blockView.Confirmations)
+	assert.Equal(t, http.StatusOK, restGet(server.URL+"/blocks/height/1", &blockView))
+	assert.Equal(t, blockHash, blockView.Hash)
+	assert.Equal(t, 2, len(blockView.Transactions))
+
+	var txView api.TxView
+	assert.Equal(t, http.StatusOK, restGet(server.URL+"/tx/"+txHash, &txView))
+	assert.Equal(t, blockHash, txView.BlockHash)
+	assert.Equal(t, 1, txView.Confirmations)
+
+	var utxos []api.UTXOView
+	assert.Equal(t, http.StatusOK, restGet(server.URL+"/address/"+address2+"/utxos", &utxos))
+	assert.Equal(t, []api.UTXOView{{TxHash: txHash, OutIdx: 0, Value: 10}}, utxos)
+
+	// address1 got the genesis subsidy, spent it and got the change and the new subsidy
+	var history api.HistoryPage
+	assert.Equal(t, http.StatusOK, restGet(server.URL+"/address/"+address1+"/history", &history))
+	assert.Equal(t, 3, history.Total)
+	assert.Equal(t, 3, len(history.Entries))
+	assert.Equal(t, genesisHash, history.Entries[0].BlockHash)
+	assert.Equal(t, blockchain.InitialSubsidy, history.Entries[0].Received)
+	assert.Equal(t, api.HistoryView{
+		TxHash:    txHash,
+		BlockHash: blockHash,
+		Height:    1,
+		Received:  blockchain.InitialSubsidy - 11,
+		Sent:      blockchain.InitialSubsidy,
+		Balance:   2*blockchain.InitialSubsidy - 10, // the coinbase got the fee
+	}, history.Entries[2])
+	assert.Equal(t, http.StatusOK, restGet(server.URL+"/address/"+address1+"/history?skip=1&count=1", &history))
+	assert.Equal(t, 3, history.Total)
+	assert.Equal(t, 1, len(history.Entries))
+	assert.Equal(t, 2*blockchain.InitialSubsidy+1, history.Entries[0].Balance)
+
+	// errors
+	assert.Equal(t, http.StatusNotFound, restGet(server.URL+"/blocks/00", nil))
+	assert.Equal(t, http.StatusBadRequest, restGet(server.URL+"/blocks/zz", nil))
+	assert.Equal(t, http.StatusNotFound, restGet(server.URL+"/blocks/height/5", nil))
+	assert.Equal(t, http.StatusBadRequest, restGet(server.URL+"/blocks/height/x", nil))
+	assert.Equal(t, http.StatusNotFound, restGet(server.URL+"/tx/00", nil))
+	assert.Equal(t, http.StatusBadRequest, restGet(server.URL+"/address/abc/utxos", nil))
+	assert.Equal(t, http.StatusBadRequest, restGet(server.URL+"/address/"+address1+"/history?skip=-1", nil))
+	assert.Equal(t, http.StatusNotFound, restGet(server.URL+"/unknown", nil))
+
+	resp, err := http.Post(server.URL+"/tip", "application/json", nil)
+	assert.Equal(t, nil, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
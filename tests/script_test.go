@@ -0,0 +1,241 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"jotacoin/pkg/blockchain"
+	"jotacoin/pkg/wallet"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadWallet(address string) *wallet.Wallet {
+	wallets, err := wallet.LoadFile()
+	if err != nil {
+		panic(err)
+	}
+	return wallets.GetWallet(address)
+}
+
+func addressHash(address string) []byte {
+	pubKeyHash, err := wallet.AddressToPubKeyHash(address)
+	if err != nil {
+		panic(err)
+	}
+	return pubKeyHash
+}
+
+// payToScript adds a block with a transaction from address1 that pays 5 coins to the
+// locking script and returns the transaction
+func payToScript(chain *blockchain.Blockchain, script []byte) *blockchain.Transaction {
+	tx := newSignedTx(chain)
+	tx.Outputs[0] = blockchain.TxOutput{Value: 5, Script: script}
+	resign(tx, prevOutputs(chain, tx))
+	err := chain.AddBlock([]*blockchain.Transaction{newCoinbase(), tx})
+	if err != nil {
+		panic(err)
+	}
+	return tx
+}
+
+// newScriptSpend creates a transaction that spends the first output of prevTx, which
+// pays 5 coins, to address1
+func newScriptSpend(prevTx *blockchain.Transaction) *blockchain.Transaction {
+	out, err := blockchain.NewTxOutput(5, address1)
+	if err != nil {
+		panic(err)
+	}
+	return &blockchain.Transaction{
+		Version: blockchain.TxVersion,
+		Inputs:  []blockchain.TxInput{{PrevTxHash: prevTx.HashID, OutIdx: 0}},
+		Outputs: []blockchain.TxOutput{*out},
+	}
+}
+
+// unlock sets the unlocking script of the input of tx with the values pushed before the
+// signature of w and its public key
+func unlock(tx *blockchain.Transaction, prevOut blockchain.TxOutput, w *wallet.Wallet, values ...[]byte) {
+	signature, err := tx.InputSignature(0, w.PrivateKey, prevOut, blockchain.SigHashAll)
+	if err != nil {
+		panic(err)
+	}
+	b := &blockchain.ScriptBuilder{}
+	b.AddData(signature).AddData(w.PublicKey)
+	for _, value := range values {
+		b.AddData(value)
+	}
+	tx.Inputs[0].Script = b.Script()
+	tx.HashID, err = tx.Hash()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	pubKeyHash := addressHash(address1)
+	script := blockchain.P2PKHScript(pubKeyHash)
+	disasm, err := blockchain.DisasmScript(script)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "DUP HASH160 "+hex.EncodeToString(pubKeyHash)+" EQUALVERIFY CHECKSIG", disasm)
+
+	disasm, err = blockchain.DisasmScript(blockchain.TimeLockScript(300, pubKeyHash))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "012c CHECKLOCKTIMEVERIFY DROP DUP HASH160 "+hex.EncodeToString(pubKeyHash)+
+		" EQUALVERIFY CHECKSIG", disasm)
+
+	assert.True(t, blockchain.IsPushOnly(blockchain.P2PKHUnlockingScript([]byte{1}, []byte{2})))
+	assert.False(t, blockchain.IsPushOnly(script))
+
+	// truncated pushes, unknown opcodes and too big values are rejected
+	for _, bad := range [][]byte{{0x02, 0x01}, {byte(blockchain.OpPushData1)}, {0xff}} {
+		_, err = blockchain.ParseScript(bad)
+		assert.ErrorIs(t, err, blockchain.ErrBadScript)
+	}
+	big := (&blockchain.ScriptBuilder{}).AddData(make([]byte, blockchain.MaxScriptElementSize+1)).Script()
+	_, err = blockchain.ParseScript(big)
+	assert.ErrorIs(t, err, blockchain.ErrBadScript)
+	ops := make([]blockchain.Opcode, blockchain.MaxScriptOps+1)
+	for i := range ops {
+		ops[i] = blockchain.OpDup
+	}
+	_, err = blockchain.ParseScript((&blockchain.ScriptBuilder{}).AddOp(ops...).Script())
+	assert.ErrorIs(t, err, blockchain.ErrBadScript)
+}
+
+func TestP2PKHScript(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+	w1 := loadWallet(address1)
+
+	// the explicit P2PKH scripts are equivalent to the outputs and inputs without script
+	tx := newSignedTx(chain)
+	prevOuts := prevOutputs(chain, tx)
+	out := &tx.Outputs[0]
+	out.Script = blockchain.P2PKHScript(out.PubKeyHash)
+	out.PubKeyHash = nil
+	assert.True(t, out.IsP2PKH())
+	assert.Equal(t, addressHash(address2), out.AddressHash())
+
+	for i := range tx.Inputs {
+		signature, err := tx.InputSignature(i, w1.PrivateKey, prevOuts[i], blockchain.SigHashAll)
+		assert.Equal(t, nil, err)
+		tx.Inputs[i].Script = blockchain.P2PKHUnlockingScript(signature, w1.PublicKey)
+		tx.Inputs[i].Signature, tx.Inputs[i].PubKey = nil, nil
+	}
+	assert.True(t, tx.Verify(prevOuts))
+
+	var err error
+	tx.HashID, err = tx.Hash()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, chain.AddBlock([]*blockchain.Transaction{newCoinbase(), tx}))
+	_, balance2 := balances(chain)
+	assert.Equal(t, 5, balance2)
+
+	// the scripts can't be mixed with the P2PKH fields
+	bad := newSignedTx(chain)
+	bad.Inputs[0].Script = blockchain.P2PKHUnlockingScript(bad.Inputs[0].Signature, bad.Inputs[0].PubKey)
+	bad.HashID, err = bad.Hash()
+	assert.Equal(t, nil, err)
+	_, err = chain.ValidateTransaction(bad)
+	assert.ErrorIs(t, err, blockchain.ErrBadTxScript)
+}
+
+func TestHashLockScript(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+	w2 := loadWallet(address2)
+
+	preimage := []byte("secret")
+	hash := sha256.Sum256(preimage)
+	prevTx := payToScript(chain, blockchain.HashLockScript(hash[:], addressHash(address2)))
+	prevOut := prevTx.Outputs[0]
+
+	// a wrong preimage or a signature of another key can't unlock it
+	tx := newScriptSpend(prevTx)
+	unlock(tx, prevOut, w2, []byte("guess"))
+	_, err := chain.ValidateTransaction(tx)
+	assert.ErrorIs(t, err, blockchain.ErrBadSignature)
+	unlock(tx, prevOut, loadWallet(address1), preimage)
+	_, err = chain.ValidateTransaction(tx)
+	assert.ErrorIs(t, err, blockchain.ErrBadSignature)
+
+	unlock(tx, prevOut, w2, preimage)
+	fee, err := chain.ValidateTransaction(tx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, fee)
+	assert.Equal(t, nil, chain.AddBlock([]*blockchain.Transaction{newCoinbase(), tx}))
+}
+
+func TestTimeLockScript(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+	w2 := loadWallet(address2)
+
+	height, err := chain.Height()
+	assert.Equal(t, nil, err)
+	lockHeight := height + 3
+	prevTx := payToScript(chain, blockchain.TimeLockScript(uint32(lockHeight), addressHash(address2)))
+	prevOut := prevTx.Outputs[0]
+
+	// the lock time of the spending transaction must reach the height of the script
+	tx := newScriptSpend(prevTx)
+	tx.LockTime = lockHeight - 1
+	unlock(tx, prevOut, w2)
+	_, err = chain.ValidateTransaction(tx)
+	assert.ErrorIs(t, err, blockchain.ErrBadSignature)
+
+	// and it can't be in a block until that height
+	tx.LockTime = lockHeight
+	unlock(tx, prevOut, w2)
+	_, err = chain.ValidateTransaction(tx)
+	assert.ErrorIs(t, err, blockchain.ErrNonFinalTx)
+	assert.ErrorIs(t, chain.AddBlock([]*blockchain.Transaction{newCoinbase(), tx}), blockchain.ErrNonFinalTx)
+
+	assert.Equal(t, nil, chain.AddBlock([]*blockchain.Transaction{newCoinbase()}))
+	_, err = chain.ValidateTransaction(tx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, chain.AddBlock([]*blockchain.Transaction{newCoinbase(), tx}))
+}
+
+func TestCheckMultiSig(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+	w1, w2 := loadWallet(address1), loadWallet(address2)
+
+	// 2 of 2 multisig
+	b := &blockchain.ScriptBuilder{}
+	b.AddInt(2).AddData(w1.PublicKey).AddData(w2.PublicKey).AddInt(2).AddOp(blockchain.OpCheckMultiSig)
+	prevTx := payToScript(chain, b.Script())
+	prevOut := prevTx.Outputs[0]
+	assert.False(t, prevOut.IsP2PKH())
+
+	tx := newScriptSpend(prevTx)
+	sig1, err := tx.InputSignature(0, w1.PrivateKey, prevOut, blockchain.SigHashAll)
+	assert.Equal(t, nil, err)
+	sig2, err := tx.InputSignature(0, w2.PrivateKey, prevOut, blockchain.SigHashAll)
+	assert.Equal(t, nil, err)
+
+	prevOuts := []blockchain.TxOutput{prevOut}
+	tests := []struct {
+		signatures [][]byte
+		valid      bool
+	}{
+		{[][]byte{sig1, sig2}, true},
+		{[][]byte{sig2, sig1}, false},
+		{[][]byte{sig1, sig1}, false},
+		{[][]byte{sig1}, false},
+	}
+	for _, test := range tests {
+		b := &blockchain.ScriptBuilder{}
+		for _, signature := range test.signatures {
+			b.AddData(signature)
+		}
+		tx.Inputs[0].Script = b.Script()
+		assert.Equal(t, test.valid, tx.Verify(prevOuts))
+	}
+
+	// the unlocking script can't run opcodes
+	tx.Inputs[0].Script = (&blockchain.ScriptBuilder{}).AddData(sig1).AddData(sig2).AddOp(blockchain.OpDup).Script()
+	assert.False(t, tx.Verify(prevOuts))
+}
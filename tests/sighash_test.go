@@ -0,0 +1,129 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"jotacoin/pkg/blockchain"
+	"jotacoin/pkg/wallet"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTxWithSigHash creates a transaction with two inputs and two outputs (the payment and
+// the change) signed with the sighash type
+func newTxWithSigHash(
+	chain *blockchain.Blockchain, hashType blockchain.SigHashType,
+) (*blockchain.Transaction, []blockchain.TxOutput) {
+	opts := blockchain.TxOptions{SigHashType: hashType}
+	tx, err := blockchain.NewTransaction(address1, address2, blockchain.InitialSubsidy+1, opts, chain)
+	if err != nil {
+		panic(err)
+	}
+	return tx, prevOutputs(chain, tx)
+}
+
+func TestParseSigHashType(t *testing.T) {
+	for _, s := range []string{"ALL", "NONE", "SINGLE", "ALL|ANYONECANPAY", "SINGLE|ANYONECANPAY"} {
+		hashType, err := blockchain.ParseSigHashType(s)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, s, hashType.String())
+	}
+	hashType, err := blockchain.ParseSigHashType("none|anyonecanpay")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, blockchain.SigHashNone|blockchain.SigHashAnyoneCanPay, hashType)
+
+	for _, s := range []string{"", "ANY", "ANYONECANPAY", "ALL|NONE"} {
+		_, err = blockchain.ParseSigHashType(s)
+		assert.ErrorIs(t, err, blockchain.ErrBadSigHashType)
+	}
+}
+
+func TestSigHashTypes(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+
+	// ALL commits to every output and to the position of the inputs
+	tx, prevOuts := newTxWithSigHash(chain, blockchain.SigHashAll)
+	assert.Equal(t, blockchain.SigHashAll, tx.Inputs[0].SigHashType())
+	assert.True(t, tx.Verify(prevOuts))
+	tx.Outputs[1].Value--
+	assert.False(t, tx.Verify(prevOuts))
+	tx.Outputs[1].Value++
+	tx.Inputs[0], tx.Inputs[1] = tx.Inputs[1], tx.Inputs[0]
+	assert.False(t, tx.Verify([]blockchain.TxOutput{prevOuts[1], prevOuts[0]}))
+
+	// NONE lets anyone change the outputs, but not the inputs
+	tx, prevOuts = newTxWithSigHash(chain, blockchain.SigHashNone)
+	tx.Outputs[0].Value--
+	tx.Outputs = append(tx.Outputs, tx.Outputs[1])
+	assert.True(t, tx.Verify(prevOuts))
+	tx.Inputs[1].OutIdx++
+	assert.False(t, tx.Verify(prevOuts))
+
+	// SINGLE only commits to the output with the index of the input
+	tx, prevOuts = newTxWithSigHash(chain, blockchain.SigHashSingle)
+	tx.Outputs = append(tx.Outputs, tx.Outputs[1])
+	assert.True(t, tx.Verify(prevOuts))
+	tx.Outputs[0].Value--
+	assert.False(t, tx.Verify(prevOuts))
+
+	// an unknown type is never valid
+	tx, prevOuts = newTxWithSigHash(chain, blockchain.SigHashAll)
+	tx.Inputs[0].Signature[len(tx.Inputs[0].Signature)-1] = 0x04
+	assert.False(t, tx.Verify(prevOuts))
+}
+
+func TestSigHashSingleWithoutOutput(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+
+	// the three outputs of address1 are needed, but there are only two outputs
+	opts := blockchain.TxOptions{SigHashType: blockchain.SigHashSingle}
+	_, err := blockchain.NewTransaction(address1, address2, 2*blockchain.InitialSubsidy+1, opts, chain)
+	assert.True(t, errors.Is(err, blockchain.ErrSigHashSingle))
+}
+
+func TestSigHashAnyoneCanPay(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+	wallets, err := wallet.LoadFile()
+	assert.Equal(t, nil, err)
+	w1 := wallets.GetWallet(address1)
+
+	hashType := blockchain.SigHashAll | blockchain.SigHashAnyoneCanPay
+	tx, prevOuts := newTxWithSigHash(chain, hashType)
+
+	// the inputs can be reordered
+	tx.Inputs[0], tx.Inputs[1] = tx.Inputs[1], tx.Inputs[0]
+	prevOuts[0], prevOuts[1] = prevOuts[1], prevOuts[0]
+	assert.True(t, tx.Verify(prevOuts))
+
+	// and anyone can add an input signed by itself, e.g. to fund the payment
+	utxos, err := chain.ListUTXO(address1)
+	assert.Equal(t, nil, err)
+	for _, utxo := range utxos {
+		if bytes.Equal(utxo.TxHash, tx.Inputs[0].PrevTxHash) && utxo.OutIdx == tx.Inputs[0].OutIdx ||
+			bytes.Equal(utxo.TxHash, tx.Inputs[1].PrevTxHash) && utxo.OutIdx == tx.Inputs[1].OutIdx {
+			continue
+		}
+		tx.Inputs = append(tx.Inputs, blockchain.TxInput{
+			PrevTxHash: utxo.TxHash, OutIdx: utxo.OutIdx, PubKey: w1.PublicKey,
+		})
+		prevOuts = append(prevOuts, utxo.Output)
+		break
+	}
+	assert.Equal(t, 3, len(tx.Inputs))
+	assert.Equal(t, nil, tx.SignInput(2, w1.PrivateKey, prevOuts[2], hashType))
+	assert.True(t, tx.Verify(prevOuts))
+
+	tx.HashID, err = tx.Hash()
+	assert.Equal(t, nil, err)
+	fee, err := chain.ValidateTransaction(tx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3*blockchain.InitialSubsidy-tx.Outputs[0].Value-tx.Outputs[1].Value, fee)
+
+	// but the outputs are still committed
+	tx.Outputs[0].Value++
+	assert.False(t, tx.Verify(prevOuts))
+}
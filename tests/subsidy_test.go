@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"errors"
+	"jotacoin/pkg/blockchain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubsidySchedule(t *testing.T) {
+	halvingInterval := blockchain.HalvingInterval
+	blockchain.HalvingInterval = 10
+	defer func() { blockchain.HalvingInterval = halvingInterval }()
+
+	assert.Equal(t, 100, blockchain.BlockSubsidy(0))
+	assert.Equal(t, 100, blockchain.BlockSubsidy(9))
+	assert.Equal(t, 50, blockchain.BlockSubsidy(10))
+	assert.Equal(t, 25, blockchain.BlockSubsidy(29))
+	assert.Equal(t, 0, blockchain.BlockSubsidy(10*7))
+
+	assert.Equal(t, 100, blockchain.Supply(0))
+	assert.Equal(t, 1000, blockchain.Supply(9))
+	assert.Equal(t, 1050, blockchain.Supply(10))
+	// 10 * (100 + 50 + 25 + 12 + 6 + 3 + 1)
+	assert.Equal(t, 1970, blockchain.MaxSupply())
+	assert.Equal(t, blockchain.MaxSupply(), blockchain.Supply(1000))
+}
+
+func TestCoinbaseFollowsSubsidy(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+
+	halvingInterval := blockchain.HalvingInterval
+	blockchain.HalvingInterval = 1
+	defer func() { blockchain.HalvingInterval = halvingInterval }()
+
+	height, err := chain.Height()
+	assert.Equal(t, nil, err)
+	subsidy := blockchain.BlockSubsidy(height + 1)
+	assert.Less(t, subsidy, blockchain.InitialSubsidy)
+
+	cbtx, err := blockchain.NewCoinbaseTx(address1, "", subsidy+1)
+	assert.Equal(t, nil, err)
+	block, err := chain.MineBlock([]*blockchain.Transaction{cbtx})
+	assert.Equal(t, nil, err)
+	assert.True(t, errors.Is(chain.ValidateBlock(block), blockchain.ErrBadCoinbase))
+
+	cbtx, err = blockchain.NewCoinbaseTx(address1, "", subsidy)
+	assert.Equal(t, nil, err)
+	block, err = chain.MineBlock([]*blockchain.Transaction{cbtx})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, chain.ValidateBlock(block))
+}
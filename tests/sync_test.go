@@ -0,0 +1,179 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"jotacoin/pkg/blockchain"
+	"jotacoin/pkg/network"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// chainBlocks returns the blocks of the main chain in ascending order
+func chainBlocks(chain *blockchain.Blockchain) []*blockchain.Block {
+	var blocks []*blockchain.Block
+	iter := chain.Iterator()
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			panic(err)
+		}
+		blocks = append([]*blockchain.Block{block}, blocks...)
+		if block.IsGenesis() {
+			return blocks
+		}
+	}
+}
+
+func openNode(path string) *network.Server {
+	chain, err := blockchain.OpenBlockchainAt(path)
+	if err != nil {
+		panic(err)
+	}
+	mempool, err := blockchain.NewMempool(chain)
+	if err != nil {
+		panic(err)
+	}
+
+	server := network.NewServer("localhost:0", chain, mempool)
+	err = server.Start()
+	if err != nil {
+		panic(err)
+	}
+	return server
+}
+
+func TestHeadersFirstSync(t *testing.T) {
+	// two full nodes with a chain long enough to have a difficulty retarget
+	pathA, pathB, pathC, pathD := t.TempDir(), t.TempDir(), t.TempDir(), t.TempDir()
+	chain, err := blockchain.NewBlockchainAt(pathA, address1)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < blockchain.RetargetInterval+5; i++ {
+		err = chain.AddBlock([]*blockchain.Transaction{newCoinbase()})
+		if err != nil {
+			panic(err)
+		}
+	}
+	blocks := chainBlocks(chain)
+	chain.DB.Close()
+	copyDir(pathA, pathC)
+
+	// node B was stopped after downloading all the headers but only some blocks
+	partial, err := blockchain.OpenBlockchainAt(pathB)
+	assert.Equal(t, nil, err)
+	var headers []blockchain.BlockHeader
+	for _, block := range blocks {
+		headers = append(headers, block.Header)
+	}
+	assert.Equal(t, nil, partial.AddHeaders(headers))
+	for _, block := range blocks[:10] {
+		assert.Equal(t, nil, partial.AcceptBlock(block))
+	}
+	partial.DB.Close()
+
+	partial, err = blockchain.OpenBlockchainAt(pathB)
+	assert.Equal(t, nil, err)
+	best, _, err := partial.BestHeader()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(blocks)-1, best.Height)
+	missing, err := partial.MissingBlocks(len(blocks))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(blocks)-10, len(missing))
+	assert.Equal(t, blocks[10].Hash, missing[0].Hash())
+	partial.DB.Close()
+
+	// a tampered header is rejected
+	tampered, err := blockchain.OpenBlockchainAt(t.TempDir())
+	assert.Equal(t, nil, err)
+	headers[3].Nonce++
+	assert.NotEqual(t, nil, tampered.AddHeaders(headers))
+	tampered.DB.Close()
+
+	nodeA := openNode(pathA)
+	defer stopNode(nodeA)
+	nodeC := openNode(pathC)
+	defer stopNode(nodeC)
+
+	// node B resumes its sync and node D (with an empty database) syncs from scratch
+	nodeB := openNode(pathB)
+	defer stopNode(nodeB)
+	nodeD := openNode(pathD)
+	defer stopNode(nodeD)
+	progress, err := nodeD.SyncProgress()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, -1, progress.BlockHeight)
+
+	for _, node := range []*network.Server{nodeB, nodeD} {
+		assert.Equal(t, nil, node.Connect(nodeA.Addr))
+		assert.Equal(t, nil, node.Connect(nodeC.Addr))
+	}
+	for _, node := range []*network.Server{nodeB, nodeD} {
+		assert.Eventually(t, func() bool {
+			return bytes.Equal(nodeA.LastHash(), node.LastHash())
+		}, 20*time.Second, 10*time.Millisecond)
+
+		progress, err := node.SyncProgress()
+		assert.Equal(t, nil, err)
+		assert.True(t, progress.Synced())
+		assert.Equal(t, len(blocks)-1, progress.BlockHeight)
+		assert.Equal(t, 2, progress.Peers)
+	}
+
+	// the downloaded chain has the same UTXO set
+	balanceA, _ := balances(nodeA.Chain)
+	balanceD, _ := balances(nodeD.Chain)
+	assert.Equal(t, balanceA, balanceD)
+}
+
+func TestSyncInvalidBlock(t *testing.T) {
+	path := t.TempDir()
+	chain, err := blockchain.NewBlockchainAt(path, address1)
+	if err != nil {
+		panic(err)
+	}
+	chain.DB.Close()
+	node := startNode(path)
+	defer stopNode(node)
+	genesis := node.LastHash()
+
+	// a peer announces a branch whose first block pays too much
+	cbtx, err := blockchain.NewCoinbaseTx(address1, "", blockchain.InitialSubsidy+1)
+	assert.Equal(t, nil, err)
+	invalid := mineBlock(node.Chain, []*blockchain.Transaction{cbtx})
+	child := mineChild(invalid)
+	conn := dialNode(t, node)
+	defer conn.Close()
+	headers := struct{ Headers []blockchain.BlockHeader }{
+		[]blockchain.BlockHeader{invalid.Header, child.Header},
+	}
+	assert.Equal(t, nil, writeRawMessage(conn, "headers", headers))
+	assert.Eventually(t, func() bool {
+		best, _, err := node.Chain.BestHeader()
+		return err == nil && best.Height == 2
+	}, 10*time.Second, 10*time.Millisecond)
+
+	// when it sends the block, the peer is disconnected and the branch isn't requested
+	// again
+	assert.Equal(t, nil, writeRawMessage(conn, "block", struct{ Block *blockchain.Block }{invalid}))
+	assert.Eventually(t, func() bool {
+		return len(node.Peers()) == 0
+	}, 10*time.Second, 10*time.Millisecond)
+
+	_, bestHash, err := node.Chain.BestHeader()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, genesis, bestHash)
+	missing, err := node.Chain.MissingBlocks(10)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(missing))
+	progress, err := node.SyncProgress()
+	assert.Equal(t, nil, err)
+	assert.True(t, progress.Synced())
+	assert.True(t, errors.Is(node.SubmitBlock(child), blockchain.ErrInvalidChain))
+	err = node.Chain.AddHeaders([]blockchain.BlockHeader{mineChild(child).Header})
+	assert.True(t, errors.Is(err, blockchain.ErrInvalidChain))
+	assert.Equal(t, genesis, node.LastHash())
+}
@@ -8,14 +8,42 @@ import (
 )
 
 func TestNewTransaction(t *testing.T) {
-	chain, err := blockchain.ContinueBlockchain()
-	if err != nil {
-		panic(err)
-	}
+	chain := newTestChain()
 	defer chain.DB.Close()
-	tx, err := blockchain.NewTransaction(address1, address2, 1, chain)
+	tx, err := blockchain.NewTransaction(address1, address2, 1, blockchain.TxOptions{}, chain)
 	assert.Equal(t, nil, err)
 
-	isValid := tx.Verify()
+	prevOuts, err := chain.PrevOutputs(tx)
+	assert.Equal(t, nil, err)
+	isValid := tx.Verify(prevOuts)
 	assert.Equal(t, true, isValid)
 }
+
+func TestSigHash(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+	tx, err := blockchain.NewTransaction(address1, address2, blockchain.InitialSubsidy+1, blockchain.TxOptions{}, chain)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(tx.Inputs))
+	prevOuts, err := chain.PrevOutputs(tx)
+	assert.Equal(t, nil, err)
+	assert.True(t, tx.Verify(prevOuts))
+
+	// the signatures are bound to the value and the lock of the spent outputs
+	badValue := append([]blockchain.TxOutput{}, prevOuts...)
+	badValue[0].Value++
+	assert.False(t, tx.Verify(badValue))
+	badLock := append([]blockchain.TxOutput{}, prevOuts...)
+	badLock[1].PubKeyHash = badLock[1].PubKeyHash[1:]
+	assert.False(t, tx.Verify(badLock))
+	assert.False(t, tx.Verify(prevOuts[:1]))
+
+	// and to the input that makes them
+	tx.Inputs[0].Signature, tx.Inputs[1].Signature = tx.Inputs[1].Signature, tx.Inputs[0].Signature
+	assert.False(t, tx.Verify(prevOuts))
+	tx.Inputs[0].Signature, tx.Inputs[1].Signature = tx.Inputs[1].Signature, tx.Inputs[0].Signature
+
+	// and to all the outputs
+	tx.Outputs[len(tx.Outputs)-1].Value--
+	assert.False(t, tx.Verify(prevOuts))
+}
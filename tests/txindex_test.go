@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"errors"
+	"jotacoin/pkg/blockchain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxIndex(t *testing.T) {
+	chainA, chainB := newForks(t)
+	defer chainA.DB.Close()
+	defer chainB.DB.Close()
+
+	enabled, err := chainA.TxIndexEnabled()
+	assert.Equal(t, nil, err)
+	assert.False(t, enabled)
+	genesis, err := chainA.GetBlock(chainA.LastHash)
+	assert.Equal(t, nil, err)
+
+	// the index is built with the blocks that are already in the chain
+	assert.Equal(t, nil, chainA.ReindexTransactions())
+	enabled, err = chainA.TxIndexEnabled()
+	assert.Equal(t, nil, err)
+	assert.True(t, enabled)
+	tx, block, err := chainA.FindTransaction(genesis.Transactions[0].HashID)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, genesis.Hash, block.Hash)
+	assert.Equal(t, genesis.Transactions[0].HashID, tx.HashID)
+
+	// and it's updated when the blocks are connected
+	signedTx := newSignedTx(chainA)
+	assert.Equal(t, nil, chainA.AddBlock([]*blockchain.Transaction{newCoinbase(), signedTx}))
+	tx, block, err = chainA.FindTransaction(signedTx.HashID)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, chainA.LastHash, block.Hash)
+	assert.Equal(t, signedTx.HashID, tx.HashID)
+
+	// and disconnected
+	var branchB []*blockchain.Block
+	for i := 0; i < 2; i++ {
+		block := mineBlock(chainB, []*blockchain.Transaction{newCoinbase()})
+		assert.Equal(t, nil, chainB.AcceptBlock(block))
+		branchB = append(branchB, block)
+	}
+	assert.Equal(t, nil, chainA.AcceptBlock(branchB[0]))
+	assert.Equal(t, nil, chainA.AcceptBlock(branchB[1]))
+	assert.Equal(t, branchB[1].Hash, chainA.LastHash)
+	_, _, err = chainA.FindTransaction(signedTx.HashID)
+	assert.True(t, errors.Is(err, blockchain.ErrTxNotFound))
+	_, block, err = chainA.FindTransaction(branchB[0].Transactions[0].HashID)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, branchB[0].Hash, block.Hash)
+
+	// without the index, the blocks are searched
+	_, block, err = chainB.FindTransaction(branchB[0].Transactions[0].HashID)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, branchB[0].Hash, block.Hash)
+	_, _, err = chainB.FindTransaction(signedTx.HashID)
+	assert.True(t, errors.Is(err, blockchain.ErrTxNotFound))
+}
@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"jotacoin/pkg/blockchain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReindexUTXO(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+
+	// an output created and spent in the same block isn't in the UTXO set
+	tx := newSignedTx(chain)
+	spend := newScriptSpend(tx)
+	unlock(spend, tx.Outputs[0], loadWallet(address2))
+	err := chain.AddBlock([]*blockchain.Transaction{newCoinbase(), tx, spend})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, chain.GetBalance(address2))
+
+	balance1 := chain.GetBalance(address1)
+	balance2 := chain.GetBalance(address2)
+	utxos1 := chain.FindUTXO(address1)
+
+	err = chain.ReindexUTXO()
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, balance1, chain.GetBalance(address1))
+	assert.Equal(t, balance2, chain.GetBalance(address2))
+	assert.ElementsMatch(t, utxos1, chain.FindUTXO(address1))
+
+	acc, spendable := chain.FindSpendableTxOutputs(address1, 1)
+	assert.GreaterOrEqual(t, acc, 1)
+	assert.NotEmpty(t, spendable)
+}
+
+func TestMissingUTXOSet(t *testing.T) {
+	chain := newTestChain()
+	assert.Equal(t, nil, chain.AddBlock([]*blockchain.Transaction{newCoinbase(), newSignedTx(chain)}))
+	balance1, balance2 := balances(chain)
+
+	// a chain stored without UTXO set gets it when it's opened
+	err := chain.DB.DropPrefix([]byte("utxo-"), []byte("utxoaddr-"))
+	assert.Equal(t, nil, err)
+	reopened, err := blockchain.OpenBlockchainWithStore(chain.DB)
+	assert.Equal(t, nil, err)
+	defer reopened.DB.Close()
+
+	reopenedBalance1, reopenedBalance2 := balances(reopened)
+	assert.Equal(t, balance1, reopenedBalance1)
+	assert.Equal(t, balance2, reopenedBalance2)
+	_, err = blockchain.NewTransaction(address1, address2, 5, blockchain.TxOptions{}, reopened)
+	assert.Equal(t, nil, err)
+}
@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"errors"
+	"jotacoin/pkg/blockchain"
+	"jotacoin/pkg/wallet"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newSignedTx(chain *blockchain.Blockchain) *blockchain.Transaction {
+	tx, err := blockchain.NewTransaction(address1, address2, 5, blockchain.TxOptions{}, chain)
+	if err != nil {
+		panic(err)
+	}
+	return tx
+}
+
+func prevOutputs(chain *blockchain.Blockchain, tx *blockchain.Transaction) []blockchain.TxOutput {
+	prevOuts, err := chain.PrevOutputs(tx)
+	if err != nil {
+		panic(err)
+	}
+	return prevOuts
+}
+
+// resign signs the transaction again after it was tampered and updates its hash
+func resign(tx *blockchain.Transaction, prevOuts []blockchain.TxOutput) {
+	wallets, err := wallet.LoadFile()
+	if err != nil {
+		panic(err)
+	}
+	err = tx.Sign(wallets.GetWallet(address1).PrivateKey, prevOuts, blockchain.SigHashAll)
+	if err != nil {
+		panic(err)
+	}
+	tx.HashID, err = tx.Hash()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func newCoinbase() *blockchain.Transaction {
+	cbtx, err := blockchain.NewCoinbaseTx(address1, "", blockchain.InitialSubsidy)
+	if err != nil {
+		panic(err)
+	}
+	return cbtx
+}
+
+func mineBlock(chain *blockchain.Blockchain, txs []*blockchain.Transaction) *blockchain.Block {
+	block, err := chain.MineBlock(txs)
+	if err != nil {
+		panic(err)
+	}
+	return block
+}
+
+func TestValidateBlock(t *testing.T) {
+	chain := newTestChain()
+	defer chain.DB.Close()
+
+	valid := mineBlock(chain, []*blockchain.Transaction{newCoinbase(), newSignedTx(chain)})
+	assert.Equal(t, nil, chain.ValidateBlock(valid))
+
+	badPoW := mineBlock(chain, []*blockchain.Transaction{newCoinbase()})
+	badPoW.Header.Nonce++
+	assert.True(t, errors.Is(chain.ValidateBlock(badPoW), blockchain.ErrBadPoW))
+
+	noCoinbase := mineBlock(chain, []*blockchain.Transaction{newSignedTx(chain)})
+	assert.True(t, errors.Is(chain.ValidateBlock(noCoinbase), blockchain.ErrBadCoinbase))
+
+	bigCoinbase := newCoinbase()
+	bigCoinbase.Outputs[0].Value = blockchain.InitialSubsidy + 1
+	bigCoinbase.HashID, _ = bigCoinbase.Hash()
+	block := mineBlock(chain, []*blockchain.Transaction{bigCoinbase})
+	assert.True(t, errors.Is(chain.ValidateBlock(block), blockchain.ErrBadCoinbase))
+
+	tx := newSignedTx(chain)
+	block = mineBlock(chain, []*blockchain.Transaction{newCoinbase(), tx, tx})
+	assert.True(t, errors.Is(chain.ValidateBlock(block), blockchain.ErrDoubleSpend))
+
+	tx = newSignedTx(chain)
+	tx.Outputs[0].Value = 1
+	tx.HashID, _ = tx.Hash()
+	block = mineBlock(chain, []*blockchain.Transaction{newCoinbase(), tx})
+	assert.True(t, errors.Is(chain.ValidateBlock(block), blockchain.ErrBadSignature))
+
+	tx = newSignedTx(chain)
+	prevOuts := prevOutputs(chain, tx)
+	tx.Inputs[0].PrevTxHash = make([]byte, len(tx.Inputs[0].PrevTxHash))
+	resign(tx, prevOuts)
+	block = mineBlock(chain, []*blockchain.Transaction{newCoinbase(), tx})
+	assert.True(t, errors.Is(chain.ValidateBlock(block), blockchain.ErrMissingInput))
+
+	tx = newSignedTx(chain)
+	tx.Outputs[0].Value += 1000
+	resign(tx, prevOutputs(chain, tx))
+	block = mineBlock(chain, []*blockchain.Transaction{newCoinbase(), tx})
+	assert.True(t, errors.Is(chain.ValidateBlock(block), blockchain.ErrValueOverflow))
+
+	block = mineBlock(chain, []*blockchain.Transaction{newCoinbase()})
+	block.Header.Timestamp = 0
+	block.Hash = block.Header.Hash()
+	assert.True(t, errors.Is(chain.ValidateBlock(block), blockchain.ErrBadTimestamp))
+
+	block = mineBlock(chain, []*blockchain.Transaction{newCoinbase()})
+	block.Header.Height++
+	assert.True(t, errors.Is(chain.ValidateBlock(block), blockchain.ErrBadHeader))
+
+	block = mineBlock(chain, []*blockchain.Transaction{newCoinbase()})
+	block.Transactions = append(block.Transactions, newSignedTx(chain))
+	assert.True(t, errors.Is(chain.ValidateBlock(block), blockchain.ErrBadMerkleRoot))
+
+	// invalid blocks are never stored
+	lastHash := chain.LastHash
+	assert.NotEqual(t, nil, chain.AcceptBlock(block))
+	assert.Equal(t, lastHash, chain.LastHash)
+}